@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bitfield/script"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"github.com/0magnet/tinygo-stuff/schematic"
+)
+
+func init() {
+	RootCmd.AddCommand(completionCmd)
+}
+
+var completionCmd = &cobra.Command{
+	Use:   "completion bash|zsh|fish",
+	Short: "shell completion script",
+	Long: `Print a completion script for bash, zsh or fish.
+
+	source <(mcu completion bash)
+	mcu completion zsh > "${fpath[1]}/_mcu"
+	mcu completion fish > ~/.config/fish/completions/mcu.fish
+
+The flags of flash, ef and eval are generated from GOPROG, so the script does
+not list them: it asks mcu each time, with GOPROG as it is then. --target
+completes from tinygo targets, -m from the serial ports present, --dev from the
+removable block devices, --profile from ` + configName + `, and a flag with
+choices from its choices.`,
+	ValidArgs:             []string{"bash", "zsh", "fish"},
+	Args:                  cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+	SilenceErrors:         true,
+	SilenceUsage:          true,
+	DisableSuggestions:    true,
+	DisableFlagsInUseLine: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		w := cmd.OutOrStdout()
+		switch args[0] {
+		case "bash":
+			return RootCmd.GenBashCompletionV2(w, true)
+		case "zsh":
+			return RootCmd.GenZshCompletion(w)
+		default:
+			return RootCmd.GenFishCompletion(w, true)
+		}
+	},
+}
+
+// choicesAnnotation is the pflag annotation an enum flag's choices are kept
+// in, for completion to offer.
+const choicesAnnotation = "mcu:choices"
+
+// registerCompletions gives every flag that takes one of a known set of values
+// a completer. It runs once every command has its flags, which for flash and
+// ef is only after GOPROG has been read.
+func registerCompletions() {
+	completers := map[string]cobra.CompletionFunc{
+		"target":  completeTargets,
+		"ser":     completeSerialPorts,
+		"dev":     completeBlockDevices,
+		"profile": completeProfiles,
+		"export":  cobra.FixedCompletions([]string{"yaml", "json"}, cobra.ShellCompDirectiveNoFileComp),
+	}
+	for _, c := range append(RootCmd.Commands(), RootCmd) {
+		c.Flags().VisitAll(func(f *pflag.Flag) {
+			fn := completers[f.Name]
+			if choices := f.Annotations[choicesAnnotation]; len(choices) > 0 {
+				fn = cobra.FixedCompletions(choices, cobra.ShellCompDirectiveNoFileComp)
+			}
+			if fn != nil {
+				_ = c.RegisterFlagCompletionFunc(f.Name, fn) //nolint:errcheck // the flag was just found on c, which is all it checks
+			}
+		})
+	}
+	for _, c := range []*cobra.Command{evalCmd, efCmd} {
+		_ = c.MarkFlagFilename("config", "yaml", "yml", "json") //nolint:errcheck // every command in the list has --config
+	}
+	_ = schematicCmd.MarkFlagFilename("from", "go")                                                                                                   //nolint:errcheck // schematic always has --from
+	_ = schematicCmd.MarkFlagFilename("file", "yaml", "yml", "json", "toml", "net", "csv")                                                            //nolint:errcheck // and -f
+	_ = schematicCmd.MarkFlagFilename("kicad", "net")                                                                                                 //nolint:errcheck // and --kicad
+	_ = schematicCmd.MarkFlagFilename("parts", "yaml", "yml", "json", "toml")                                                                         //nolint:errcheck // and --parts
+	_ = schematicCmd.RegisterFlagCompletionFunc("dump", cobra.FixedCompletions([]string{"yaml", "json", "toml"}, cobra.ShellCompDirectiveNoFileComp)) //nolint:errcheck // and --dump
+	_ = schematicCmd.RegisterFlagCompletionFunc("erc-severity", ercSeverityCompletion)                                                                //nolint:errcheck // and --erc-severity
+}
+
+// ercSeverityCompletion lists every rule=severity --erc-severity takes, which
+// is fewer than two dozen and saves looking the rule names up.
+func ercSeverityCompletion(_ *cobra.Command, _ []string, _ string) ([]string, cobra.ShellCompDirective) {
+	var pairs []string
+	for _, r := range schematic.Rules {
+		for _, v := range []schematic.Severity{schematic.SeverityError, schematic.SeverityWarning, schematic.SeverityIgnore} {
+			pairs = append(pairs, fmt.Sprintf("%s=%s", r, v))
+		}
+	}
+	return pairs, cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeTargets lists tinygo's targets. tinygo knows more boards than anyone
+// remembers the spelling of, and is already required to flash.
+func completeTargets(_ *cobra.Command, _ []string, _ string) ([]string, cobra.ShellCompDirective) {
+	targets, err := script.Exec("tinygo targets").Slice()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+	return targets, cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeSerialPorts lists the USB serial devices present now, a Pico
+// running firmware among them.
+func completeSerialPorts(_ *cobra.Command, _ []string, _ string) ([]string, cobra.ShellCompDirective) {
+	var ports []string
+	for _, pattern := range []string{"/dev/ttyACM*", "/dev/ttyUSB*"} {
+		found, _ := filepath.Glob(pattern) //nolint:errcheck // the patterns are constant and valid
+		ports = append(ports, found...)
+	}
+	sort.Strings(ports)
+	return ports, cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeBlockDevices lists the partitions of removable disks, each described
+// by its label, so a Pico in BOOTSEL mode is the one that says RPI-RP2. The
+// fixed disks are left out: --dev is mounted and written to, and the system
+// disk is never the right answer.
+func completeBlockDevices(_ *cobra.Command, _ []string, _ string) ([]string, cobra.ShellCompDirective) {
+	labels := map[string]string{}
+	byLabel, _ := filepath.Glob("/dev/disk/by-label/*") //nolint:errcheck // the pattern is constant and valid
+	for _, l := range byLabel {
+		if dev, err := filepath.EvalSymlinks(l); err == nil {
+			labels[dev] = filepath.Base(l)
+		}
+	}
+	var devs []string
+	disks, _ := filepath.Glob("/sys/block/*") //nolint:errcheck // the pattern is constant and valid
+	for _, disk := range disks {
+		b, err := os.ReadFile(filepath.Join(disk, "removable")) //nolint:gosec // a sysfs attribute
+		if err != nil || strings.TrimSpace(string(b)) != "1" {
+			continue
+		}
+		name := filepath.Base(disk)
+		parts, _ := filepath.Glob(filepath.Join(disk, name+"*")) //nolint:errcheck // built from a path Glob returned
+		if len(parts) == 0 {
+			parts = []string{disk}
+		}
+		for _, p := range parts {
+			dev := "/dev/" + filepath.Base(p)
+			if l, ok := labels[dev]; ok {
+				dev += "\t" + l
+			}
+			devs = append(devs, dev)
+		}
+	}
+	sort.Strings(devs)
+	return devs, cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeProfiles lists the profiles in the project file, each described by
+// what it extends or the program it flashes.
+func completeProfiles(_ *cobra.Command, _ []string, _ string) ([]string, cobra.ShellCompDirective) {
+	if project == nil {
+		if err := readProject(); err != nil || project == nil {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+	}
+	names := make([]string, 0, len(project.Profiles))
+	for n, p := range project.Profiles {
+		switch {
+		case p != nil && p.GoProg != "":
+			n += "\t" + p.GoProg
+		case p != nil && p.Extends != "":
+			n += "\textends " + p.Extends
+		}
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names, cobra.ShellCompDirectiveNoFileComp
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+// complete runs mcu as a shell asking for completions would, and returns
+// what it printed.
+func complete(t *testing.T, args ...string) string {
+	t.Helper()
+	var b bytes.Buffer
+	RootCmd.SetOut(&b)
+	RootCmd.SetArgs(append([]string{cobra.ShellCompRequestCmd}, args...))
+	t.Cleanup(func() {
+		RootCmd.SetOut(nil)
+		RootCmd.SetArgs(nil)
+	})
+	if err := RootCmd.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+// Each flag that takes one of a known set of values is given its completer
+// by name, wherever it is defined, and a flag with choices offers them.
+func TestRegisterCompletionsGivesEachFlagItsCompleter(t *testing.T) {
+	scratch := &cobra.Command{Use: "scratch", Run: func(*cobra.Command, []string) {}}
+	for _, name := range []string{"target", "ser", "dev", "profile", "mode"} {
+		scratch.Flags().String(name, "", "")
+	}
+	_ = scratch.Flags().SetAnnotation("mode", choicesAnnotation, []string{"clock", "scroll"}) //nolint:errcheck // the flag was just defined
+	RootCmd.AddCommand(scratch)
+	t.Cleanup(func() { RootCmd.RemoveCommand(scratch) })
+	registerCompletions()
+
+	for _, tc := range []struct {
+		flag string
+		want cobra.CompletionFunc
+	}{
+		{"target", completeTargets},
+		{"ser", completeSerialPorts},
+		{"dev", completeBlockDevices},
+		{"profile", completeProfiles},
+	} {
+		fn, ok := scratch.GetFlagCompletionFunc(tc.flag)
+		if !ok || reflect.ValueOf(fn).Pointer() != reflect.ValueOf(tc.want).Pointer() {
+			t.Errorf("--%s has the wrong completer", tc.flag)
+		}
+	}
+
+	savedProject := project
+	t.Cleanup(func() { project = savedProject })
+	project = &Project{Profiles: map[string]*Profile{
+		"pico":    {Target: "pico"},
+		"clock":   {Extends: "pico", GoProg: "firmware/main.go"},
+		"kitchen": {Extends: "clock"},
+	}}
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"scratch", "--profile", ""}, "clock\tfirmware/main.go\nkitchen\textends clock\npico\n:4\n"},
+		{[]string{"scratch", "--mode", ""}, "clock\nscroll\n:4\n"},
+	} {
+		if got := complete(t, tc.args...); got != tc.want {
+			t.Errorf("%s: got %q, want %q", strings.Join(tc.args, " "), got, tc.want)
+		}
+	}
+}
+
+// The script for each shell is the one cobra writes for mcu, on the command's
+// own output.
+func TestCompletionScripts(t *testing.T) {
+	for shell, want := range map[string]string{
+		"bash": "__start_" + RootCmd.Name(),
+		"zsh":  "#compdef " + RootCmd.Name(),
+		"fish": "complete -c " + RootCmd.Name(),
+	} {
+		var b bytes.Buffer
+		RootCmd.SetOut(&b)
+		RootCmd.SetArgs([]string{"completion", shell})
+		err := RootCmd.Execute()
+		RootCmd.SetOut(nil)
+		RootCmd.SetArgs(nil)
+		if err != nil {
+			t.Fatalf("%s: %v", shell, err)
+		}
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("%s: no %q in the script", shell, want)
+		}
+	}
+	RootCmd.SetArgs([]string{"completion", "tcsh"})
+	defer RootCmd.SetArgs(nil)
+	if err := RootCmd.Execute(); err == nil {
+		t.Error("a shell mcu has no script for was accepted")
+	}
+}
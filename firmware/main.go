@@ -19,8 +19,8 @@ Workflow / flashing procedure ; note that the block device will be different on
 set -x ; sudo echo "sudo cache" ; udisksctl mount -b /dev/sdd1 ; tinygo flash -target=pico -ldflags "-X main.timeStamp='$(date '+%Y-%m-%dT%H:%M:%SZ')' -X main.multiDisplay='true' -X main.rtcFuture='true' -X main.offSet='9'" main.go && sleep 3 && sudo chmod a+rw $(echo /dev/ttyACM?) && go run mon.go  -m $(echo /dev/ttyACM?) ; set +x
 */
 
-// Set timestamp $(date '+%Y-%m-%dT%H:%M:%SZ')
-var timeStamp string //$(date '+%Y-%m-%dT%H:%M:%SZ')
+// Set timestamp, local time as RFC3339
+var timeStamp string //{{now "2006-01-02T15:04:05Z"}}
 
 // seconds to add to timeStamp
 var offSet string //9
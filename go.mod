@@ -4,12 +4,16 @@ go 1.25.0
 
 require (
 	github.com/0magnet/calvin v0.0.0-20260821223054-326629922218
+	github.com/BurntSushi/toml v1.6.0
 	github.com/bitfield/script v0.25.0
 	github.com/guptarohit/asciigraph v0.10.0
 	github.com/ivanpirog/coloredcobra v1.0.1
 	github.com/spf13/cobra v1.10.2
 	github.com/spf13/pflag v1.0.10
 	github.com/tarm/serial v0.0.0-20180830185346-98f6abe2eb07
+	go.yaml.in/yaml/v3 v3.0.4
+	golang.org/x/image v0.25.0
+	golang.org/x/sys v0.47.0
 	tinygo.org/x/drivers v0.35.0
 )
 
@@ -21,6 +25,5 @@ require (
 	github.com/itchyny/timefmt-go v0.1.8 // indirect
 	github.com/mattn/go-colorable v0.1.15 // indirect
 	github.com/mattn/go-isatty v0.0.24 // indirect
-	golang.org/x/sys v0.47.0 // indirect
 	mvdan.cc/sh/v3 v3.13.1 // indirect
 )
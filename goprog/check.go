@@ -0,0 +1,210 @@
+package goprog
+
+import (
+	"bytes"
+	_ "embed" // the machine stub
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+//go:embed machine.go.txt
+var machineStub []byte
+
+// Problem is a type error in rewritten source, traced back to the change that
+// caused it.
+type Problem struct {
+	// Origin is the flag or --set whose value the error is in, or, for an
+	// error in code that was not changed, every change that was made.
+	Origin string
+	Pos    token.Position
+	Line   string // the source line the error is on
+	Msg    string
+}
+
+// String is the problem with the line quoted and a caret under the column, the
+// way a compiler would show it, but named for the flag rather than a temp file.
+func (p Problem) String() string {
+	// A literal is often one very long line; show the part around the
+	// error rather than the line from the start.
+	line, col := p.Line, p.Pos.Column-1
+	if len(line) > 120 && col < len(line) {
+		start, end := max(col-60, 0), min(col+40, len(line))
+		prefix, suffix := "", ""
+		if start > 0 {
+			prefix = "..."
+		}
+		if end < len(line) {
+			suffix = "..."
+		}
+		line, col = prefix+line[start:end]+suffix, col-start+len(prefix)
+	}
+	var caret strings.Builder
+	for i := 0; i < col && i < len(line); i++ {
+		if line[i] == '\t' {
+			caret.WriteByte('\t')
+		} else {
+			caret.WriteByte(' ')
+		}
+	}
+	return fmt.Sprintf("%s: %s: %s\n\t%s\n\t%s^", p.Origin, p.Pos, p.Msg, line, caret.String())
+}
+
+// Check type-checks the rewritten file, with the real machine package replaced
+// by a stub, and returns the errors the changes are responsible for.
+//
+// Without this a bad --displays is found by tinygo, after the boot volume was
+// mounted, in a temp file that no longer exists. The original file is checked
+// too when there are errors, and an error it already had is dropped: the stub
+// is not the whole machine package, and code nobody touched is not this
+// check's business. dir is where imports are resolved from.
+func (s *Source) Check(dir string) ([]Problem, error) {
+	if !s.Changed() {
+		return nil, nil
+	}
+	out, spans := s.spliced()
+	problems, err := check(s.Name, out, dir)
+	if err != nil || len(problems) == 0 {
+		return nil, err
+	}
+	before, err := check(s.Name, s.Src, dir)
+	if err != nil {
+		return nil, err
+	}
+	had := map[string]bool{}
+	for _, p := range before {
+		had[p.Msg+"\x00"+strings.TrimSpace(p.Line)] = true
+	}
+
+	var all []string
+	for _, sp := range spans {
+		all = append(all, sp.origin)
+	}
+	var kept []Problem
+	for _, p := range problems {
+		if had[p.Msg+"\x00"+strings.TrimSpace(p.Line)] {
+			continue
+		}
+		p.Origin = strings.Join(all, ", ")
+		for _, sp := range spans {
+			if p.Pos.Offset >= sp.start && p.Pos.Offset <= sp.end {
+				p.Origin = sp.origin
+				break
+			}
+		}
+		kept = append(kept, p)
+	}
+	return kept, nil
+}
+
+// check type-checks one file of package main on its own.
+func check(name string, src []byte, dir string) ([]Problem, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, name, src, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	lines := bytes.Split(src, []byte("\n"))
+	var problems []Problem
+	conf := types.Config{
+		Importer: newStubImporter(fset, dir),
+		Error: func(err error) {
+			te, ok := err.(types.Error)
+			if !ok {
+				return
+			}
+			pos := fset.Position(te.Pos)
+			p := Problem{Pos: pos, Msg: te.Msg}
+			if pos.Line-1 < len(lines) {
+				p.Line = string(lines[pos.Line-1])
+			}
+			problems = append(problems, p)
+		},
+	}
+	conf.Check("main", fset, []*ast.File{f}, nil) //nolint:errcheck,gosec // every error went to conf.Error
+	sort.SliceStable(problems, func(i, j int) bool { return problems[i].Pos.Offset < problems[j].Pos.Offset })
+	return problems, nil
+}
+
+// stubImporter resolves machine to the stub, the standard library from export
+// data, and anything else from source, found with go list from dir. Errors in
+// imported packages are ignored: a driver that does not type-check against the
+// stub has not been changed by anyone here.
+type stubImporter struct {
+	fset *token.FileSet
+	std  types.Importer
+	dir  string
+	pkgs map[string]*types.Package
+}
+
+func newStubImporter(fset *token.FileSet, dir string) *stubImporter {
+	return &stubImporter{fset: fset, std: importer.Default(), dir: dir, pkgs: map[string]*types.Package{}}
+}
+
+func (im *stubImporter) Import(path string) (*types.Package, error) {
+	if p, ok := im.pkgs[path]; ok {
+		return p, nil
+	}
+	var p *types.Package
+	var err error
+	switch {
+	case path == "machine":
+		p, err = im.fromSource(path, []string{"machine.go"}, [][]byte{machineStub})
+	case !strings.Contains(strings.Split(path, "/")[0], "."):
+		p, err = im.std.Import(path)
+	default:
+		p, err = im.fromModule(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	im.pkgs[path] = p
+	return p, nil
+}
+
+func (im *stubImporter) fromModule(path string) (*types.Package, error) {
+	cmd := exec.Command("go", "list", "-e", "-find", "-json", "--", path)
+	cmd.Dir = im.dir
+	b, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("go list %s: %w", path, err)
+	}
+	var p listed
+	if err := json.Unmarshal(b, &p); err != nil {
+		return nil, err
+	}
+	if len(p.GoFiles) == 0 {
+		return nil, fmt.Errorf("%s: no Go files", path)
+	}
+	names := make([]string, len(p.GoFiles))
+	for i, f := range p.GoFiles {
+		names[i] = filepath.Join(p.Dir, f)
+	}
+	return im.fromSource(path, names, nil)
+}
+
+func (im *stubImporter) fromSource(path string, names []string, srcs [][]byte) (*types.Package, error) {
+	var files []*ast.File
+	for i, name := range names {
+		var src any
+		if srcs != nil {
+			src = srcs[i]
+		}
+		f, err := parser.ParseFile(im.fset, name, src, 0)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	conf := types.Config{Importer: im, Error: func(error) {}}
+	p, _ := conf.Check(path, im.fset, files, nil) //nolint:errcheck // see stubImporter
+	return p, nil
+}
@@ -0,0 +1,508 @@
+package goprog
+
+import (
+	"fmt"
+	"go/ast"
+	"go/printer"
+	"go/token"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ConfigLiterals turns a configuration document into Go source for the
+// variables of f it names:
+//
+//	displays:
+//	  - mode: clock
+//	    color: white on blue
+//	    speed: 333ms
+//	    data_pins: [GP22, GP21, GP20, GP19, GP18, GP17, GP16, GP15]
+//	    rs: GP26
+//	ds1307i2c: {sda: GP0, scl: GP1}
+//
+// becomes the composite literals eval would otherwise need passed back whole
+// on the command line. encoding/json is not something TinyGo can be relied on
+// for, so the document is read here, on the host, and the device is only ever
+// given Go. The structs' json tags name the fields, which is what they were
+// put there for.
+//
+// Types are taken from the file: a machine.Pin is written GP4 (or 4, or
+// NoPin), a *time.Ticker or time.Duration as a duration, and a string field
+// tagged color as names of the file's own string constants — the identifier
+// or the comment beside it: "hiwht + hiblubg", or "bright white on bright blue".
+//
+// The keys that do not name a non-string variable are returned as rest, sorted,
+// for the caller to deal with; they may be -X variables.
+func ConfigLiterals(f *ast.File, doc map[string]any) (literals map[string]string, rest []string, err error) {
+	g := newGen(f)
+	literals = map[string]string{}
+	keys := make([]string, 0, len(doc))
+	for k := range doc {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		slot, typ := findVar(f, k)
+		if slot == nil || isString(typ) || isStringLit(*slot) {
+			rest = append(rest, k)
+			continue
+		}
+		if lit := compositeLit(*slot); lit != nil && lit.Type != nil {
+			typ = lit.Type
+		}
+		if typ == nil {
+			return nil, nil, fmt.Errorf("%s: the type of its initializer is not written out, so there is nothing to convert to", k)
+		}
+		text, err := g.value(typ, doc[k], k, false)
+		if err != nil {
+			return nil, nil, err
+		}
+		literals[k] = text
+	}
+	return literals, rest, nil
+}
+
+// Configure replaces the initializer of each variable doc configures with the
+// literal ConfigLiterals makes for it. name is the document's file name, which
+// type errors in the result are reported against. It returns the variables it
+// configured and, as ConfigLiterals does, the keys it left alone.
+//
+// A variable whose initializer already says what doc does is left as it is
+// written. The literal ConfigLiterals makes has its own field order and
+// layout, so replacing it anyway would turn --export followed by --config,
+// which changes nothing, into a diff of every composite literal in the file.
+func (s *Source) Configure(name string, doc map[string]any) (vars, rest []string, err error) {
+	literals, rest, err := ConfigLiterals(s.File, doc)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", name, err)
+	}
+	for k := range literals {
+		vars = append(vars, k)
+	}
+	sort.Strings(vars)
+	ex := &exporter{gen: newGen(s.File)}
+	for _, k := range vars {
+		slot, typ := findVar(s.File, k)
+		if ex.says(*slot, typ, k, literals[k]) {
+			continue
+		}
+		if err := s.Replace(*slot, literals[k], "--config "+name+": "+k); err != nil {
+			return nil, nil, fmt.Errorf("%s: %s: %w", name, k, err)
+		}
+	}
+	return vars, rest, nil
+}
+
+// says reports whether x, the initializer of k, is text in other words: whether
+// it exports to a value ConfigLiterals writes as text. An initializer Export
+// cannot read is taken to say something else.
+func (ex *exporter) says(x, typ ast.Expr, k, text string) bool {
+	if lit := compositeLit(x); lit != nil && lit.Type != nil {
+		typ, x = lit.Type, lit
+	}
+	if typ == nil {
+		return false
+	}
+	node, err := ex.value(typ, x, k)
+	if err != nil {
+		return false
+	}
+	var v any
+	if err := node.Decode(&v); err != nil {
+		return false
+	}
+	was, err := ex.gen.value(typ, v, k, false)
+	return err == nil && was == text
+}
+
+func isStringLit(x ast.Expr) bool {
+	bl, ok := x.(*ast.BasicLit)
+	return ok && bl.Kind == token.STRING
+}
+
+type gen struct {
+	f       *ast.File
+	machine string            // what the file calls the machine package
+	time    string            // and the time package
+	colors  map[string]string // lower-case name or comment → constant
+}
+
+func newGen(f *ast.File) *gen {
+	g := &gen{f: f, machine: "machine", time: "time", colors: map[string]string{}}
+	for _, imp := range f.Imports {
+		path, _ := strconv.Unquote(imp.Path.Value)
+		if imp.Name == nil {
+			continue
+		}
+		switch path {
+		case "machine":
+			g.machine = imp.Name.Name
+		case "time":
+			g.time = imp.Name.Name
+		}
+	}
+	for _, decl := range f.Decls {
+		gd, ok := decl.(*ast.GenDecl)
+		if !ok || gd.Tok != token.CONST {
+			continue
+		}
+		for _, spec := range gd.Specs {
+			vs := spec.(*ast.ValueSpec)
+			for i, n := range vs.Names {
+				if i >= len(vs.Values) || !isStringLit(vs.Values[i]) {
+					continue
+				}
+				g.colors[strings.ToLower(n.Name)] = n.Name
+				if vs.Comment != nil {
+					c := strings.ToLower(strings.TrimSpace(strings.TrimPrefix(vs.Comment.Text(), "//")))
+					if _, taken := g.colors[c]; !taken && c != "" {
+						g.colors[c] = n.Name
+					}
+				}
+			}
+		}
+	}
+	return g
+}
+
+// value renders v as an expression of type typ. elided is true where Go lets
+// a composite literal leave its type out: an element of an array or slice.
+func (g *gen) value(typ ast.Expr, v any, at string, elided bool) (string, error) {
+	switch t := typ.(type) {
+	case *ast.ParenExpr:
+		return g.value(t.X, v, at, elided)
+	case *ast.Ident:
+		return g.named(t, v, at, elided)
+	case *ast.SelectorExpr:
+		pkg, _ := t.X.(*ast.Ident)
+		switch {
+		case g.isPin(t):
+			return g.pin(v, at)
+		case pkg != nil && pkg.Name == g.time && t.Sel.Name == "Duration":
+			return g.duration(v, at)
+		}
+	case *ast.StarExpr:
+		if sel, ok := t.X.(*ast.SelectorExpr); ok {
+			if pkg, _ := sel.X.(*ast.Ident); pkg != nil && pkg.Name == g.time && sel.Sel.Name == "Ticker" {
+				d, err := g.duration(v, at)
+				if err != nil {
+					return "", err
+				}
+				return g.time + ".NewTicker(" + d + ")", nil
+			}
+		}
+		s, err := g.value(t.X, v, at, true)
+		if err != nil || elided {
+			return s, err
+		}
+		return "&" + g.typeText(t.X) + s, nil
+	case *ast.ArrayType:
+		list, ok := v.([]any)
+		if !ok {
+			return "", fmt.Errorf("%s: want a list, got %s", at, describe(v))
+		}
+		elts := make([]string, len(list))
+		for i, e := range list {
+			s, err := g.value(t.Elt, e, fmt.Sprintf("%s[%d]", at, i), true)
+			if err != nil {
+				return "", err
+			}
+			elts[i] = s
+		}
+		if lit, ok := t.Len.(*ast.BasicLit); ok {
+			if n, _ := strconv.Atoi(lit.Value); n < len(list) {
+				return "", fmt.Errorf("%s: %d elements for an array of %d", at, len(list), n)
+			}
+		}
+		body := "{" + strings.Join(elts, ", ") + "}"
+		if elided {
+			return body, nil
+		}
+		return g.typeText(t) + body, nil
+	}
+	return "", fmt.Errorf("%s: cannot convert to %s", at, g.typeText(typ))
+}
+
+func (g *gen) named(t *ast.Ident, v any, at string, elided bool) (string, error) {
+	switch t.Name {
+	case "string":
+		s, ok := v.(string)
+		if !ok {
+			return "", fmt.Errorf("%s: want a string, got %s", at, describe(v))
+		}
+		return strconv.Quote(s), nil
+	case "bool":
+		b, ok := v.(bool)
+		if !ok {
+			return "", fmt.Errorf("%s: want true or false, got %s", at, describe(v))
+		}
+		return strconv.FormatBool(b), nil
+	case "float32", "float64":
+		n, ok := number(v)
+		if !ok {
+			return "", fmt.Errorf("%s: want a number, got %s", at, describe(v))
+		}
+		return strconv.FormatFloat(n, 'g', -1, 64), nil
+	}
+	if lo, hi, ok := intRange(t.Name); ok {
+		n, isNum := number(v)
+		if !isNum || n != math.Trunc(n) {
+			return "", fmt.Errorf("%s: want a whole number, got %s", at, describe(v))
+		}
+		if n < lo || n > hi {
+			return "", fmt.Errorf("%s: %v does not fit in %s", at, n, t.Name)
+		}
+		return strconv.FormatFloat(n, 'f', -1, 64), nil
+	}
+
+	ts := typeSpec(g.f, t.Name)
+	if ts == nil {
+		return "", fmt.Errorf("%s: type %s is not declared in this file", at, t.Name)
+	}
+	st, ok := ts.Type.(*ast.StructType)
+	if !ok {
+		return g.value(ts.Type, v, at, elided)
+	}
+	body, err := g.structLit(st, t.Name, v, at)
+	if err != nil || elided {
+		return body, err
+	}
+	return t.Name + body, nil
+}
+
+// structLit writes the fields the document gives, in declaration order, keyed
+// by field name. A key that is not a field is an error: a typo in a
+// configuration file should not quietly leave a field at zero. A pin left out
+// is NoPin rather than zero, because the zero Pin is GP0.
+func (g *gen) structLit(st *ast.StructType, name string, v any, at string) (string, error) {
+	m, ok := v.(map[string]any)
+	if !ok {
+		return "", fmt.Errorf("%s: want a mapping of %s's fields, got %s", at, name, describe(v))
+	}
+	used := map[string]bool{}
+	var known, elts []string
+	for _, fl := range st.Fields.List {
+		for _, n := range fl.Names {
+			key := jsonName(fl, n.Name)
+			known = append(known, key)
+			fv, ok := m[key]
+			if !ok {
+				if g.isPin(fl.Type) {
+					elts = append(elts, n.Name+": "+g.machine+".NoPin")
+				}
+				continue
+			}
+			used[key] = true
+			var s string
+			var err error
+			if key == "color" || key == "colour" {
+				s, err = g.color(fv, at+"."+key)
+			} else {
+				s, err = g.value(fl.Type, fv, at+"."+key, false)
+			}
+			if err != nil {
+				return "", err
+			}
+			elts = append(elts, n.Name+": "+s)
+		}
+	}
+	var unknown []string
+	for k := range m {
+		if !used[k] {
+			unknown = append(unknown, k)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return "", fmt.Errorf("%s: %s has no field %q (it has %s)", at, name, unknown[0], strings.Join(known, ", "))
+	}
+	return "{" + strings.Join(elts, ", ") + "}", nil
+}
+
+func (g *gen) isPin(typ ast.Expr) bool {
+	sel, ok := typ.(*ast.SelectorExpr)
+	if !ok {
+		return false
+	}
+	pkg, _ := sel.X.(*ast.Ident)
+	return pkg != nil && pkg.Name == g.machine && sel.Sel.Name == "Pin"
+}
+
+// jsonName is the field's json tag name, or its Go name if it has none.
+func jsonName(fl *ast.Field, name string) string {
+	if fl.Tag == nil {
+		return name
+	}
+	tag, err := strconv.Unquote(fl.Tag.Value)
+	if err != nil {
+		return name
+	}
+	j, _, _ := strings.Cut(reflect.StructTag(tag).Get("json"), ",")
+	if j == "" || j == "-" {
+		return name
+	}
+	return j
+}
+
+func (g *gen) pin(v any, at string) (string, error) {
+	var n float64
+	switch p := v.(type) {
+	case nil:
+		return g.machine + ".NoPin", nil
+	case string:
+		s := strings.ToUpper(strings.TrimSpace(p))
+		if s == "" || s == "NOPIN" || s == "NONE" {
+			return g.machine + ".NoPin", nil
+		}
+		var err error
+		if n, err = strconv.ParseFloat(strings.TrimPrefix(s, "GP"), 64); err != nil {
+			return "", fmt.Errorf("%s: %q is not a pin (GP0 to GP29, or NoPin)", at, p)
+		}
+	default:
+		var ok bool
+		if n, ok = number(v); !ok {
+			return "", fmt.Errorf("%s: want a pin, got %s", at, describe(v))
+		}
+	}
+	if n < 0 || n > 29 || n != math.Trunc(n) {
+		return "", fmt.Errorf("%s: there is no GP%v", at, n)
+	}
+	return fmt.Sprintf("%s.GP%d", g.machine, int(n)), nil
+}
+
+// duration writes a duration as Go that reads the way it was written:
+// 333 * time.Millisecond rather than 333000000.
+func (g *gen) duration(v any, at string) (string, error) {
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("%s: want a duration such as 333ms, got %s", at, describe(v))
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", at, err)
+	}
+	return durationExpr(g.time, d), nil
+}
+
+func durationExpr(pkg string, d time.Duration) string {
+	for _, u := range []struct {
+		d    time.Duration
+		name string
+	}{{time.Hour, "Hour"}, {time.Minute, "Minute"}, {time.Second, "Second"}, {time.Millisecond, "Millisecond"}, {time.Microsecond, "Microsecond"}} {
+		if d%u.d == 0 && d != 0 {
+			if d == u.d {
+				return pkg + "." + u.name
+			}
+			return fmt.Sprintf("%d * %s.%s", d/u.d, pkg, u.name)
+		}
+	}
+	return fmt.Sprintf("%s.Duration(%d)", pkg, d)
+}
+
+// color resolves colour names to the file's constants. "fg on bg" is short for
+// "fg + bg background".
+func (g *gen) color(v any, at string) (string, error) {
+	var names []string
+	switch c := v.(type) {
+	case string:
+		if fg, bg, ok := strings.Cut(c, " on "); ok {
+			names = []string{fg, bg + " background"}
+		} else {
+			names = strings.FieldsFunc(c, func(r rune) bool { return r == '+' || r == ',' })
+		}
+	case []any:
+		for _, x := range c {
+			s, ok := x.(string)
+			if !ok {
+				return "", fmt.Errorf("%s: want colour names, got %s", at, describe(x))
+			}
+			names = append(names, s)
+		}
+	default:
+		return "", fmt.Errorf("%s: want colour names, got %s", at, describe(v))
+	}
+	consts := make([]string, 0, len(names))
+	for _, n := range names {
+		c, ok := g.colorConst(strings.ToLower(strings.TrimSpace(n)))
+		if !ok {
+			return "", fmt.Errorf("%s: no colour constant named %q", at, strings.TrimSpace(n))
+		}
+		consts = append(consts, c)
+	}
+	if len(consts) == 0 {
+		return `""`, nil
+	}
+	return strings.Join(consts, " + "), nil
+}
+
+// colorConst looks a name up, and failing that builds it from the constants'
+// naming: only the base colours have comments, so "bright blue background" is
+// found as hi + blu + bg.
+func (g *gen) colorConst(name string) (string, bool) {
+	if c, ok := g.colors[name]; ok {
+		return c, true
+	}
+	if base, ok := strings.CutPrefix(name, "bright "); ok {
+		if c, ok := g.colorConst(base); ok {
+			c, ok = g.colors["hi"+strings.ToLower(c)]
+			return c, ok
+		}
+	}
+	if base, ok := strings.CutSuffix(name, " background"); ok {
+		if c, ok := g.colorConst(base); ok {
+			c, ok = g.colors[strings.ToLower(c)+"bg"]
+			return c, ok
+		}
+	}
+	return "", false
+}
+
+func (g *gen) typeText(typ ast.Expr) string {
+	var b strings.Builder
+	printer.Fprint(&b, token.NewFileSet(), typ) //nolint:errcheck,gosec // printing to a strings.Builder cannot fail
+	return b.String()
+}
+
+func intRange(name string) (lo, hi float64, ok bool) {
+	bits := map[string]int{"int8": 8, "int16": 16, "int32": 32, "rune": 32, "int64": 64, "int": 32,
+		"uint8": 8, "byte": 8, "uint16": 16, "uint32": 32, "uint64": 64, "uint": 32, "uintptr": 32}
+	b, ok := bits[name]
+	if !ok {
+		return 0, 0, false
+	}
+	// int is 32 bits on the RP2040, which is the board this is for.
+	if strings.HasPrefix(name, "u") || name == "byte" {
+		return 0, math.Pow(2, float64(b)) - 1, true
+	}
+	return -math.Pow(2, float64(b-1)), math.Pow(2, float64(b-1)) - 1, true
+}
+
+func number(v any) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+func describe(v any) string {
+	switch v.(type) {
+	case nil:
+		return "nothing"
+	case map[string]any:
+		return "a mapping"
+	case []any:
+		return "a list"
+	}
+	return fmt.Sprintf("%T %v", v, v)
+}
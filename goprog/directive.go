@@ -0,0 +1,281 @@
+package goprog
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"strconv"
+	"strings"
+)
+
+// FlagDirective is what a //mcu:flag line says about a variable's flag:
+//
+//	// seconds to add to timeStamp, which the RTC keeps in UTC
+//	//mcu:flag name=offset type=int default=9 group=time help="hours east of UTC, in seconds"
+//	var offSet string
+//
+// Values are bare words or Go-quoted strings; hidden and required stand on
+// their own. Every key is optional, and whatever the line leaves out comes
+// from the older conventions: the name of the variable, the trailing comment
+// as the default, the doc comment as the help and a kind inferred from both.
+//
+// The conventions are still how most variables are annotated, and are fine for
+// them. The directive is for what they cannot say — a flag name that is not
+// the variable's, a group in the help menu, a flag kept out of it, or one that
+// must be given — and says it in one place, where before a string variable
+// and an initialised one were described by different rules.
+type FlagDirective struct {
+	Name  string
+	Help  string
+	Group string
+
+	Default    string
+	HasDefault bool
+
+	Kind    Kind
+	Choices []string
+	HasKind bool // type= or choices= was given
+
+	// Hidden keeps the flag out of the help menu; it can still be set.
+	Hidden bool
+	// Required refuses to build until the flag, a profile or --config gives
+	// the variable a value.
+	Required bool
+}
+
+// ParseFlagDirective reads the part of a //mcu:flag line after "flag".
+func ParseFlagDirective(s string) (FlagDirective, error) {
+	var d FlagDirective
+	for s = strings.TrimSpace(s); s != ""; s = strings.TrimSpace(s) {
+		n := strings.IndexAny(s, "= \t")
+		if n < 0 {
+			n = len(s)
+		}
+		key := s[:n]
+		s = s[n:]
+		if !strings.HasPrefix(s, "=") {
+			switch key {
+			case "hidden":
+				d.Hidden = true
+			case "required":
+				d.Required = true
+			default:
+				return d, fmt.Errorf("%q needs a value (%s=...)", key, key)
+			}
+			continue
+		}
+		s = s[1:]
+		var value string
+		if strings.HasPrefix(s, `"`) || strings.HasPrefix(s, "`") {
+			q, err := strconv.QuotedPrefix(s)
+			if err != nil {
+				return d, fmt.Errorf("%s: unterminated string", key)
+			}
+			value, _ = strconv.Unquote(q) //nolint:errcheck // QuotedPrefix found a valid one
+			s = s[len(q):]
+		} else {
+			n := strings.IndexAny(s, " \t")
+			if n < 0 {
+				n = len(s)
+			}
+			value, s = s[:n], s[n:]
+		}
+
+		switch key {
+		case "name":
+			if value == "" || strings.HasPrefix(value, "-") || strings.ContainsAny(value, " \t=") {
+				return d, fmt.Errorf("name %q is not a flag name", value)
+			}
+			if ReservedFlags[value] {
+				return d, fmt.Errorf("name %s is mcu's own flag", value)
+			}
+			d.Name = value
+		case "help":
+			d.Help = value
+		case "group":
+			d.Group = value
+		case "default":
+			d.Default, d.HasDefault = value, true
+		case "type":
+			k, choices, err := ParseKind(value)
+			if err != nil {
+				return d, err
+			}
+			if d.Kind == Enum && k != Enum {
+				return d, fmt.Errorf("type=%s with choices", value)
+			}
+			d.Kind, d.HasKind = k, true
+			if k == Enum {
+				d.Choices = choices
+			}
+		case "choices":
+			if d.HasKind && d.Kind != Enum {
+				return d, fmt.Errorf("choices for a %s", d.Kind)
+			}
+			k, choices, err := ParseKind("enum(" + value + ")")
+			if err != nil {
+				return d, err
+			}
+			d.Kind, d.Choices, d.HasKind = k, choices, true
+		case "hidden", "required":
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return d, fmt.Errorf("%s=%s: want true or false", key, value)
+			}
+			if key == "hidden" {
+				d.Hidden = b
+			} else {
+				d.Required = b
+			}
+		default:
+			return d, fmt.Errorf("unknown key %q (want name, type, default, help, group, choices, hidden or required)", key)
+		}
+	}
+	if d.HasDefault && d.Kind == Enum {
+		if err := Enum.Validate(d.Default, d.Choices); err != nil {
+			return d, fmt.Errorf("default: %w", err)
+		}
+	}
+	return d, nil
+}
+
+// flagDirective finds and parses the last //mcu:flag line among directives.
+// found reports whether there was one; err, if not nil, is why it could not
+// be used.
+func flagDirective(directives []string) (d FlagDirective, found bool, err error) {
+	for i := len(directives) - 1; i >= 0; i-- {
+		rest, ok := strings.CutPrefix(directives[i], "flag")
+		if !ok || (rest != "" && rest[0] != ' ' && rest[0] != '\t') {
+			continue
+		}
+		if d, err = ParseFlagDirective(rest); err != nil {
+			return d, true, fmt.Errorf("//mcu:flag: %w", err)
+		}
+		return d, true, nil
+	}
+	return d, false, nil
+}
+
+// ReservedFlags are the flags mcu gives flash, ef and eval itself, which a
+// variable's flag would be defined alongside. pflag answers a second flag of
+// the same name by panicking, and it does so while the commands are being set
+// up, so a firmware with a config variable used to take eval and ef down with
+// it, --help included.
+var ReservedFlags = map[string]bool{
+	"help": true, "profile": true, "config": true, "set": true,
+	"diff": true, "write": true, "export": true, "check": true, "keep": true,
+	"ser": true, "baud": true, "slp": true, "dev": true, "target": true,
+	"watch": true, "production": true, "expect": true, "csv": true, "timeout": true,
+}
+
+// unreserved is the flag for a main package variable whose flag would be
+// name: name itself, or, if mcu has a flag of that name, the variable as -X
+// names it, with an error that says so. The qualified form is the one Load
+// already falls back to when two packages would share a flag.
+func unreserved(name, variable string) (string, error) {
+	if !ReservedFlags[name] {
+		return name, nil
+	}
+	flag := "main." + variable
+	return flag, fmt.Errorf("--%s is mcu's own flag; %s's is --%s", name, variable, flag)
+}
+
+// directivePos is the last //mcu:flag line in the first of the comment groups
+// that has one, which for a spec and its declaration is the line
+// flagDirective reads, or name's position if none does.
+func directivePos(name *ast.Ident, groups ...*ast.CommentGroup) token.Pos {
+	for _, cg := range groups {
+		if cg == nil {
+			continue
+		}
+		for i := len(cg.List) - 1; i >= 0; i-- {
+			rest, ok := strings.CutPrefix(cg.List[i].Text, "//mcu:flag")
+			if ok && (rest == "" || rest[0] == ' ' || rest[0] == '\t') {
+				return cg.List[i].Pos()
+			}
+		}
+	}
+	return name.Pos()
+}
+
+// Init is an initialised package-level variable that is not a string: one eval
+// and ef give a flag whose value replaces the initializer in the source.
+type Init struct {
+	Name  string
+	Flag  string
+	Doc   string
+	Value ast.Expr // the initializer, in the file Inits was given
+
+	Group    string
+	Hidden   bool
+	Required bool // the initializer is a placeholder; --flag or --config must replace it
+
+	// Err is a //mcu:flag line that could not be used, or a flag name mcu
+	// keeps for itself. The variable is still described by the conventions
+	// and still has a flag, so either costs a warning and not the flag.
+	Err error
+	// Pos is the //mcu:flag line, or the variable's name if it has none:
+	// where a warning about Err points.
+	Pos token.Pos
+}
+
+// Inits returns a file's initialised non-string variables in declaration
+// order.
+func Inits(f *ast.File) []Init {
+	var inits []Init
+	for _, decl := range f.Decls {
+		gd, ok := decl.(*ast.GenDecl)
+		if !ok || gd.Tok != token.VAR {
+			continue
+		}
+		doc, dirs := splitDoc(gd.Doc)
+		for _, spec := range gd.Specs {
+			vs, ok := spec.(*ast.ValueSpec)
+			if !ok || len(vs.Values) == 0 || isString(vs.Type) {
+				continue
+			}
+			sdoc, sdirs := specDoc(vs, doc, dirs)
+			d, found, err := flagDirective(sdirs)
+			if err == nil && (d.HasKind || d.HasDefault) {
+				err = fmt.Errorf("//mcu:flag: type, default and choices are for -X variables; %s's initializer is its own", vs.Names[0].Name)
+			}
+			for i, name := range vs.Names {
+				if i >= len(vs.Values) {
+					continue
+				}
+				in := Init{Name: name.Name, Flag: name.Name, Doc: sdoc, Value: vs.Values[i], Err: err, Pos: directivePos(name, vs.Doc, gd.Doc)}
+				if found && err == nil {
+					in.apply(d)
+				}
+				var rerr error
+				if in.Flag, rerr = unreserved(in.Flag, in.Name); rerr != nil {
+					in.Err = errors.Join(in.Err, rerr)
+				}
+				inits = append(inits, in)
+			}
+		}
+	}
+	return inits
+}
+
+func (in *Init) apply(d FlagDirective) {
+	if d.Name != "" {
+		in.Flag = d.Name
+	}
+	if d.Help != "" {
+		in.Doc = d.Help
+	}
+	in.Group, in.Hidden, in.Required = d.Group, d.Hidden, d.Required
+}
+
+// specDoc is a spec's own doc comment and directives, which in a var ( ... )
+// block are more specific than the declaration's: the spec's prose if it has
+// any, and both sets of directives, the spec's last so they win.
+func specDoc(vs *ast.ValueSpec, doc string, dirs []string) (string, []string) {
+	sdoc, sdirs := splitDoc(vs.Doc)
+	if sdoc == "" {
+		sdoc = doc
+	}
+	return sdoc, append(append([]string(nil), dirs...), sdirs...)
+}
@@ -0,0 +1,316 @@
+package goprog
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Env is what a default value can refer to when it is expanded.
+//
+// Defaults used to be expanded by handing them to bash -c printf, which ran a
+// shell for every -X variable every time mcu started — including for commands
+// that never flash anything — and meant a comment in the firmware could run
+// anything at all. A default is now a text/template with a fixed set of
+// functions, none of which is a shell:
+//
+//	{{now "2006-01-02T15:04:05Z"}}   local time, in a Go layout (default RFC3339)
+//	{{utc "15:04"}}                  the same in UTC
+//	{{unix}}                         seconds since the epoch
+//	{{env "USER"}}                   an environment variable
+//	{{git "describe" "--tags"}}      git run directly, without a shell
+//	{{describe}} {{commit}}          git describe --tags --always --dirty; the short HEAD
+//	{{hostname}}                     this machine's name
+//	{{file "VERSION"}}               a file's contents, trailing newline removed
+//
+// git and file are relative to Dir, which is GOPROG's directory.
+type Env struct {
+	Dir string
+	Now func() time.Time // nil means time.Now
+}
+
+// Expand evaluates a default. A value with no {{ in it is returned as is, so a
+// plain literal costs nothing.
+func (e Env) Expand(s string) (string, error) {
+	if !strings.Contains(s, "{{") {
+		return s, nil
+	}
+	t, err := template.New("default").Option("missingkey=error").Funcs(e.funcs()).Parse(s)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if err := t.Execute(&b, nil); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+func (e Env) now() time.Time {
+	if e.Now != nil {
+		return e.Now()
+	}
+	return time.Now()
+}
+
+func (e Env) funcs() template.FuncMap {
+	layout := func(l []string) string {
+		if len(l) > 0 {
+			return l[0]
+		}
+		return time.RFC3339
+	}
+	return template.FuncMap{
+		"now":      func(l ...string) string { return e.now().Format(layout(l)) },
+		"utc":      func(l ...string) string { return e.now().UTC().Format(layout(l)) },
+		"unix":     func() int64 { return e.now().Unix() },
+		"env":      os.Getenv,
+		"git":      e.git,
+		"describe": func() (string, error) { return e.git("describe", "--tags", "--always", "--dirty") },
+		"commit":   func() (string, error) { return e.git("rev-parse", "--short", "HEAD") },
+		"hostname": os.Hostname,
+		"file": func(name string) (string, error) {
+			if !filepath.IsAbs(name) {
+				name = filepath.Join(e.Dir, name)
+			}
+			b, err := os.ReadFile(name) //nolint:gosec // a file the firmware's own comment names
+			return strings.TrimRight(string(b), "\r\n"), err
+		},
+	}
+}
+
+func (e Env) git(args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = e.Dir
+	b, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %w", strings.Join(args, " "), err)
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// Translate rewrites a default written for the old shell expansion into a
+// template. It understands the forms firmware comments actually used —
+// $(date '+...'), $(hostname), $(git ...), $(cat FILE), $VAR — and returns an
+// error for anything else rather than guess at what a shell would have done.
+// A default with no $ in it is returned unchanged, apart from escaping.
+func Translate(s string) (string, error) {
+	var b strings.Builder
+	lit := func(text string) {
+		if text == "" {
+			return
+		}
+		if strings.Contains(text, "{{") {
+			fmt.Fprintf(&b, "{{%s}}", strconv.Quote(text))
+			return
+		}
+		b.WriteString(text)
+	}
+
+	for len(s) > 0 {
+		i := strings.IndexByte(s, '$')
+		if i < 0 {
+			lit(s)
+			break
+		}
+		lit(s[:i])
+		s = s[i:]
+
+		switch {
+		case strings.HasPrefix(s, "$("):
+			end := matchParen(s)
+			if end < 0 {
+				return "", fmt.Errorf("unterminated $( in %q", s)
+			}
+			t, err := translateCommand(s[2:end])
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(t)
+			s = s[end+1:]
+		case strings.HasPrefix(s, "${"):
+			end := strings.IndexByte(s, '}')
+			if end < 0 {
+				return "", fmt.Errorf("unterminated ${ in %q", s)
+			}
+			fmt.Fprintf(&b, "{{env %s}}", strconv.Quote(s[2:end]))
+			s = s[end+1:]
+		default:
+			// A name starts with a letter; "$5" is left alone.
+			n := 1
+			for n < len(s) && (s[n] == '_' || isAlnum(s[n])) && (n > 1 || s[n] > '9') {
+				n++
+			}
+			if n == 1 {
+				lit("$")
+				s = s[1:]
+				continue
+			}
+			fmt.Fprintf(&b, "{{env %s}}", strconv.Quote(s[1:n]))
+			s = s[n:]
+		}
+	}
+	return b.String(), nil
+}
+
+// matchParen returns the index of the ) closing the $( at the start of s,
+// skipping anything quoted, or -1.
+func matchParen(s string) int {
+	depth := 0
+	var quote byte
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func translateCommand(cmd string) (string, error) {
+	words, err := splitWords(cmd)
+	if err != nil {
+		return "", err
+	}
+	if len(words) == 0 {
+		return "", fmt.Errorf("empty $()")
+	}
+	switch words[0] {
+	case "date":
+		fn := "now"
+		format := "+%a %b %e %H:%M:%S %Z %Y" // date's own default
+		for _, w := range words[1:] {
+			switch {
+			case w == "-u" || w == "--utc":
+				fn = "utc"
+			case strings.HasPrefix(w, "+"):
+				format = w
+			default:
+				return "", fmt.Errorf("cannot translate date option %q", w)
+			}
+		}
+		layout, err := strftimeLayout(format[1:])
+		if err != nil {
+			return "", err
+		}
+		if layout == "" {
+			return "{{unix}}", nil
+		}
+		return fmt.Sprintf("{{%s %s}}", fn, strconv.Quote(layout)), nil
+	case "hostname":
+		if len(words) == 1 {
+			return "{{hostname}}", nil
+		}
+	case "cat":
+		if len(words) == 2 {
+			return fmt.Sprintf("{{file %s}}", strconv.Quote(words[1])), nil
+		}
+	case "git":
+		args := make([]string, 0, len(words)-1)
+		for _, w := range words[1:] {
+			args = append(args, strconv.Quote(w))
+		}
+		return fmt.Sprintf("{{git %s}}", strings.Join(args, " ")), nil
+	case "echo", "printf":
+		return strings.Join(words[1:], " "), nil
+	}
+	return "", fmt.Errorf("cannot translate $(%s) without a shell", cmd)
+}
+
+// splitWords splits a command line on spaces, honouring single and double
+// quotes. It is the little of shell quoting the old defaults relied on.
+func splitWords(s string) ([]string, error) {
+	var words []string
+	var cur strings.Builder
+	in := false
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				cur.WriteByte(c)
+			}
+		case c == '\'' || c == '"':
+			quote, in = c, true
+		case c == ' ' || c == '\t':
+			if in {
+				words = append(words, cur.String())
+				cur.Reset()
+				in = false
+			}
+		default:
+			cur.WriteByte(c)
+			in = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote in %q", s)
+	}
+	if in {
+		words = append(words, cur.String())
+	}
+	return words, nil
+}
+
+// strftime directives and their Go layout equivalents.
+var strftime = map[byte]string{
+	'Y': "2006", 'y': "06", 'm': "01", 'd': "02", 'e': "_2", 'H': "15", 'I': "03",
+	'M': "04", 'S': "05", 'p': "PM", 'b': "Jan", 'h': "Jan", 'B': "January",
+	'a': "Mon", 'A': "Monday", 'Z': "MST", 'z': "-0700", 'j': "002",
+	'F': "2006-01-02", 'T': "15:04:05", 'D': "01/02/06", 'R': "15:04", '%': "%",
+}
+
+// strftimeLayout converts a date(1) format to a Go layout. "%s" on its own
+// comes back as "", meaning epoch seconds, which no layout can express.
+//
+// Literal text is only allowed where Go would not read it as part of a layout:
+// a digit, or a word such as Mon or PM, would silently become a field.
+func strftimeLayout(f string) (string, error) {
+	if f == "%s" {
+		return "", nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(f); i++ {
+		if f[i] != '%' {
+			if isAlnum(f[i]) && !strings.ContainsRune("TZW", rune(f[i])) {
+				return "", fmt.Errorf("cannot translate the literal %q in date format %q", f[i:i+1], f)
+			}
+			b.WriteByte(f[i])
+			continue
+		}
+		i++
+		if i >= len(f) {
+			return "", fmt.Errorf("date format %q ends in %%", f)
+		}
+		l, ok := strftime[f[i]]
+		if !ok {
+			return "", fmt.Errorf("cannot translate %%%c in date format %q", f[i], f)
+		}
+		b.WriteString(l)
+	}
+	return b.String(), nil
+}
+
+func isAlnum(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
@@ -0,0 +1,570 @@
+package goprog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/constant"
+	"go/token"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// Export is ConfigLiterals the other way round: the file's configuration, as a
+// document --config would read back and give the same program. Each
+// initialised non-string variable is evaluated from its literal, without
+// compiling anything, and each -X variable is given its default; both in the
+// order the file declares them.
+//
+// A pin comes out as its name, a ticker or a duration as a duration, and a
+// colour by the names of the constants it is made of, so a configuration can
+// be kept and reviewed as data rather than as one very long line of Go. What
+// cannot be worked out from the source alone, a call to anything but
+// time.NewTicker say, is an error naming where it is.
+//
+// The result is a YAML node so that the order survives; JSON turns it into
+// JSON.
+func Export(f *ast.File) (*yaml.Node, error) {
+	return (&exporter{gen: newGen(f)}).export(f)
+}
+
+func (ex *exporter) export(f *ast.File) (*yaml.Node, error) {
+	doc := &yaml.Node{Kind: yaml.MappingNode}
+	xvars := map[string]Var{}
+	for _, v := range XVars(f) {
+		xvars[v.Name] = v
+	}
+	for _, decl := range f.Decls {
+		gd, ok := decl.(*ast.GenDecl)
+		if !ok || gd.Tok != token.VAR {
+			continue
+		}
+		for _, spec := range gd.Specs {
+			vs := spec.(*ast.ValueSpec)
+			for i, n := range vs.Names {
+				if v, ok := xvars[n.Name]; ok {
+					doc.Content = append(doc.Content, str(n.Name), ex.xvar(v))
+					continue
+				}
+				if i >= len(vs.Values) || isString(vs.Type) || isStringLit(vs.Values[i]) {
+					continue
+				}
+				typ, x := vs.Type, vs.Values[i]
+				if lit := compositeLit(x); lit != nil && lit.Type != nil {
+					typ, x = lit.Type, lit
+				}
+				if typ == nil {
+					return nil, fmt.Errorf("%s: the type of its initializer is not written out", n.Name)
+				}
+				node, err := ex.value(typ, x, n.Name)
+				if err != nil {
+					return nil, err
+				}
+				doc.Content = append(doc.Content, str(n.Name), node)
+			}
+		}
+	}
+	return doc, nil
+}
+
+type exporter struct {
+	*gen
+	pins []PinUse // every pin the document names, for CheckPins
+}
+
+// xvar is an -X variable's default, typed by its kind so that offSet comes out
+// as 9 and not "9". A default in the old shell syntax is given as the template
+// it translates to, since that is what a flag set from the document expects.
+func (ex *exporter) xvar(v Var) *yaml.Node {
+	def := v.Default
+	if strings.Contains(def, "$") {
+		if t, err := Translate(def); err == nil {
+			def = t
+		}
+	}
+	switch {
+	case v.Kind == Int && def != "":
+		if _, err := strconv.Atoi(def); err == nil {
+			return scalar("!!int", def)
+		}
+	case v.Kind == Bool && def != "":
+		if b, err := strconv.ParseBool(def); err == nil {
+			return scalar("!!bool", strconv.FormatBool(b))
+		}
+	}
+	return str(def)
+}
+
+func (ex *exporter) value(typ ast.Expr, x ast.Expr, at string) (*yaml.Node, error) {
+	if p, ok := x.(*ast.ParenExpr); ok {
+		return ex.value(typ, p.X, at)
+	}
+	switch t := typ.(type) {
+	case *ast.ParenExpr:
+		return ex.value(t.X, x, at)
+	case *ast.Ident:
+		return ex.named(t, x, at)
+	case *ast.SelectorExpr:
+		pkg, _ := t.X.(*ast.Ident)
+		switch {
+		case ex.isPin(t):
+			return ex.pin(x, at)
+		case pkg != nil && pkg.Name == ex.time && t.Sel.Name == "Duration":
+			return ex.duration(x, at)
+		}
+	case *ast.StarExpr:
+		if sel, ok := t.X.(*ast.SelectorExpr); ok {
+			if pkg, _ := sel.X.(*ast.Ident); pkg != nil && pkg.Name == ex.time && sel.Sel.Name == "Ticker" {
+				call, ok := x.(*ast.CallExpr)
+				if !ok || len(call.Args) != 1 || !ex.isTime(call.Fun, "NewTicker") {
+					return nil, fmt.Errorf("%s: only time.NewTicker(d) can be exported as a ticker", at)
+				}
+				return ex.duration(call.Args[0], at)
+			}
+		}
+		if u, ok := x.(*ast.UnaryExpr); ok && u.Op == token.AND {
+			x = u.X
+		}
+		return ex.value(t.X, x, at)
+	case *ast.ArrayType:
+		lit, ok := x.(*ast.CompositeLit)
+		if !ok {
+			return nil, fmt.Errorf("%s: not a literal", at)
+		}
+		seq := &yaml.Node{Kind: yaml.SequenceNode}
+		flow := true
+		for i, e := range lit.Elts {
+			if _, keyed := e.(*ast.KeyValueExpr); keyed {
+				return nil, fmt.Errorf("%s: elements given by index cannot be exported", at)
+			}
+			n, err := ex.value(t.Elt, e, fmt.Sprintf("%s[%d]", at, i))
+			if err != nil {
+				return nil, err
+			}
+			flow = flow && n.Kind == yaml.ScalarNode
+			seq.Content = append(seq.Content, n)
+		}
+		// A list of pins reads best on one line, as it does in Go.
+		if flow {
+			seq.Style = yaml.FlowStyle
+		}
+		return seq, nil
+	}
+	return nil, fmt.Errorf("%s: cannot export a %s", at, ex.typeText(typ))
+}
+
+func (ex *exporter) named(t *ast.Ident, x ast.Expr, at string) (*yaml.Node, error) {
+	switch t.Name {
+	case "string", "bool", "float32", "float64":
+		c, err := ex.constant(x, at)
+		if err != nil {
+			return nil, err
+		}
+		switch c.Kind() {
+		case constant.String:
+			return str(constant.StringVal(c)), nil
+		case constant.Bool:
+			return scalar("!!bool", c.String()), nil
+		case constant.Int, constant.Float:
+			f, _ := constant.Float64Val(c)
+			return scalar("!!float", strconv.FormatFloat(f, 'g', -1, 64)), nil
+		}
+		return nil, fmt.Errorf("%s: %s is not a %s", at, c, t.Name)
+	}
+	if _, _, ok := intRange(t.Name); ok {
+		c, err := ex.constant(x, at)
+		if err != nil {
+			return nil, err
+		}
+		if c.Kind() != constant.Int {
+			return nil, fmt.Errorf("%s: %s is not a whole number", at, c)
+		}
+		return scalar("!!int", c.ExactString()), nil
+	}
+
+	ts := typeSpec(ex.f, t.Name)
+	if ts == nil {
+		return nil, fmt.Errorf("%s: type %s is not declared in this file", at, t.Name)
+	}
+	st, ok := ts.Type.(*ast.StructType)
+	if !ok {
+		return ex.value(ts.Type, x, at)
+	}
+	lit, ok := x.(*ast.CompositeLit)
+	if !ok {
+		return nil, fmt.Errorf("%s: not a literal", at)
+	}
+	return ex.structLit(st, lit, at)
+}
+
+// structLit writes the fields in declaration order, each under its json name.
+// A field the literal leaves out is left out, except a pin: the zero Pin is
+// GP0, and --config would read a missing pin as NoPin.
+func (ex *exporter) structLit(st *ast.StructType, lit *ast.CompositeLit, at string) (*yaml.Node, error) {
+	given := map[string]ast.Expr{}
+	positional := len(lit.Elts) > 0
+	for _, e := range lit.Elts {
+		if kv, ok := e.(*ast.KeyValueExpr); ok {
+			positional = false
+			if k, ok := kv.Key.(*ast.Ident); ok {
+				given[k.Name] = kv.Value
+			}
+		}
+	}
+	i := 0
+	m := &yaml.Node{Kind: yaml.MappingNode}
+	for _, fl := range st.Fields.List {
+		for _, n := range fl.Names {
+			var x ast.Expr
+			if positional {
+				if i < len(lit.Elts) {
+					x = lit.Elts[i]
+				}
+				i++
+			} else {
+				x = given[n.Name]
+			}
+			key := jsonName(fl, n.Name)
+			if x == nil {
+				if ex.isPin(fl.Type) {
+					ex.pins = append(ex.pins, PinUse{Pin: 0, Where: at + "." + key + " (left out, so GP0)"})
+					m.Content = append(m.Content, str(key), str("GP0"))
+				}
+				continue
+			}
+			var v *yaml.Node
+			var err error
+			if key == "color" || key == "colour" {
+				v, err = ex.color(x, at+"."+key)
+			} else {
+				v, err = ex.value(fl.Type, x, at+"."+key)
+			}
+			if err != nil {
+				return nil, err
+			}
+			m.Content = append(m.Content, str(key), v)
+		}
+	}
+	return m, nil
+}
+
+// pinAliases are the machine package's other names for pins the firmware
+// might use in place of GPn.
+var pinAliases = map[string]int{"LED": 25, "ADC0": 26, "ADC1": 27, "ADC2": 28}
+
+func (ex *exporter) pin(x ast.Expr, at string) (*yaml.Node, error) {
+	n, err := ex.pinNumber(x, at)
+	if err != nil {
+		return nil, err
+	}
+	if n < 0 {
+		return str("NoPin"), nil
+	}
+	ex.pins = append(ex.pins, PinUse{Pin: n, Where: at})
+	return str(fmt.Sprintf("GP%d", n)), nil
+}
+
+// pinNumber is the GPIO a pin expression names, or -1 for NoPin.
+func (ex *exporter) pinNumber(x ast.Expr, at string) (int, error) {
+	if sel, ok := x.(*ast.SelectorExpr); ok {
+		if pkg, _ := sel.X.(*ast.Ident); pkg != nil && pkg.Name == ex.machine {
+			name := sel.Sel.Name
+			if name == "NoPin" {
+				return -1, nil
+			}
+			if n, ok := machinePin(name); ok {
+				return n, nil
+			}
+			return 0, fmt.Errorf("%s: unknown pin %s.%s", at, ex.machine, name)
+		}
+	}
+	c, err := ex.constant(x, at)
+	if err != nil {
+		return 0, err
+	}
+	n, ok := constant.Int64Val(c)
+	if !ok || n < 0 || n > 29 {
+		return 0, fmt.Errorf("%s: %s is not a pin", at, c)
+	}
+	return int(n), nil
+}
+
+// machinePin is the GPIO a machine package pin name stands for.
+func machinePin(name string) (int, bool) {
+	if n, ok := pinAliases[name]; ok {
+		return n, true
+	}
+	if n, err := strconv.Atoi(strings.TrimPrefix(name, "GP")); err == nil && strings.HasPrefix(name, "GP") {
+		return n, true
+	}
+	return 0, false
+}
+
+func (ex *exporter) duration(x ast.Expr, at string) (*yaml.Node, error) {
+	c, err := ex.constant(x, at)
+	if err != nil {
+		return nil, err
+	}
+	n, ok := constant.Int64Val(c)
+	if !ok {
+		return nil, fmt.Errorf("%s: %s is not a duration", at, c)
+	}
+	return str(time.Duration(n).String()), nil
+}
+
+// color names the constants a colour is made of the way --config is written:
+// a constant by its comment, a hi or bg variant by its base colour's, and a
+// foreground and a background as "fg on bg" — "bright white on bright blue"
+// rather than hiwht + hiblubg, which means nothing to someone choosing a
+// colour. A constant that has no such name, or whose name --config would not
+// read back as the same constant, is given as itself. A colour that is not
+// made of the file's constants has no name to give, and an escape sequence
+// would not survive the trip back through --config.
+func (ex *exporter) color(x ast.Expr, at string) (*yaml.Node, error) {
+	var names []string
+	var walk func(ast.Expr) bool
+	walk = func(x ast.Expr) bool {
+		switch v := x.(type) {
+		case *ast.ParenExpr:
+			return walk(v.X)
+		case *ast.BinaryExpr:
+			return v.Op == token.ADD && walk(v.X) && walk(v.Y)
+		case *ast.Ident:
+			if c, ok := ex.colors[strings.ToLower(v.Name)]; ok && c == v.Name {
+				names = append(names, ex.colorName(v.Name))
+				return true
+			}
+		case *ast.BasicLit:
+			return v.Value == `""`
+		}
+		return false
+	}
+	if !walk(x) {
+		return nil, fmt.Errorf("%s: not made of the file's colour constants", at)
+	}
+	if len(names) == 2 && !strings.HasSuffix(names[0], " background") {
+		if bg, ok := strings.CutSuffix(names[1], " background"); ok {
+			return str(names[0] + " on " + bg), nil
+		}
+	}
+	return str(strings.Join(names, " + ")), nil
+}
+
+// colorName is what --config calls the constant c, if colorConst finds c by
+// it, and otherwise c.
+func (ex *exporter) colorName(c string) string {
+	if n := ex.describeColor(c); n != "" {
+		if found, ok := ex.colorConst(n); ok && found == c {
+			return n
+		}
+	}
+	return c
+}
+
+// describeColor is colorConst the other way round: c's comment, or the name
+// of the constant c is the bright or background variant of, with "bright"
+// before it or "background" after it. It is "" if c has neither.
+func (ex *exporter) describeColor(c string) string {
+	for k, v := range ex.colors {
+		if v == c && k != strings.ToLower(c) {
+			return k
+		}
+	}
+	lc := strings.ToLower(c)
+	if base, ok := strings.CutSuffix(lc, "bg"); ok {
+		if b, ok := ex.colors[base]; ok {
+			if n := ex.describeColor(b); n != "" {
+				return n + " background"
+			}
+		}
+	}
+	if base, ok := strings.CutPrefix(lc, "hi"); ok {
+		if b, ok := ex.colors[base]; ok {
+			if n := ex.describeColor(b); n != "" {
+				return "bright " + n
+			}
+		}
+	}
+	return ""
+}
+
+// constant evaluates a constant expression: literals, the file's constants,
+// arithmetic, conversions, and time's units — enough for 333 * time.Millisecond.
+func (ex *exporter) constant(x ast.Expr, at string) (c constant.Value, err error) {
+	// go/constant panics on an operation its operands do not support, such
+	// as a string minus a string; that is a program the compiler would
+	// reject, and is reported as one that cannot be evaluated.
+	defer func() {
+		if recover() != nil {
+			c, err = nil, fmt.Errorf("%s: %s is not a valid constant expression", at, ex.typeText(x))
+		}
+	}()
+	c = ex.eval(x, map[string]bool{})
+	if c == nil || c.Kind() == constant.Unknown {
+		return nil, fmt.Errorf("%s: %s cannot be worked out without running the program", at, ex.typeText(x))
+	}
+	return c, nil
+}
+
+var timeUnits = map[string]time.Duration{
+	"Nanosecond": time.Nanosecond, "Microsecond": time.Microsecond, "Millisecond": time.Millisecond,
+	"Second": time.Second, "Minute": time.Minute, "Hour": time.Hour,
+}
+
+func (ex *exporter) eval(x ast.Expr, seen map[string]bool) constant.Value {
+	switch v := x.(type) {
+	case *ast.BasicLit:
+		return constant.MakeFromLiteral(v.Value, v.Kind, 0)
+	case *ast.ParenExpr:
+		return ex.eval(v.X, seen)
+	case *ast.Ident:
+		switch v.Name {
+		case "true", "false":
+			return constant.MakeBool(v.Name == "true")
+		}
+		if seen[v.Name] {
+			return nil
+		}
+		seen[v.Name] = true
+		if y := ex.constExpr(v.Name); y != nil {
+			return ex.eval(y, seen)
+		}
+	case *ast.SelectorExpr:
+		if pkg, _ := v.X.(*ast.Ident); pkg != nil && pkg.Name == ex.time {
+			if d, ok := timeUnits[v.Sel.Name]; ok {
+				return constant.MakeInt64(int64(d))
+			}
+		}
+	case *ast.UnaryExpr:
+		if y := ex.eval(v.X, seen); y != nil {
+			return constant.UnaryOp(v.Op, y, 0)
+		}
+	case *ast.BinaryExpr:
+		a, b := ex.eval(v.X, seen), ex.eval(v.Y, seen)
+		if a == nil || b == nil {
+			return nil
+		}
+		switch v.Op {
+		case token.SHL, token.SHR:
+			s, ok := constant.Uint64Val(b)
+			if !ok {
+				return nil
+			}
+			return constant.Shift(a, v.Op, uint(s))
+		case token.EQL, token.NEQ, token.LSS, token.LEQ, token.GTR, token.GEQ:
+			return constant.MakeBool(constant.Compare(a, v.Op, b))
+		case token.QUO:
+			if constant.Sign(b) == 0 {
+				return nil
+			}
+			if a.Kind() == constant.Int && b.Kind() == constant.Int {
+				return constant.BinaryOp(a, token.QUO_ASSIGN, b)
+			}
+		}
+		return constant.BinaryOp(a, v.Op, b)
+	case *ast.CallExpr:
+		// A conversion, time.Duration(5) or uint8(2), is its argument.
+		if len(v.Args) == 1 {
+			switch fun := v.Fun.(type) {
+			case *ast.Ident:
+				if _, _, ok := intRange(fun.Name); ok || typeSpec(ex.f, fun.Name) != nil {
+					return ex.eval(v.Args[0], seen)
+				}
+			case *ast.SelectorExpr:
+				if ex.isTime(fun, "Duration") {
+					return ex.eval(v.Args[0], seen)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (ex *exporter) isTime(x ast.Expr, name string) bool {
+	sel, ok := x.(*ast.SelectorExpr)
+	if !ok {
+		return false
+	}
+	pkg, _ := sel.X.(*ast.Ident)
+	return pkg != nil && pkg.Name == ex.time && sel.Sel.Name == name
+}
+
+// constExpr is the expression a package-level constant is declared with. A
+// constant given by iota is not followed; nothing configurable uses one.
+func (ex *exporter) constExpr(name string) ast.Expr {
+	for _, decl := range ex.f.Decls {
+		gd, ok := decl.(*ast.GenDecl)
+		if !ok || gd.Tok != token.CONST {
+			continue
+		}
+		for _, spec := range gd.Specs {
+			vs := spec.(*ast.ValueSpec)
+			for i, n := range vs.Names {
+				if n.Name == name && i < len(vs.Values) {
+					return vs.Values[i]
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func str(s string) *yaml.Node { return scalar("!!str", s) }
+
+func scalar(tag, value string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: tag, Value: value}
+}
+
+// JSON writes an exported document as indented JSON, keys in the order the
+// node has them, which a map would not keep.
+func JSON(n *yaml.Node) ([]byte, error) {
+	var b bytes.Buffer
+	if err := writeJSON(&b, n); err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	if err := json.Indent(&out, b.Bytes(), "", "  "); err != nil {
+		return nil, err
+	}
+	out.WriteByte('\n')
+	return out.Bytes(), nil
+}
+
+func writeJSON(b *bytes.Buffer, n *yaml.Node) error {
+	switch n.Kind {
+	case yaml.MappingNode, yaml.SequenceNode:
+		open, closing, step := "[", "]", 1
+		if n.Kind == yaml.MappingNode {
+			open, closing, step = "{", "}", 2
+		}
+		b.WriteString(open)
+		for i := 0; i < len(n.Content); i += step {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			if step == 2 {
+				k, _ := json.Marshal(n.Content[i].Value) //nolint:errcheck // a string always marshals
+				b.Write(k)
+				b.WriteByte(':')
+			}
+			if err := writeJSON(b, n.Content[i+step-1]); err != nil {
+				return err
+			}
+		}
+		b.WriteString(closing)
+	case yaml.ScalarNode:
+		if n.Tag == "!!str" {
+			s, _ := json.Marshal(n.Value) //nolint:errcheck // a string always marshals
+			b.Write(s)
+		} else {
+			b.WriteString(n.Value)
+		}
+	default:
+		return fmt.Errorf("cannot write a YAML node of kind %d as JSON", n.Kind)
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+// Package goprog reads the annotations a TinyGo program carries for mcu: which
+// of its package-level variables are meant to be set from the command line,
+// what they mean, and what their defaults are.
+//
+// The program is the one named by the GOPROG environment variable, which is
+// where the name comes from. Two kinds of variable are of interest. A string
+// variable with a trailing comment is set at link time with -X, the comment
+// giving its default:
+//
+//	// seconds to add to timeStamp
+//	var offSet string //9
+//
+// and any other initialised variable can be rewritten in the source before it
+// is compiled, which is how a struct literal reaches a device that cannot
+// decode JSON.
+//
+// GOPROG may be a file, a package directory or an import path; Load resolves
+// it and finds -X variables in the module's other packages as well.
+package goprog
+
+import (
+	"errors"
+	"go/ast"
+	"go/token"
+	"strings"
+)
+
+// Var is a string variable meant to be set with -X.
+type Var struct {
+	Name string
+
+	// Pkg is what -X qualifies the name with: "main" for the package being
+	// built, which is how the linker knows it whatever its import path, and
+	// the full import path for any other package.
+	Pkg string
+
+	// Flag is the name of the flag that sets the variable. In the main
+	// package it is the bare name; elsewhere it is qualified by the package
+	// name, so config.version and main.version are different flags.
+	Flag string
+
+	// Doc is the first paragraph of the declaration's doc comment, without
+	// the comment markers, or a //mcu:flag line's help. It is what the help
+	// menu shows. Directive lines are not part of it.
+	Doc string
+
+	// Default is the trailing comment as written, before any expansion.
+	Default string
+
+	// Kind is what the string is meant to hold. The linker can only set
+	// strings, so this is checked on the host instead: a value the device
+	// would parse into something else entirely is rejected before building.
+	Kind    Kind
+	Choices []string // for Enum
+
+	// Group is the heading the flag is listed under in the help menu, if
+	// not the usual one.
+	Group string
+	// Hidden keeps the flag out of the help menu.
+	Hidden bool
+	// Required refuses to build while the variable expands to "".
+	Required bool
+
+	// Err is a //mcu:flag line that could not be used, or a flag name mcu
+	// keeps for itself. The variable is still described by the conventions
+	// and still has a flag, so either costs a warning and not the flag.
+	Err error
+	// Pos is the //mcu:flag line, or the variable's name if it has none:
+	// where a warning about Err points. Load's Fset knows the file.
+	Pos token.Pos
+}
+
+// Symbol is the variable as -X names it.
+func (v Var) Symbol() string { return v.Pkg + "." + v.Name }
+
+// XVars returns the -X variables of a parsed file in declaration order: every
+// package-level string variable with a trailing comment or a //mcu:flag line.
+// Outside package main they are qualified by the package name alone; Load,
+// which knows the import path, qualifies them properly.
+func XVars(f *ast.File) []Var {
+	pkg := f.Name.Name
+	var vars []Var
+	for _, decl := range f.Decls {
+		gd, ok := decl.(*ast.GenDecl)
+		if !ok || gd.Tok != token.VAR {
+			continue
+		}
+		doc, dirs := splitDoc(gd.Doc)
+		for _, spec := range gd.Specs {
+			vs, ok := spec.(*ast.ValueSpec)
+			if !ok || !isString(vs.Type) {
+				continue
+			}
+			sdoc, sdirs := specDoc(vs, doc, dirs)
+			d, found, err := flagDirective(sdirs)
+			if vs.Comment == nil && !found {
+				continue
+			}
+
+			var def string
+			if vs.Comment != nil {
+				def = strings.TrimSpace(vs.Comment.Text())
+				def = strings.TrimSpace(strings.TrimPrefix(def, "//"))
+			}
+			for _, name := range vs.Names {
+				v := Var{Name: name.Name, Pkg: pkg, Flag: name.Name, Doc: sdoc, Default: def, Err: err, Pos: directivePos(name, vs.Doc, gd.Doc)}
+				if found && err == nil {
+					v.apply(d)
+				}
+				if !d.HasKind || err != nil {
+					v.Kind, v.Choices = infer(sdirs, v.Doc, v.Default)
+				}
+				if pkg != "main" {
+					v.Flag = pkg + "." + v.Flag
+				} else {
+					var rerr error
+					if v.Flag, rerr = unreserved(v.Flag, v.Name); rerr != nil {
+						v.Err = errors.Join(v.Err, rerr)
+					}
+				}
+				vars = append(vars, v)
+			}
+		}
+	}
+	return vars
+}
+
+func (v *Var) apply(d FlagDirective) {
+	if d.Name != "" {
+		v.Flag = d.Name
+	}
+	if d.Help != "" {
+		v.Doc = d.Help
+	}
+	if d.HasDefault {
+		v.Default = d.Default
+	}
+	if d.HasKind {
+		v.Kind, v.Choices = d.Kind, d.Choices
+	}
+	v.Group, v.Hidden, v.Required = d.Group, d.Hidden, d.Required
+}
+
+// splitDoc separates a doc comment into its prose and any //mcu: directives.
+// The prose is the first paragraph, joined into one line: a description that
+// runs onto a second line is still one description, and cutting it at the
+// first line break is what used to leave help text ending mid-sentence.
+func splitDoc(cg *ast.CommentGroup) (doc string, directives []string) {
+	if cg == nil {
+		return "", nil
+	}
+	var prose []string
+	ended := false
+	for _, c := range cg.List {
+		if d, ok := strings.CutPrefix(c.Text, "//mcu:"); ok {
+			directives = append(directives, strings.TrimSpace(d))
+			continue
+		}
+		text := strings.TrimPrefix(c.Text, "//")
+		if t, ok := strings.CutPrefix(text, "/*"); ok {
+			text = strings.TrimSuffix(t, "*/")
+		}
+		for _, line := range strings.Split(text, "\n") {
+			line = strings.TrimSpace(line)
+			switch {
+			case line == "" && len(prose) > 0:
+				ended = true
+			case line != "" && !ended:
+				prose = append(prose, line)
+			}
+		}
+	}
+	return strings.Join(prose, " "), directives
+}
+
+func isString(expr ast.Expr) bool {
+	id, ok := expr.(*ast.Ident)
+	return ok && id.Name == "string"
+}
@@ -0,0 +1,967 @@
+package goprog
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// src has the shapes that matter: the firmware's own -X variables with the
+// comments they really carry, a directive, a string with no default (not an
+// -X variable), and a non-string initialised variable.
+const src = `package main
+
+// Set timestamp $(date '+%Y-%m-%dT%H:%M:%SZ')
+var timeStamp string //$(date '+%Y-%m-%dT%H:%M:%SZ')
+
+// seconds to add to timeStamp
+var offSet string //9
+
+// set 'true' if RTC is set to the future
+var rtcFuture string //false
+
+// what the second display shows
+//mcu:type enum(clock|scroll)
+var mode string //clock
+
+// no trailing comment, so not settable
+var internal string
+
+// Configure DS1307 rtc i2c
+var ds1307i2c = DS1307{SDA: 0, SCL: 1}
+`
+
+func parse(t *testing.T, src string) *ast.File {
+	t.Helper()
+	f, err := parser.ParseFile(token.NewFileSet(), "main.go", src, parser.ParseComments)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	return f
+}
+
+func TestXVarsFindsStringVarsWithADefault(t *testing.T) {
+	vars := XVars(parse(t, src))
+	var names []string
+	for _, v := range vars {
+		names = append(names, v.Name)
+	}
+	want := []string{"timeStamp", "offSet", "rtcFuture", "mode"}
+	if len(names) != len(want) {
+		t.Fatalf("XVars = %v, want %v", names, want)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Errorf("XVars[%d] = %s, want %s (declaration order)", i, names[i], want[i])
+		}
+	}
+}
+
+// The firmware's comments were written for people, long before anything read
+// them for a type. They have to come out typed as they stand, or every
+// existing program needs editing before it benefits.
+func TestKindsAreInferredFromTheFirmwaresExistingComments(t *testing.T) {
+	got := map[string]Kind{}
+	for _, v := range XVars(parse(t, src)) {
+		got[v.Name] = v.Kind
+	}
+	for name, want := range map[string]Kind{
+		"timeStamp": Time,
+		"offSet":    Int, // mentions timeStamp, but holds seconds
+		"rtcFuture": Bool,
+		"mode":      Enum,
+	} {
+		if got[name] != want {
+			t.Errorf("%s is %v, want %v", name, got[name], want)
+		}
+	}
+}
+
+func TestADirectiveIsNotPartOfTheDoc(t *testing.T) {
+	for _, v := range XVars(parse(t, src)) {
+		if v.Name != "mode" {
+			continue
+		}
+		if v.Doc != "what the second display shows" {
+			t.Errorf("doc = %q", v.Doc)
+		}
+		if len(v.Choices) != 2 || v.Choices[0] != "clock" || v.Choices[1] != "scroll" {
+			t.Errorf("choices = %v", v.Choices)
+		}
+		return
+	}
+	t.Fatal("mode not found")
+}
+
+func TestDefaultIsTheTrailingCommentAsWritten(t *testing.T) {
+	for _, v := range XVars(parse(t, src)) {
+		if v.Name == "timeStamp" && v.Default != "$(date '+%Y-%m-%dT%H:%M:%SZ')" {
+			t.Errorf("default = %q", v.Default)
+		}
+	}
+}
+
+// These are the typos the kinds exist to catch: each one is silently turned
+// into false or 0 by the device's strconv.
+func TestValidateRejectsWhatTheDeviceWouldMisparse(t *testing.T) {
+	for _, tc := range []struct {
+		kind    Kind
+		value   string
+		choices []string
+		ok      bool
+	}{
+		{Bool, "true", nil, true},
+		{Bool, "ture", nil, false},
+		{Int, "9", nil, true},
+		{Int, "9s", nil, false},
+		{Duration, "333ms", nil, true},
+		{Duration, "333", nil, false},
+		{Time, "2024-03-22T11:56:20Z", nil, true},
+		{Time, "2024-03-22 11:56:20", nil, false},
+		{Enum, "clock", []string{"clock", "scroll"}, true},
+		{Enum, "Clock", []string{"clock", "scroll"}, false},
+		{String, "anything at all", nil, true},
+	} {
+		err := tc.kind.Validate(tc.value, tc.choices)
+		if (err == nil) != tc.ok {
+			t.Errorf("%v.Validate(%q) = %v, want ok=%v", tc.kind, tc.value, err, tc.ok)
+		}
+	}
+}
+
+func TestParseKind(t *testing.T) {
+	for _, tc := range []struct {
+		in      string
+		kind    Kind
+		choices int
+		wantErr bool
+	}{
+		{in: "int", kind: Int},
+		{in: " duration ", kind: Duration},
+		{in: "enum(a|b|c)", kind: Enum, choices: 3},
+		{in: "enum a,b", kind: Enum, choices: 2},
+		{in: "enum", wantErr: true},
+		{in: "float", wantErr: true},
+	} {
+		k, choices, err := ParseKind(tc.in)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("ParseKind(%q) = %v; want an error", tc.in, k)
+			}
+			continue
+		}
+		if err != nil || k != tc.kind || len(choices) != tc.choices {
+			t.Errorf("ParseKind(%q) = %v, %v, %v", tc.in, k, choices, err)
+		}
+	}
+}
+
+// A bare default is only trusted when it cannot be anything else.
+func TestInferFromValue(t *testing.T) {
+	for def, want := range map[string]Kind{
+		"":                     String,
+		"false":                Bool,
+		"9":                    Int,
+		"2024-03-22T11:56:20Z": Time,
+		"250ms":                Duration,
+		"hello":                String,
+		"$(date)":              String,
+	} {
+		if got := inferFromValue(def); got != want {
+			t.Errorf("inferFromValue(%q) = %v, want %v", def, got, want)
+		}
+	}
+}
+
+// The firmware's comments were written for bash. Each form they use must come
+// out as a template that means the same thing, or an old program silently
+// flashes the literal text of its comment.
+func TestTranslateTheShellFormsDefaultsUsed(t *testing.T) {
+	for in, want := range map[string]string{
+		"$(date '+%Y-%m-%dT%H:%M:%SZ')": `{{now "2006-01-02T15:04:05Z"}}`,
+		"$(date -u +%H:%M)":             `{{utc "15:04"}}`,
+		"$(date +%s)":                   `{{unix}}`,
+		"v$(git describe --tags)":       `v{{git "describe" "--tags"}}`,
+		"$(hostname)-$USER":             `{{hostname}}-{{env "USER"}}`,
+		"${HOME}/x":                     `{{env "HOME"}}/x`,
+		"$(cat VERSION)":                `{{file "VERSION"}}`,
+		"9":                             "9",
+		"costs $5":                      "costs $5",
+	} {
+		got, err := Translate(in)
+		if err != nil || got != want {
+			t.Errorf("Translate(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+}
+
+// Guessing at what a shell would have done is how a default ends up wrong on
+// the device, so anything outside the known forms is refused.
+func TestTranslateRefusesWhatItCannotExpress(t *testing.T) {
+	for _, in := range []string{
+		"$(curl example.com)",
+		"$(date '+%Y%q')",
+		"$(date '+at %H')", // "at" is literal text, "a" would be read as a field
+		"$(date",
+	} {
+		if got, err := Translate(in); err == nil {
+			t.Errorf("Translate(%q) = %q; want an error", in, got)
+		}
+	}
+}
+
+func TestExpand(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "VERSION"), []byte("1.2.3\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("MCU_TEST", "pico")
+	env := Env{Dir: dir, Now: func() time.Time { return time.Date(2024, 3, 22, 11, 56, 20, 0, time.UTC) }}
+	for in, want := range map[string]string{
+		`{{now "2006-01-02T15:04:05Z"}}`:        "2024-03-22T11:56:20Z",
+		`{{now}}`:                               "2024-03-22T11:56:20Z",
+		`{{unix}}`:                              "1711108580",
+		`{{env "MCU_TEST"}}-{{file "VERSION"}}`: "pico-1.2.3",
+		"plain":                                 "plain",
+		"{not a template}":                      "{not a template}",
+	} {
+		got, err := env.Expand(in)
+		if err != nil || got != want {
+			t.Errorf("Expand(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	if _, err := env.Expand(`{{sh "rm -rf /"}}`); err == nil {
+		t.Error("an unknown function expanded; want an error")
+	}
+}
+
+// A program that keeps its settings in another package of the module must
+// have them found there, and named for -X by their full import path.
+func TestLoadFindsVarsInImportedPackages(t *testing.T) {
+	t.Setenv("GOFLAGS", "")
+	dir := t.TempDir()
+	for name, src := range map[string]string{
+		"go.mod": "module example.com/clock\n\ngo 1.21\n",
+		"main.go": `package main
+
+import "example.com/clock/config"
+
+// seconds to add
+var offSet string //9
+
+func main() { println(offSet, config.Version) }
+`,
+		"config/config.go": `package config
+
+// firmware version
+var Version string //dev
+`,
+	} {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o750); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(src), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, target := range []string{dir, filepath.Join(dir, "main.go")} {
+		prog, err := Load(target)
+		if err != nil {
+			t.Fatalf("Load(%s): %v", target, err)
+		}
+		got := map[string]string{}
+		for _, v := range prog.Vars {
+			got[v.Flag] = v.Symbol()
+		}
+		want := map[string]string{
+			"offSet":         "main.offSet",
+			"config.Version": "example.com/clock/config.Version",
+		}
+		if len(got) != len(want) {
+			t.Errorf("Load(%s) vars = %v, want %v", target, got, want)
+		}
+		for flag, sym := range want {
+			if got[flag] != sym {
+				t.Errorf("Load(%s): --%s sets %q, want %q", target, flag, got[flag], sym)
+			}
+		}
+	}
+}
+
+// editSrc is the shape of the firmware's displays: an array of structs with
+// an elided element type, and a struct literal with fields left out.
+const editSrc = `package main
+
+type Display struct {
+	Mode string
+	Clvl uint8
+	Rows int16
+}
+
+type Pins struct{ SDA, SCL int }
+
+var displays = [...]Display{{Mode: "clock", Clvl: 2}, {Mode: "scroll", Clvl: 3}}
+
+var pins = Pins{0, 1}
+`
+
+func source(t *testing.T, src string) *Source {
+	t.Helper()
+	s, err := ParseSource("main.go", []byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestEditChangesOnlyWhatItAddresses(t *testing.T) {
+	s := source(t, editSrc)
+	for _, set := range []string{
+		`displays[1].Mode="Happy birthday"`,
+		`displays[0].Clvl=3`,
+		`displays[0].Rows=4`, // left out of the literal, so added
+		`displays[0].Rows=5`, // and changed again, not added twice
+		`pins.SCL=5`,         // a positional literal, addressed by name
+	} {
+		e, err := ParseEdit(set)
+		if err != nil {
+			t.Fatalf("ParseEdit(%s): %v", set, err)
+		}
+		if err := e.Apply(s); err != nil {
+			t.Fatalf("%s: %v", set, err)
+		}
+	}
+	got, err := s.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := strings.NewReplacer(
+		`{{Mode: "clock", Clvl: 2}, {Mode: "scroll", Clvl: 3}}`, `{{Mode: "clock", Clvl: 3, Rows: 5}, {Mode: "Happy birthday", Clvl: 3}}`,
+		`Pins{0, 1}`, `Pins{0, 5}`,
+	).Replace(editSrc)
+	if string(got) != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+// Everything eval did not change must come out byte for byte, comments
+// included; that is what makes --diff worth reading.
+func TestSourceKeepsWhatWasNotChanged(t *testing.T) {
+	const src = `package main
+
+// Configure DS1307 rtc i2c
+var ds1307i2c = DS1307{SDA: 0, SCL: 1} // the default pads
+
+/* a block comment */
+var other = []int{1, 2, 3}
+`
+	s := source(t, src)
+	vs, _ := findVar(s.File, "ds1307i2c")
+	if err := s.Replace(*vs, "DS1307{SDA: 4, SCL: 5}", "--ds1307i2c"); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := strings.Replace(src, "DS1307{SDA: 0, SCL: 1}", "DS1307{SDA: 4, SCL: 5}", 1); string(got) != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+	if d := Diff("main.go", []byte(src), got); !strings.Contains(d, "-var ds1307i2c = DS1307{SDA: 0, SCL: 1} // the default pads\n+var ds1307i2c = DS1307{SDA: 4, SCL: 5} // the default pads\n") {
+		t.Errorf("diff:\n%s", d)
+	}
+}
+
+func TestOverlappingEditsAreRefused(t *testing.T) {
+	s := source(t, editSrc)
+	for i, set := range []string{`displays[0]=Display{}`, `displays[0].Clvl=1`} {
+		e, err := ParseEdit(set)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = e.Apply(s)
+		if (err != nil) != (i == 1) {
+			t.Errorf("%s: %v", set, err)
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	a := []byte("a\nb\nc\nd\ne\nf\ng\nh\ni\nj\n")
+	b := []byte("a\nB\nc\nd\ne\nf\ng\nh\ni\nj\nk\n")
+	want := `--- f
++++ f
+@@ -1,5 +1,5 @@
+ a
+-b
++B
+ c
+ d
+ e
+@@ -8,3 +8,4 @@
+ h
+ i
+ j
++k
+`
+	if got := Diff("f", a, b); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+	if got := Diff("f", a, a); got != "" {
+		t.Errorf("identical files diff to %q", got)
+	}
+}
+
+func TestEditErrors(t *testing.T) {
+	for s, want := range map[string]string{
+		`displays[2].Mode="x"`:  "index 2 out of range: displays has 2 elements",
+		`displays[0].Mood="x"`:  "unknown field Mood",
+		`nope.X=1`:              "no variable nope",
+		`displays[0].Rows.X=1`:  "displays[0].Rows is not in the literal",
+		`displays[0].Mode[1]=1`: "displays[0].Mode is not a composite literal",
+	} {
+		e, err := ParseEdit(s)
+		if err == nil {
+			err = e.Apply(source(t, editSrc))
+		}
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: error %v, want one containing %q", s, err, want)
+		}
+	}
+	for _, s := range []string{`displays`, `displays=1`, `displays[x].Mode=1`, `displays[0].Mode=m.`} {
+		if _, err := ParseEdit(s); err == nil {
+			t.Errorf("ParseEdit(%s) succeeded; want an error", s)
+		}
+	}
+}
+
+// The stub is only useful if the firmware it stands in for checks cleanly
+// against it; otherwise every error there would have to be filtered out.
+func TestFirmwareChecksAgainstTheMachineStub(t *testing.T) {
+	for _, name := range []string{"../firmware/main.go", "../firmware/relay/main.go", "../firmware/bible/main.go"} {
+		s, err := ParseSource(name, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		problems, err := check(name, s.Src, filepath.Dir(name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range problems {
+			t.Errorf("%s", p)
+		}
+	}
+}
+
+func TestCheckReportsAnErrorAgainstTheFlagThatCausedIt(t *testing.T) {
+	const src = `package main
+
+import m "machine"
+
+type DS1307 struct{ SDA, SCL m.Pin }
+
+var ds1307i2c = DS1307{SDA: m.GP0, SCL: m.GP1}
+
+var pins = []m.Pin{m.GP2}
+
+func main() { _, _ = ds1307i2c, pins }
+`
+	s := source(t, src)
+	e, err := ParseEdit("ds1307i2c.SDA=m.GP99")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Apply(s); err != nil {
+		t.Fatal(err)
+	}
+	slot, _ := findVar(s.File, "pins")
+	if err := s.Replace(*slot, "[]m.Pin{m.GP3}", "--pins"); err != nil {
+		t.Fatal(err)
+	}
+	problems, err := s.Check(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 1 {
+		t.Fatalf("problems = %v, want one", problems)
+	}
+	want := "--set ds1307i2c.SDA: main.go:7:31: undefined: m.GP99\n" +
+		"\tvar ds1307i2c = DS1307{SDA: m.GP99, SCL: m.GP1}\n" +
+		"\t                              ^"
+	if got := problems[0].String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+// A document decodes to maps, lists, strings and numbers; what comes out must
+// be the firmware's own Go, and must type-check as it.
+func TestConfigureWritesTypedLiterals(t *testing.T) {
+	s, err := ParseSource("../firmware/main.go", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := map[string]any{
+		"displays": []any{
+			map[string]any{
+				"mode":             "clock",
+				"color":            "bright white on bright blue",
+				"speed":            "333ms",
+				"data_pins":        []any{"GP22", "gp21", 20},
+				"rs":               "GP26",
+				"contrast_percent": 2,
+			},
+			map[string]any{"mode": "scroll", "color": "blk + Green background", "speed": "1.5s"},
+		},
+		"ds1307i2c": map[string]any{"sda": "GP4", "scl": 5},
+		"offSet":    9,
+	}
+	vars, rest, err := s.Configure("clock.yaml", doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(vars, " ") != "displays ds1307i2c" || strings.Join(rest, " ") != "offSet" {
+		t.Errorf("vars = %v, rest = %v", vars, rest)
+	}
+	problems, err := s.Check("../firmware")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range problems {
+		t.Errorf("%s", p)
+	}
+	b, err := s.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`var displays = [...]HD44780{{Mode: "clock", Speed: time.NewTicker(333 * time.Millisecond), Color: hiwht + hiblubg, DataPins: []m.Pin{m.GP22, m.GP21, m.GP20}, RS: m.GP26, EN: m.NoPin, RW: m.NoPin, Contrast: m.NoPin, Clvl: 2}, {Mode: "scroll", Speed: time.NewTicker(1500 * time.Millisecond), Color: blk + grnbg, RS: m.NoPin, EN: m.NoPin, RW: m.NoPin, Contrast: m.NoPin}}`,
+		`var ds1307i2c = DS1307{SDA: m.GP4, SCL: m.GP5}`,
+	} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("rewritten source does not contain\n%s", want)
+		}
+	}
+}
+
+func TestConfigErrors(t *testing.T) {
+	s, err := ParseSource("../firmware/main.go", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for want, doc := range map[string]map[string]any{
+		`displays[0]: HD44780 has no field "colr"`:                {"displays": []any{map[string]any{"colr": "red"}}},
+		"displays[0].rs: there is no GP30":                        {"displays": []any{map[string]any{"rs": "GP30"}}},
+		`displays[0].color: no colour constant named "teal"`:      {"displays": []any{map[string]any{"color": "teal"}}},
+		"displays[0].contrast_percent: 300 does not fit in uint8": {"displays": []any{map[string]any{"contrast_percent": 300}}},
+		"displays[0].speed: want a duration":                      {"displays": []any{map[string]any{"speed": 333}}},
+		"displays: want a list":                                   {"displays": map[string]any{"mode": "clock"}},
+		"ds1307i2c: want a mapping of DS1307's fields":            {"ds1307i2c": []any{0, 1}},
+	} {
+		if _, _, err := ConfigLiterals(s.File, doc); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("error %v, want one containing %q", err, want)
+		}
+	}
+}
+
+// What Export writes, --config must read back into the same program: exporting
+// again gives the same document.
+func TestExportRoundTripsThroughConfig(t *testing.T) {
+	for _, name := range []string{"../firmware/main.go", "../firmware/relay/main.go"} {
+		s, err := ParseSource(name, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		node, err := Export(s.File)
+		if err != nil {
+			t.Fatal(err)
+		}
+		first, err := JSON(node)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var doc map[string]any
+		if err := node.Decode(&doc); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := s.Configure("exported", doc); err != nil {
+			t.Fatal(err)
+		}
+		b, err := s.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d := Diff(name, s.Src, b); d != "" {
+			t.Errorf("%s: --config of its own export changed it:\n%s", name, d)
+		}
+		again, err := ParseSource(name, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		node, err = Export(again.File)
+		if err != nil {
+			t.Fatal(err)
+		}
+		second, err := JSON(node)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(first) != string(second) {
+			t.Errorf("%s: exported\n%s\nthen, after --config,\n%s", name, first, second)
+		}
+	}
+}
+
+func TestExportEvaluatesConstantExpressions(t *testing.T) {
+	const src = `package main
+
+import (
+	m "machine"
+	"time"
+)
+
+const (
+	red   = "\033[31m" // Red
+	whtbg = "\033[47m" // White background
+	base  = 2
+)
+
+type Display struct {
+	Color string      ` + "`json:\"color\"`" + `
+	Every time.Duration ` + "`json:\"every\"`" + `
+	Clvl  uint8       ` + "`json:\"contrast_percent\"`" + `
+	RS, EN m.Pin
+}
+
+// seconds to add to timeStamp
+var offSet string //9
+
+var d = &Display{Color: (red + whtbg), Every: time.Duration(base) * 1500 * time.Millisecond, Clvl: base << 2, RS: m.LED}
+
+var pins = [2]m.Pin{3, m.GP4}
+`
+	node, err := Export(source(t, src).File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := JSON(node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{
+  "offSet": 9,
+  "d": {
+    "color": "red on white",
+    "every": "3s",
+    "contrast_percent": 8,
+    "RS": "GP25",
+    "EN": "GP0"
+  },
+  "pins": [
+    "GP3",
+    "GP4"
+  ]
+}
+`
+	if string(got) != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+// A colour is exported by the names --config takes, and a constant is named
+// only if --config would find that same constant by the name.
+func TestExportNamesColoursAsConfigReadsThem(t *testing.T) {
+	const src = `package main
+
+const (
+	ylw     = "\033[33m" // Yellow
+	wht     = "\033[37m" // White
+	hiyel   = "\033[93m"
+	hiwht   = "\033[97m"
+	blubg   = "\033[44m" // Blue background
+	hiblubg = "\033[104m"
+)
+
+type Display struct {
+	Color string ` + "`json:\"color\"`" + `
+}
+
+var ds = []Display{{Color: hiwht + hiblubg}, {Color: ylw + wht}, {Color: hiyel + blubg}, {Color: ""}}
+`
+	node, err := Export(source(t, src).File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc struct{ Ds []struct{ Color string } }
+	if err := node.Decode(&doc); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"bright white on bright blue", "yellow + white", "hiyel on blue", ""}
+	for i, d := range doc.Ds {
+		if i >= len(want) || d.Color != want[i] {
+			t.Errorf("ds[%d].color = %q, want %q", i, d.Color, want[i])
+		}
+	}
+}
+
+func TestExportRefusesWhatOnlyRunningCouldTell(t *testing.T) {
+	for want, decl := range map[string]string{
+		"d.every: f() cannot be worked out":             `var d = Display{Every: f()}`,
+		"d.color: not made of the file's colour":        `var d = Display{Color: "\033[31m"}`,
+		"t[0]: only time.NewTicker(d) can be exported":  `var t = [1]*time.Ticker{ticker()}`,
+		"x: the type of its initializer is not written": `var x = f()`,
+	} {
+		src := "package main\n\nimport \"time\"\n\ntype Display struct {\n\tColor string `json:\"color\"`\n\tEvery time.Duration `json:\"every\"`\n}\n\n" + decl + "\n"
+		if _, err := Export(source(t, src).File); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: error %v, want one containing %q", decl, err, want)
+		}
+	}
+}
+
+// A variable named after one of mcu's own flags gets the qualified flag -X
+// would name it by, and a warning, rather than a second --config that pflag
+// would panic at before any command ran.
+func TestReservedNamesAreQualified(t *testing.T) {
+	f := parse(t, `package main
+
+// where to read settings from
+var config string //mcu.yaml
+
+var set = [2]int{1, 2}
+
+var setting = [2]int{3, 4}
+`)
+	vars := XVars(f)
+	if len(vars) != 1 || vars[0].Flag != "main.config" || vars[0].Err == nil || !strings.Contains(vars[0].Err.Error(), "--config is mcu's own flag") {
+		t.Errorf("config = %+v", vars)
+	}
+	inits := Inits(f)
+	if len(inits) != 2 {
+		t.Fatalf("inits = %+v", inits)
+	}
+	if in := inits[0]; in.Flag != "main.set" || in.Err == nil {
+		t.Errorf("set = %+v, want --main.set and a warning", in)
+	}
+	if in := inits[1]; in.Flag != "setting" || in.Err != nil {
+		t.Errorf("setting = %+v, want it left alone", in)
+	}
+}
+
+func TestParseFlagDirective(t *testing.T) {
+	d, err := ParseFlagDirective(` name=offset type=int default=9 help="hours east of UTC, \"in seconds\"" group=time hidden required`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := FlagDirective{Name: "offset", Help: `hours east of UTC, "in seconds"`, Group: "time", Default: "9", HasDefault: true, Kind: Int, HasKind: true, Hidden: true, Required: true}
+	if d.Name != want.Name || d.Help != want.Help || d.Group != want.Group || d.Default != want.Default || !d.HasDefault ||
+		d.Kind != want.Kind || !d.HasKind || !d.Hidden || !d.Required {
+		t.Errorf("got %+v\nwant %+v", d, want)
+	}
+	if d, err := ParseFlagDirective("choices=clock|scroll default=scroll"); err != nil || d.Kind != Enum || len(d.Choices) != 2 {
+		t.Errorf("choices: %+v, %v", d, err)
+	}
+
+	for s, want := range map[string]string{
+		"colour=red":            `unknown key "colour"`,
+		"name":                  `"name" needs a value`,
+		`help="unterminated`:    "unterminated string",
+		"type=float":            `unknown type "float"`,
+		"type=int choices=a|b":  "choices for a int",
+		"choices=a|b default=c": `default: "c" is not one of a, b`,
+		"hidden=maybe":          "want true or false",
+		"name=--offset":         "is not a flag name",
+		"name=config":           "name config is mcu's own flag",
+	} {
+		if _, err := ParseFlagDirective(s); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: error %v, want one containing %q", s, err, want)
+		}
+	}
+}
+
+// The directive says what the conventions cannot, and the conventions fill in
+// whatever it leaves out; a directive that cannot be read costs a warning,
+// not the flag.
+func TestFlagDirectiveOverridesTheConventions(t *testing.T) {
+	const src = `package main
+
+// seconds to add to timeStamp,
+// which the RTC keeps as local time
+//
+// Not part of the description.
+//mcu:flag name=offset group=time
+var offSet string //9
+
+//mcu:flag default=clock choices=clock|scroll help="what the display shows" hidden
+var mode string
+
+//mcu:flag required
+var serial string
+
+// set 'true' to blink
+//mcu:flag colour=red
+var blink string //true
+
+// no directive and no default, so not settable
+var internal string
+
+// Configure DS1307 rtc i2c
+//mcu:flag name=rtc group=hardware required
+var ds1307i2c = DS1307{SDA: 0, SCL: 1}
+
+//mcu:flag default=1
+var pins = []int{1}
+`
+	f := parse(t, src)
+	got := map[string]Var{}
+	for _, v := range XVars(f) {
+		got[v.Name] = v
+	}
+	if len(got) != 4 {
+		t.Fatalf("vars = %v, want offSet, mode, serial, blink", got)
+	}
+	if v := got["offSet"]; v.Flag != "offset" || v.Group != "time" || v.Kind != Int || v.Default != "9" ||
+		v.Doc != "seconds to add to timeStamp, which the RTC keeps as local time" {
+		t.Errorf("offSet = %+v", v)
+	}
+	if v := got["mode"]; v.Kind != Enum || v.Default != "clock" || !v.Hidden || v.Doc != "what the display shows" {
+		t.Errorf("mode = %+v", v)
+	}
+	if v := got["serial"]; !v.Required || v.Default != "" || v.Kind != String {
+		t.Errorf("serial = %+v", v)
+	}
+	if v := got["blink"]; v.Err == nil || v.Kind != Bool || v.Flag != "blink" {
+		t.Errorf("blink = %+v, want the conventions and an error", v)
+	}
+
+	inits := Inits(f)
+	if len(inits) != 2 {
+		t.Fatalf("inits = %+v", inits)
+	}
+	if in := inits[0]; in.Flag != "rtc" || in.Group != "hardware" || !in.Required || in.Doc != "Configure DS1307 rtc i2c" || in.Err != nil {
+		t.Errorf("ds1307i2c = %+v", in)
+	}
+	if in := inits[1]; in.Err == nil || in.Flag != "pins" {
+		t.Errorf("pins = %+v, want an error: an initializer is its own default", in)
+	}
+}
+
+// A directive that names one of mcu's own flags is refused like any other
+// that cannot be used, and the warning points at the line, not the variable.
+func TestAReservedDirectiveNameIsReportedAtTheDirective(t *testing.T) {
+	s := source(t, `package main
+
+var (
+	// where to read settings from
+	//mcu:flag name=profile group=setup
+	where string //mcu.yaml
+
+	//mcu:flag name=set
+	pins = [2]int{1, 2}
+
+	plain = [2]int{3, 4}
+)
+`)
+	vars := XVars(s.File)
+	if len(vars) != 1 || vars[0].Flag != "where" || vars[0].Group != "" || vars[0].Err == nil ||
+		!strings.Contains(vars[0].Err.Error(), "name profile is mcu's own flag") {
+		t.Fatalf("where = %+v, want the conventions and an error", vars)
+	}
+	if pos := s.Fset.Position(vars[0].Pos); pos.Line != 5 || pos.Column != 2 {
+		t.Errorf("where's warning is at %s, want main.go:5:2", pos)
+	}
+	inits := Inits(s.File)
+	if len(inits) != 2 || inits[0].Flag != "pins" || inits[0].Err == nil {
+		t.Fatalf("inits = %+v", inits)
+	}
+	if pos := s.Fset.Position(inits[0].Pos); pos.Line != 8 {
+		t.Errorf("pins' warning is at %s, want line 8", pos)
+	}
+	if pos := s.Fset.Position(inits[1].Pos); pos.Line != 11 || pos.Column != 2 {
+		t.Errorf("plain is at %s, want its name, main.go:11:2", pos)
+	}
+}
+
+func TestFirmwarePinsDoNotConflict(t *testing.T) {
+	for _, name := range []string{"../firmware/main.go", "../firmware/relay/main.go", "../firmware/bible/main.go"} {
+		s, err := ParseSource(name, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		problems, err := CheckPins(s.Fset, s.File)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range problems {
+			t.Errorf("%s: %s", name, p)
+		}
+	}
+}
+
+// The relay firmware's second display, commented out because its pins are the
+// relays', is what the check is for.
+func TestCheckPinsFindsTheRelayFirmwaresSecondDisplay(t *testing.T) {
+	s, err := ParseSource("../firmware/relay/main.go", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second := map[string]any{
+		"mode": "bible", "data_pins": []any{"GP5", "GP6", "GP7", "GP8", "GP9", "GP10"},
+		"rs": "GP4", "en": "GP3", "rw": "NoPin", "contrast": "GP12", "rows": 2, "columns": 20,
+	}
+	first := map[string]any{
+		"mode": "clock", "data_pins": []any{"GP22", "GP21", "GP20", "GP19", "GP18", "GP17", "GP16", "GP15"},
+		"rs": "GP26", "en": "GP27", "rw": "NoPin", "contrast": "GP28", "rows": 2, "columns": 16,
+	}
+	doc := map[string]any{
+		"displays":  []any{first, second},
+		"ds1307i2c": map[string]any{"sda": "GP2", "scl": "GP3"},
+	}
+	if _, _, err := s.Configure("t.yaml", doc); err != nil {
+		t.Fatal(err)
+	}
+	b, err := s.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err = ParseSource("main.go", b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	problems, err := CheckPins(s.Fset, s.File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := strings.Join(problems, "\n")
+	for _, want := range []string{
+		"GP6 is used by relays[0] and displays[1].data_pins[1]",
+		"GP12 is used by relays[6] and displays[1].contrast",
+		"displays[1].data_pins: 6 pins",
+		"displays[1].contrast: GP12 is on PWM6 channel A, which displays[0].contrast already drives",
+		"ds1307i2c: GP2 and GP3 are I2C1's pads, but the firmware configures I2C0",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("no %q in\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "GP13") || strings.Contains(got, "GP25") {
+		t.Errorf("conflicts that are not there:\n%s", got)
+	}
+}
+
+func TestCheckPinsCountsPinsTheCodeNames(t *testing.T) {
+	src := "package main\n\nimport m \"machine\"\n\nvar relays = []m.Pin{m.GP25, m.GP2}\n\nfunc main() {\n\tled := m.LED\n\tled.High()\n\t_ = m.LED\n}\n"
+	s := source(t, src)
+	problems, err := CheckPins(s.Fset, s.File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 1 || problems[0] != "GP25 is used by relays[0] and main.go:8 (m.LED)" {
+		t.Errorf("problems = %q", problems)
+	}
+}
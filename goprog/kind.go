@@ -0,0 +1,144 @@
+package goprog
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Kind is what an -X string is meant to hold once the device parses it.
+//
+// -X only sets strings, so every generated flag used to be a string flag, and a
+// typo went straight through: --rtcFuture=ture reaches strconv.ParseBool on
+// the device, which returns false and an error nobody checks. Knowing the kind
+// lets the host refuse the value instead.
+type Kind int
+
+// The kinds a variable can be declared or inferred as.
+const (
+	String Kind = iota
+	Bool
+	Int
+	Duration // time.ParseDuration
+	Time     // RFC3339
+	Enum     // one of Var.Choices
+)
+
+var kindNames = [...]string{"string", "bool", "int", "duration", "time", "enum"}
+
+func (k Kind) String() string {
+	if int(k) < len(kindNames) {
+		return kindNames[k]
+	}
+	return fmt.Sprintf("Kind(%d)", int(k))
+}
+
+// ParseKind reads a kind as written in a directive. An enum is written with
+// its choices: "enum(clock|scroll)" or "enum clock,scroll".
+func ParseKind(s string) (Kind, []string, error) {
+	s = strings.TrimSpace(s)
+	if rest, ok := strings.CutPrefix(s, "enum"); ok {
+		rest = strings.TrimSpace(rest)
+		rest = strings.TrimSuffix(strings.TrimPrefix(rest, "("), ")")
+		choices := strings.FieldsFunc(rest, func(r rune) bool { return r == '|' || r == ',' || r == ' ' })
+		if len(choices) == 0 {
+			return String, nil, fmt.Errorf("enum with no choices")
+		}
+		return Enum, choices, nil
+	}
+	for i, n := range kindNames {
+		if s == n && Kind(i) != Enum {
+			return Kind(i), nil, nil
+		}
+	}
+	return String, nil, fmt.Errorf("unknown type %q (want one of string, bool, int, duration, time, enum(a|b))", s)
+}
+
+// Validate reports whether s is a value of this kind, in the form the device
+// will parse it.
+func (k Kind) Validate(s string, choices []string) error {
+	var err error
+	switch k {
+	case Bool:
+		_, err = strconv.ParseBool(s)
+	case Int:
+		_, err = strconv.Atoi(s)
+	case Duration:
+		_, err = time.ParseDuration(s)
+	case Time:
+		_, err = time.Parse(time.RFC3339, s)
+	case Enum:
+		for _, c := range choices {
+			if s == c {
+				return nil
+			}
+		}
+		return fmt.Errorf("%q is not one of %s", s, strings.Join(choices, ", "))
+	}
+	if err != nil {
+		return fmt.Errorf("%q is not a valid %s", s, k)
+	}
+	return nil
+}
+
+// Keywords in a doc comment that say what a variable holds. These are what the
+// firmware's existing comments already say — "set 'true' if ...", "seconds to
+// add ..." — so the flags come out typed without the source being touched.
+var (
+	docBool     = regexp.MustCompile(`(?i)'(true|false)'|\b(enable|disable)s?\b`)
+	docTime     = regexp.MustCompile(`(?i)\b(RFC ?3339|timestamp)\b`)
+	docDuration = regexp.MustCompile(`(?i)\bduration\b`)
+	docInt      = regexp.MustCompile(`(?i)\b(seconds|number of|count|index|percent)\b`)
+)
+
+// infer works out a variable's kind. A //mcu:type directive is definite. After
+// that the doc comment is trusted over the default, because a default can be
+// ambiguous — "9" is an int and also a perfectly good string — where "set
+// 'true' if" is not.
+func infer(directives []string, doc, def string) (Kind, []string) {
+	for _, d := range directives {
+		if t, ok := strings.CutPrefix(d, "type"); ok {
+			if k, choices, err := ParseKind(t); err == nil {
+				return k, choices
+			}
+		}
+	}
+	// Order matters: "seconds to add to timeStamp" mentions a timestamp
+	// but holds a number of seconds, so a unit outranks the thing it is a
+	// unit of.
+	switch {
+	case docBool.MatchString(doc):
+		return Bool, nil
+	case docInt.MatchString(doc):
+		return Int, nil
+	case docDuration.MatchString(doc):
+		return Duration, nil
+	case docTime.MatchString(doc):
+		return Time, nil
+	}
+	return inferFromValue(def), nil
+}
+
+// inferFromValue guesses a kind from a default value alone. Only unambiguous
+// shapes count; anything else stays a string.
+func inferFromValue(def string) Kind {
+	if def == "" {
+		return String
+	}
+	if def == "true" || def == "false" {
+		return Bool
+	}
+	if _, err := strconv.Atoi(def); err == nil {
+		return Int
+	}
+	if _, err := time.Parse(time.RFC3339, def); err == nil {
+		return Time
+	}
+	// Atoi already took bare numbers, so a duration here has a unit.
+	if _, err := time.ParseDuration(def); err == nil {
+		return Duration
+	}
+	return String
+}
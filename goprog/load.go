@@ -0,0 +1,219 @@
+package goprog
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Program is what GOPROG names, loaded: the package to build and the packages
+// of the same module it imports, with the -X variables of all of them.
+//
+// GOPROG used to be a single file, parsed on its own, and every variable was
+// main.something. A program that keeps its settings in a config package could
+// not have them set at all. GOPROG may now also be a directory or an import
+// path, which tinygo accepts just the same, and a variable is found in
+// whichever package of the module declares it.
+type Program struct {
+	// Dir is the main package's directory; defaults that read files or run
+	// git do so relative to it.
+	Dir string
+
+	// Packages are the main package first, then the module's packages it
+	// imports, in the order go list reports them.
+	Packages []*Package
+
+	// Vars are the -X variables of every package, in the same order.
+	Vars []Var
+
+	// Fset has the files the Vars were read from, for their positions.
+	Fset *token.FileSet
+}
+
+// Package is one loaded package.
+type Package struct {
+	ImportPath string
+	Name       string
+	Dir        string
+	Files      []string // absolute paths of the files that are built
+}
+
+// Dirs returns the directory of every package, which is what a watch on the
+// program has to cover.
+func (p *Program) Dirs() []string {
+	dirs := make([]string, 0, len(p.Packages))
+	for _, pkg := range p.Packages {
+		dirs = append(dirs, pkg.Dir)
+	}
+	return dirs
+}
+
+// Load resolves GOPROG the way the go command does and reads the -X variables
+// out of the result.
+//
+// Resolution is go list's, so build constraints, the module and vendoring
+// all mean what they mean to a build. The machine package only exists inside
+// tinygo, so go list is run with -e and a package that fails to import is
+// still listed. If there is no go command, or the file is outside any module
+// and go list refuses it, a file or a directory is read on its own, which is
+// all the old behaviour ever did.
+func Load(target string) (*Program, error) {
+	pkgs, err := goList(target)
+	if err != nil {
+		var lerr error
+		if pkgs, lerr = readLocal(target); lerr != nil {
+			return nil, fmt.Errorf("%w (and reading it directly: %v)", err, lerr)
+		}
+	}
+
+	fset := token.NewFileSet()
+	prog := &Program{Dir: pkgs[0].Dir, Packages: pkgs, Fset: fset}
+	seen := map[string]string{}
+	for _, pkg := range pkgs {
+		for _, name := range pkg.Files {
+			f, err := parser.ParseFile(fset, name, nil, parser.ParseComments)
+			if err != nil {
+				return nil, err
+			}
+			for _, v := range XVars(f) {
+				if f.Name.Name != "main" {
+					v.Pkg = pkg.ImportPath
+				}
+				// Two packages of the same name, a/config and
+				// b/config, would give the same flag twice.
+				if other, dup := seen[v.Flag]; dup && other != v.Pkg {
+					v.Flag = v.Symbol()
+				}
+				seen[v.Flag] = v.Pkg
+				prog.Vars = append(prog.Vars, v)
+			}
+		}
+	}
+	return prog, nil
+}
+
+// listed is the part of go list -json that Load uses.
+type listed struct {
+	ImportPath string
+	Name       string
+	Dir        string
+	GoFiles    []string
+	DepOnly    bool
+	Standard   bool
+	Module     *struct{ Main bool }
+	Error      *struct{ Err string }
+}
+
+func goList(target string) ([]*Package, error) {
+	// go list is run from the program's own directory, so that its module
+	// is the one found, and would read a bare "firmware" as an import path
+	// rather than the directory that tinygo takes it to be.
+	arg, dir := target, ""
+	if fi, err := os.Stat(target); err == nil {
+		if fi.IsDir() {
+			arg, dir = ".", target
+		} else {
+			arg, dir = filepath.Base(target), filepath.Dir(target)
+		}
+	}
+	cmd := exec.Command("go", "list", "-e", "-deps", "-json", "--", arg)
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	b, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("go list %s: %v: %s", target, err, strings.TrimSpace(stderr.String()))
+	}
+
+	var root *listed
+	var deps []*listed
+	dec := json.NewDecoder(bytes.NewReader(b))
+	for {
+		var p listed
+		if err := dec.Decode(&p); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		switch {
+		case !p.DepOnly && root == nil:
+			root = &p
+		case !p.Standard && p.Module != nil:
+			deps = append(deps, &p)
+		}
+	}
+	if root == nil {
+		return nil, fmt.Errorf("go list %s: no package", target)
+	}
+	if len(root.GoFiles) == 0 {
+		if root.Error != nil {
+			return nil, fmt.Errorf("go list %s: %s", target, root.Error.Err)
+		}
+		return nil, fmt.Errorf("go list %s: no Go files", target)
+	}
+
+	pkgs := []*Package{toPackage(root)}
+	for _, p := range deps {
+		// Main is the module go list was run in. A file given on its
+		// own is listed with no module, but its imports still have one.
+		if p.Module.Main {
+			pkgs = append(pkgs, toPackage(p))
+		}
+	}
+	return pkgs, nil
+}
+
+func toPackage(p *listed) *Package {
+	pkg := &Package{ImportPath: p.ImportPath, Name: p.Name, Dir: p.Dir}
+	for _, f := range p.GoFiles {
+		pkg.Files = append(pkg.Files, filepath.Join(p.Dir, f))
+	}
+	return pkg
+}
+
+// readLocal is Load without the go command: one file, or every non-test file
+// of one directory, with no imports followed.
+func readLocal(target string) ([]*Package, error) {
+	fi, err := os.Stat(target)
+	if err != nil {
+		return nil, err
+	}
+	abs, err := filepath.Abs(target)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return []*Package{{ImportPath: "command-line-arguments", Name: "main", Dir: filepath.Dir(abs), Files: []string{abs}}}, nil
+	}
+	pkg := &Package{ImportPath: "command-line-arguments", Name: "main", Dir: abs}
+	matches, err := filepath.Glob(filepath.Join(abs, "*.go"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(matches)
+	for _, m := range matches {
+		if !strings.HasSuffix(m, "_test.go") {
+			pkg.Files = append(pkg.Files, m)
+		}
+	}
+	if len(pkg.Files) == 0 {
+		return nil, fmt.Errorf("%s: no Go files", target)
+	}
+	return []*Package{pkg}, nil
+}
+
+// MainFile reports whether the program is a single file, which is the only
+// form eval and ef can rewrite: they print one file back out for tinygo.
+func MainFile(target string) bool {
+	fi, err := os.Stat(target)
+	return err == nil && !fi.IsDir() && strings.HasSuffix(target, ".go")
+}
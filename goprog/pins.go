@@ -0,0 +1,228 @@
+package goprog
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// PinUse is one claim on a GPIO: a field or element of an initializer, by its
+// path in the document Export writes, or a line of code that names the pin
+// itself, such as led := machine.LED.
+type PinUse struct {
+	Pin   int
+	Where string
+	Code  bool // named in a function, not an initializer
+}
+
+// CheckPins finds pin assignments that cannot all work at once, without
+// building or flashing anything:
+//
+//   - a GPIO claimed twice, by two initializers or by one and the code;
+//   - two contrast pins on the same PWM slice and channel, which would give
+//     both displays whatever duty cycle was set last;
+//   - SDA and SCL that are not an I2C bus's pads, not on the same bus, or on
+//     a bus the firmware does not configure;
+//   - a display whose data_pins are neither 4 nor 8, which the HD44780
+//     driver refuses at boot, with nothing on the screen to say so.
+//
+// The rules go by the json names the firmwares give their fields — contrast,
+// sda, scl and data_pins — so they hold for any firmware that uses the same
+// names. The relay firmware is the one that needs it: its relays sit on
+// GP6–GP13, which is where the second display's data pins were.
+//
+// The configuration is worked out the way Export works it out, so a file it
+// cannot export cannot be checked either; err says where.
+func CheckPins(fset *token.FileSet, f *ast.File) (problems []string, err error) {
+	ex := &exporter{gen: newGen(f)}
+	doc, err := ex.export(f)
+	if err != nil {
+		return nil, err
+	}
+	uses := append(ex.pins, codePins(fset, f, ex.machine)...)
+
+	byPin := map[int][]PinUse{}
+	for _, u := range uses {
+		if u.Pin > 29 {
+			problems = append(problems, fmt.Sprintf("%s: GP%d is not a GPIO on the RP2040 (GP0–GP29)", u.Where, u.Pin))
+			continue
+		}
+		byPin[u.Pin] = append(byPin[u.Pin], u)
+	}
+	pins := make([]int, 0, len(byPin))
+	for p := range byPin {
+		pins = append(pins, p)
+	}
+	sort.Ints(pins)
+	for _, p := range pins {
+		// The code naming a pin more than once is the code using it, not
+		// two things claiming it.
+		var claims []string
+		code := 0
+		for _, u := range byPin[p] {
+			if u.Code {
+				if code++; code > 1 {
+					continue
+				}
+			}
+			claims = append(claims, u.Where)
+		}
+		if len(claims) > 1 {
+			problems = append(problems, fmt.Sprintf("GP%d is used by %s", p, strings.Join(claims, " and ")))
+		}
+	}
+
+	bus := configuredI2C(f, ex.machine)
+	pwm := map[string]string{}
+	walkDoc(doc, "", func(at string, n *yaml.Node) {
+		if n.Kind != yaml.MappingNode {
+			return
+		}
+		fields := map[string]*yaml.Node{}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			fields[n.Content[i].Value] = n.Content[i+1]
+		}
+		if dp := fields["data_pins"]; dp != nil && dp.Kind == yaml.SequenceNode && len(dp.Content) != 4 && len(dp.Content) != 8 {
+			problems = append(problems, fmt.Sprintf("%s.data_pins: %d pins; an HD44780 takes 4 or 8", at, len(dp.Content)))
+		}
+		if p, ok := gpio(fields["contrast"]); ok {
+			slice := fmt.Sprintf("PWM%d channel %c", p/2%8, 'A'+p%2)
+			if other, taken := pwm[slice]; taken {
+				problems = append(problems, fmt.Sprintf("%s.contrast: GP%d is on %s, which %s already drives", at, p, slice, other))
+			} else {
+				pwm[slice] = at + ".contrast"
+			}
+		}
+		sda, okSDA := gpio(fields["sda"])
+		scl, okSCL := gpio(fields["scl"])
+		if !okSDA || !okSCL {
+			return
+		}
+		sdaBus, sclBus := -1, -1
+		switch sda % 4 {
+		case 0:
+			sdaBus = 0
+		case 2:
+			sdaBus = 1
+		default:
+			problems = append(problems, fmt.Sprintf("%s.sda: GP%d is not an SDA pad (I2C0: GP0, GP4, ... GP28; I2C1: GP2, GP6, ... GP26)", at, sda))
+		}
+		switch scl % 4 {
+		case 1:
+			sclBus = 0
+		case 3:
+			sclBus = 1
+		default:
+			problems = append(problems, fmt.Sprintf("%s.scl: GP%d is not an SCL pad (I2C0: GP1, GP5, ... GP29; I2C1: GP3, GP7, ... GP27)", at, scl))
+		}
+		switch {
+		case sdaBus < 0 || sclBus < 0:
+		case sdaBus != sclBus:
+			problems = append(problems, fmt.Sprintf("%s: SDA GP%d is on I2C%d but SCL GP%d is on I2C%d", at, sda, sdaBus, scl, sclBus))
+		case bus >= 0 && sdaBus != bus:
+			problems = append(problems, fmt.Sprintf("%s: GP%d and GP%d are I2C%d's pads, but the firmware configures I2C%d", at, sda, scl, sdaBus, bus))
+		}
+	})
+	return problems, nil
+}
+
+// codePins are the pins the file's functions name directly. The
+// initializers are left to Export, which knows where in them each pin is.
+func codePins(fset *token.FileSet, f *ast.File, machine string) []PinUse {
+	var uses []PinUse
+	for _, decl := range f.Decls {
+		fd, ok := decl.(*ast.FuncDecl)
+		if !ok || fd.Body == nil {
+			continue
+		}
+		ast.Inspect(fd.Body, func(n ast.Node) bool {
+			sel, ok := n.(*ast.SelectorExpr)
+			if !ok {
+				return true
+			}
+			if pkg, _ := sel.X.(*ast.Ident); pkg != nil && pkg.Name == machine {
+				if p, ok := machinePin(sel.Sel.Name); ok {
+					pos := fset.Position(sel.Pos())
+					where := fmt.Sprintf("%s:%d (%s.%s)", filepath.Base(pos.Filename), pos.Line, machine, sel.Sel.Name)
+					uses = append(uses, PinUse{Pin: p, Where: where, Code: true})
+				}
+			}
+			return true
+		})
+	}
+	return uses
+}
+
+// configuredI2C is the I2C bus the file calls Configure on, or -1 if it
+// configures neither or both.
+func configuredI2C(f *ast.File, machine string) int {
+	found := map[int]bool{}
+	ast.Inspect(f, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+		fun, ok := call.Fun.(*ast.SelectorExpr)
+		if !ok || fun.Sel.Name != "Configure" {
+			return true
+		}
+		if sel, ok := fun.X.(*ast.SelectorExpr); ok {
+			if pkg, _ := sel.X.(*ast.Ident); pkg != nil && pkg.Name == machine {
+				switch sel.Sel.Name {
+				case "I2C0":
+					found[0] = true
+				case "I2C1":
+					found[1] = true
+				}
+			}
+		}
+		return true
+	})
+	if len(found) != 1 {
+		return -1
+	}
+	if found[0] {
+		return 0
+	}
+	return 1
+}
+
+// walkDoc calls fn on every node of an exported document with its path, in
+// the form the exporter's errors use.
+func walkDoc(n *yaml.Node, at string, fn func(string, *yaml.Node)) {
+	if at != "" {
+		fn(at, n)
+	}
+	switch n.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key := n.Content[i].Value
+			if at != "" {
+				key = at + "." + key
+			}
+			walkDoc(n.Content[i+1], key, fn)
+		}
+	case yaml.SequenceNode:
+		for i, c := range n.Content {
+			walkDoc(c, fmt.Sprintf("%s[%d]", at, i), fn)
+		}
+	}
+}
+
+// gpio is the number of an exported pin, GPn; NoPin and a missing field are
+// not one.
+func gpio(n *yaml.Node) (int, bool) {
+	if n == nil || n.Kind != yaml.ScalarNode {
+		return 0, false
+	}
+	var p int
+	if _, err := fmt.Sscanf(n.Value, "GP%d", &p); err != nil {
+		return 0, false
+	}
+	return p, true
+}
@@ -0,0 +1,293 @@
+package goprog
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strconv"
+	"strings"
+)
+
+// Edit is one change inside a variable's initializer, as given to --set:
+//
+//	displays[1].Mode="Happy birthday"
+//	ds1307i2c.SDA=m.GP4
+//
+// Replacing a whole variable means passing its whole literal back on the
+// command line, all eight data pins of both displays included, to change one
+// string. An Edit addresses the one element or field instead and leaves the
+// rest of the literal as the source has it.
+type Edit struct {
+	Var   string
+	Path  []Step
+	Value string // a Go expression
+}
+
+// Step is one element of an Edit's path: an index or a field name.
+type Step struct {
+	Index int
+	Field string // "" for an index
+}
+
+func (e Edit) String() string {
+	var b strings.Builder
+	b.WriteString(e.Var)
+	for _, s := range e.Path {
+		if s.Field != "" {
+			b.WriteString("." + s.Field)
+		} else {
+			fmt.Fprintf(&b, "[%d]", s.Index)
+		}
+	}
+	return b.String()
+}
+
+// ParseEdit reads path=value. The value must be a Go expression; it is checked
+// here so a typo is reported against the --set that made it, not as a syntax
+// error somewhere in the middle of the rewritten file.
+func ParseEdit(s string) (Edit, error) {
+	path, value, ok := strings.Cut(s, "=")
+	if !ok {
+		return Edit{}, fmt.Errorf("--set %s: want path=value", s)
+	}
+	path, value = strings.TrimSpace(path), strings.TrimSpace(value)
+	if _, err := parser.ParseExpr(value); err != nil {
+		return Edit{}, fmt.Errorf("--set %s: %s is not a Go expression: %v", s, value, err)
+	}
+
+	e := Edit{Value: value}
+	i := strings.IndexAny(path, ".[")
+	if i < 0 {
+		i = len(path)
+	}
+	e.Var, path = path[:i], path[i:]
+	if !token.IsIdentifier(e.Var) {
+		return Edit{}, fmt.Errorf("--set %s: %q is not a variable name", s, e.Var)
+	}
+	for path != "" {
+		switch path[0] {
+		case '.':
+			path = path[1:]
+			n := strings.IndexAny(path, ".[")
+			if n < 0 {
+				n = len(path)
+			}
+			if !token.IsIdentifier(path[:n]) {
+				return Edit{}, fmt.Errorf("--set %s: %q is not a field name", s, path[:n])
+			}
+			e.Path = append(e.Path, Step{Field: path[:n]})
+			path = path[n:]
+		case '[':
+			n := strings.IndexByte(path, ']')
+			if n < 0 {
+				return Edit{}, fmt.Errorf("--set %s: unterminated [", s)
+			}
+			idx, err := strconv.Atoi(path[1:n])
+			if err != nil || idx < 0 {
+				return Edit{}, fmt.Errorf("--set %s: %q is not an index", s, path[1:n])
+			}
+			e.Path = append(e.Path, Step{Index: idx})
+			path = path[n+1:]
+		default:
+			return Edit{}, fmt.Errorf("--set %s: unexpected %q", s, path[:1])
+		}
+	}
+	if len(e.Path) == 0 {
+		return Edit{}, fmt.Errorf("--set %s: that is the whole variable; use --%s", s, e.Var)
+	}
+	return e, nil
+}
+
+// Apply makes the edit in s. The element or field must exist in the literal,
+// with one exception: a field the literal leaves out is added, if the struct
+// is declared in the same file and has it — that is the only way to tell a
+// field left at its zero value from a typo.
+func (e Edit) Apply(s *Source) error {
+	f := s.File
+	slot, typ := findVar(f, e.Var)
+	if slot == nil {
+		return fmt.Errorf("--set %s: no variable %s with an initializer", e, e.Var)
+	}
+	at := e.Var
+	for i, step := range e.Path {
+		lit := compositeLit(*slot)
+		if lit == nil {
+			return fmt.Errorf("--set %s: %s is not a composite literal", e, at)
+		}
+		if lit.Type != nil {
+			typ = lit.Type
+		}
+		typ = underlying(f, typ)
+
+		if step.Field == "" {
+			if len(lit.Elts) > 0 {
+				if _, keyed := lit.Elts[0].(*ast.KeyValueExpr); keyed {
+					return fmt.Errorf("--set %s: %s has keyed elements, which cannot be indexed", e, at)
+				}
+			}
+			if step.Index >= len(lit.Elts) {
+				return fmt.Errorf("--set %s: index %d out of range: %s has %d elements", e, step.Index, at, len(lit.Elts))
+			}
+			slot = &lit.Elts[step.Index]
+			typ = elemType(typ)
+			at += fmt.Sprintf("[%d]", step.Index)
+			continue
+		}
+
+		st, _ := typ.(*ast.StructType)
+		var err error
+		slot, typ, err = field(lit, st, step.Field)
+		if err != nil {
+			return fmt.Errorf("--set %s: %s: %w", e, at, err)
+		}
+		at += "." + step.Field
+		if slot == nil {
+			if i < len(e.Path)-1 {
+				return fmt.Errorf("--set %s: %s is not in the literal, so there is nothing inside it to set", e, at)
+			}
+			// Not in the literal: added after its last element.
+			text, pos := step.Field+": "+e.Value, lit.Rbrace
+			if n := len(lit.Elts); n > 0 {
+				text, pos = ", "+text, lit.Elts[n-1].End()
+			}
+			if err := s.insert(pos, at, text, "--set "+at); err != nil {
+				return fmt.Errorf("--set %s: %w", e, err)
+			}
+			return nil
+		}
+	}
+	if err := s.Replace(*slot, e.Value, "--set "+e.String()); err != nil {
+		return fmt.Errorf("--set %s: %w", e, err)
+	}
+	return nil
+}
+
+// findVar returns the initializer of a package-level variable, as a slot it
+// can be replaced through, and its declared type if it has one.
+func findVar(f *ast.File, name string) (*ast.Expr, ast.Expr) {
+	for _, decl := range f.Decls {
+		gd, ok := decl.(*ast.GenDecl)
+		if !ok || gd.Tok != token.VAR {
+			continue
+		}
+		for _, spec := range gd.Specs {
+			vs := spec.(*ast.ValueSpec)
+			for i, n := range vs.Names {
+				if n.Name == name && i < len(vs.Values) {
+					return &vs.Values[i], vs.Type
+				}
+			}
+		}
+	}
+	return nil, nil
+}
+
+// compositeLit sees through & and parentheses, which a literal of pointers
+// carries on each element.
+func compositeLit(x ast.Expr) *ast.CompositeLit {
+	for {
+		switch v := x.(type) {
+		case *ast.CompositeLit:
+			return v
+		case *ast.UnaryExpr:
+			if v.Op != token.AND {
+				return nil
+			}
+			x = v.X
+		case *ast.ParenExpr:
+			x = v.X
+		default:
+			return nil
+		}
+	}
+}
+
+// underlying resolves a type name declared in f to its definition. Anything
+// else — a type from another package, or no type at all — is returned as is;
+// the edit can still go ahead, it just cannot add missing fields.
+func underlying(f *ast.File, typ ast.Expr) ast.Expr {
+	for i := 0; i < 10; i++ { // a bound, in case of type T U; type U T
+		switch t := typ.(type) {
+		case *ast.StarExpr:
+			typ = t.X
+			continue
+		case *ast.Ident:
+			if ts := typeSpec(f, t.Name); ts != nil {
+				typ = ts.Type
+				continue
+			}
+		}
+		return typ
+	}
+	return typ
+}
+
+func typeSpec(f *ast.File, name string) *ast.TypeSpec {
+	for _, decl := range f.Decls {
+		gd, ok := decl.(*ast.GenDecl)
+		if !ok || gd.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range gd.Specs {
+			if ts := spec.(*ast.TypeSpec); ts.Name.Name == name {
+				return ts
+			}
+		}
+	}
+	return nil
+}
+
+func elemType(typ ast.Expr) ast.Expr {
+	switch t := typ.(type) {
+	case *ast.ArrayType:
+		return t.Elt
+	case *ast.MapType:
+		return t.Value
+	}
+	return nil
+}
+
+// field finds name in a struct literal. st is the struct's declaration when it
+// is known, and lets a positional literal be addressed by name, or a field the
+// literal leaves out be added: for that, the slot returned is nil.
+func field(lit *ast.CompositeLit, st *ast.StructType, name string) (*ast.Expr, ast.Expr, error) {
+	var ftype ast.Expr
+	pos := -1
+	if st != nil {
+		i := 0
+		for _, fl := range st.Fields.List {
+			for _, n := range fl.Names {
+				if n.Name == name {
+					ftype, pos = fl.Type, i
+				}
+				i++
+			}
+		}
+		if pos < 0 {
+			return nil, nil, fmt.Errorf("unknown field %s", name)
+		}
+	}
+
+	keyed := len(lit.Elts) == 0
+	for _, elt := range lit.Elts {
+		kv, ok := elt.(*ast.KeyValueExpr)
+		if !ok {
+			break
+		}
+		keyed = true
+		if id, ok := kv.Key.(*ast.Ident); ok && id.Name == name {
+			return &kv.Value, ftype, nil
+		}
+	}
+	switch {
+	case st == nil:
+		return nil, nil, fmt.Errorf("no field %s in the literal, and its type is not declared in this file", name)
+	case !keyed:
+		if pos >= len(lit.Elts) {
+			return nil, nil, fmt.Errorf("field %s is missing from the literal", name)
+		}
+		return &lit.Elts[pos], ftype, nil
+	}
+	return nil, ftype, nil
+}
@@ -0,0 +1,121 @@
+package goprog
+
+import (
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"os"
+	"sort"
+)
+
+// Source is a Go file being rewritten: its text as read, and the changes to
+// make to it as byte ranges.
+//
+// eval used to swap nodes in the syntax tree and print the whole tree back
+// out. A node made that way has no position, so the printer has nowhere to
+// hang the comments around it; they drifted, and a diff against the original
+// was noise from top to bottom. Splicing new text into the old keeps every
+// byte that was not changed, and gofmt then tidies only what was.
+type Source struct {
+	Name string
+	Src  []byte
+	Fset *token.FileSet
+	File *ast.File
+
+	splices []splice
+}
+
+type splice struct {
+	start, end int // byte offsets; start == end for an insertion
+	key        string
+	text       string
+	origin     string // the flag or --set that asked for it
+}
+
+// ParseSource reads and parses a file. src, if not nil, is used in place of
+// the file's contents.
+func ParseSource(name string, src []byte) (*Source, error) {
+	if src == nil {
+		var err error
+		if src, err = os.ReadFile(name); err != nil { //nolint:gosec // the file GOPROG names
+			return nil, err
+		}
+	}
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, name, src, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	return &Source{Name: name, Src: src, Fset: fset, File: f}, nil
+}
+
+// Text returns the source text of a node.
+func (s *Source) Text(n ast.Node) string {
+	return string(s.Src[s.offset(n.Pos()):s.offset(n.End())])
+}
+
+func (s *Source) offset(p token.Pos) int { return s.Fset.Position(p).Offset }
+
+// Replace replaces the text of n. origin is what asked for the change, such as
+// "--displays", and is what a type error in the new text is reported against.
+// Replacing the same node again replaces the earlier replacement; replacing
+// part of a node already replaced, or the other way round, is an error,
+// because one of the two would be lost.
+func (s *Source) Replace(n ast.Node, text, origin string) error {
+	start, end := s.offset(n.Pos()), s.offset(n.End())
+	return s.add(splice{start: start, end: end, key: fmt.Sprintf("%d-%d", start, end), text: text, origin: origin})
+}
+
+// insert adds text at p. key identifies the insertion, so that making the same
+// one twice replaces it rather than inserting twice.
+func (s *Source) insert(p token.Pos, key, text, origin string) error {
+	off := s.offset(p)
+	return s.add(splice{start: off, end: off, key: key, text: text, origin: origin})
+}
+
+func (s *Source) add(sp splice) error {
+	for i, o := range s.splices {
+		if o.key == sp.key {
+			s.splices[i] = sp
+			return nil
+		}
+		if sp.start < o.end && o.start < sp.end {
+			return fmt.Errorf("overlaps an earlier change to %s", s.Src[o.start:o.end])
+		}
+	}
+	s.splices = append(s.splices, sp)
+	return nil
+}
+
+// Changed reports whether anything has been replaced or inserted.
+func (s *Source) Changed() bool { return len(s.splices) > 0 }
+
+// spliced returns the file with every change made, before gofmt, and where
+// in it each change's text ended up.
+func (s *Source) spliced() ([]byte, []splice) {
+	sp := append([]splice(nil), s.splices...)
+	sort.SliceStable(sp, func(i, j int) bool { return sp[i].start < sp[j].start })
+	var out []byte
+	last := 0
+	for i, x := range sp {
+		out = append(out, s.Src[last:x.start]...)
+		last = x.end
+		sp[i].start = len(out)
+		out = append(out, x.text...)
+		sp[i].end = len(out)
+	}
+	return append(out, s.Src[last:]...), sp
+}
+
+// Bytes returns the rewritten file, gofmt'd. An error here means a change
+// made the file invalid Go.
+func (s *Source) Bytes() ([]byte, error) {
+	out, _ := s.spliced()
+	b, err := format.Source(out)
+	if err != nil {
+		return out, fmt.Errorf("%s: the rewritten source does not parse: %w", s.Name, err)
+	}
+	return b, nil
+}
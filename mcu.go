@@ -19,15 +19,15 @@ Help menu --flags for subcommands are auto-generated from the .go file reference
 
 import (
 	"bufio"
-	"bytes"
 	"fmt"
 	"go/ast"
-	"go/parser"
 	"go/printer"
 	"go/token"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -38,6 +38,9 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 	"github.com/tarm/serial"
+	"go.yaml.in/yaml/v3"
+
+	"github.com/0magnet/tinygo-stuff/goprog"
 )
 
 func main() {
@@ -53,6 +56,11 @@ func main() {
 		NoExtraNewlines: true,
 		NoBottomNewline: true,
 	})
+	if err := prepare(os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+	registerCompletions()
 	if err := RootCmd.Execute(); err != nil {
 		log.Fatal("Failed to execute command: ", err)
 	}
@@ -68,9 +76,33 @@ func out(s string) {
 	_, _ = script.Echo(s).Stdout() //nolint:errcheck,gosec
 }
 
+// notice is for what the setup has to say before any command runs. It goes to
+// stderr: stdout may be a file being written, eval --export > clock.yaml, or
+// the shell's, a list of completions or the script that asks for them, and a
+// notice there would be a line of YAML, a completion or a command.
+func notice(s string) {
+	fmt.Fprint(os.Stderr, s)
+}
+
+// noArgs refuses arguments to a command that takes none. Its flags with an
+// optional value are where one turns up: pflag only reads the value after an
+// =, so eval --export json exports YAML and leaves json lying on the command
+// line, which cobra would otherwise ignore. example is the form that works.
+func noArgs(example string) cobra.PositionalArgs {
+	return func(cmd *cobra.Command, args []string) error {
+		if len(args) > 0 {
+			return fmt.Errorf("%s takes no arguments, got %q (a flag's optional value goes after an =, as in %s)", cmd.CommandPath(), args[0], example)
+		}
+		return nil
+	}
+}
+
+// defaultFirmware is the program mcu suggests, and schematic draws, when
+// GOPROG is not set.
+const defaultFirmware = "firmware/main.go"
+
 var (
-	fset      *token.FileSet
-	node      *ast.File
+	source    *goprog.Source
 	goProg    string
 	ttyUSB    string
 	baud      int
@@ -80,7 +112,6 @@ var (
 )
 
 func init() {
-	RootCmd.CompletionOptions.DisableDefaultCmd = true
 	RootCmd.Flags().StringVarP(&ttyUSB, "ser", "m", "", "block device for serial interface (i.e. \"/dev/ttyACM0\")\nif unspecified serial connection will not be attempted")
 	RootCmd.Flags().IntVarP(&baud, "baud", "b", 9600, "baud rate")
 	var helpflag bool
@@ -208,154 +239,124 @@ var sendCmd = &cobra.Command{
 
 func init() {
 	RootCmd.AddCommand(evalCmd)
-
+	evalCmd.Flags().BoolVar(&evalDiff, "diff", false, "print a unified diff against GOPROG instead of the whole source")
+	evalCmd.Flags().BoolVar(&evalWrite, "write", false, "rewrite GOPROG in place, keeping the original as GOPROG.bak")
+	evalCmd.Flags().StringVar(&evalExport, "export", "", "print the configuration as yaml or json instead of the source, in the form --config reads")
+	evalCmd.Flags().Lookup("export").NoOptDefVal = "yaml"
+	evalCmd.Flags().BoolVar(&evalCheck, "check", false, "check the pin assignments for conflicts instead of printing the source\n(ef and flash check them before building)")
+	efCmd.Flags().BoolVar(&efKeep, "keep", false, "keep the generated source file, and print where it is")
+	for _, c := range []*cobra.Command{evalCmd, efCmd} {
+		c.Flags().StringVar(&configFile, "config", "", "set initializers and -X variables from a JSON or YAML file, keyed by\nvariable name and the structs' json tags")
+		c.Flags().StringArrayVar(&sets, "set", nil, "change one element or field of an initializer, i.e. 'displays[1].Mode=\"Happy birthday\"'\n(repeatable; the value is a Go expression)")
+	}
 	goProg = os.Getenv("GOPROG")
-	if goProg != "" {
-
-		fset = token.NewFileSet()
-		var err error
-		node, err = parser.ParseFile(fset, goProg, nil, parser.ParseComments)
-		if err != nil {
-			fmt.Println("Error parsing file:", err)
-			os.Exit(1)
-		}
-
-		var commentAbove string
-
-		for _, decl := range node.Decls {
-			genDecl, ok := decl.(*ast.GenDecl)
-			if !ok || genDecl.Tok != token.VAR {
-				continue
-			}
+}
 
-			if genDecl.Doc != nil && len(genDecl.Doc.List) > 0 {
-				commentAbove = genDecl.Doc.List[0].Text
-			} else {
-				commentAbove = ""
-			}
+// initFlags maps each initialised variable to its flag, which a //mcu:flag
+// line may have named differently. A variable whose flag was taken is not in
+// it.
+var initFlags = map[string]string{}
 
-			for _, spec := range genDecl.Specs {
-				valueSpec, ok := spec.(*ast.ValueSpec)
-				if !ok || len(valueSpec.Values) == 0 {
-					continue
-				}
+// initFlag is the flag for the initialised variable name, or nil if it has
+// none. The flag named name may be another variable's.
+func initFlag(cmd *cobra.Command, name string) *pflag.Flag {
+	f, ok := initFlags[name]
+	if !ok {
+		return nil
+	}
+	return cmd.Flags().Lookup(f)
+}
 
-				if !isStringType(valueSpec.Type) {
-					for _, name := range valueSpec.Names {
-						jsonValue, err := getVar(node, name.Name)
-						if err != nil {
-							fmt.Printf("Error marshaling %s: %v\n", name.Name, err)
-							continue
-						}
-						evalCmd.Flags().String(name.Name, jsonValue, strings.TrimSpace(commentAbove)+"\n\r\x1b[1;34m")
-						efCmd.Flags().String(name.Name, jsonValue, strings.TrimSpace(commentAbove)+"\n\r\x1b[1;34m")
-					}
-				}
-			}
+// annotate records what a //mcu:flag line said about a generated flag: its
+// group, which flagGroups lists it under, whether it is hidden, and whether it
+// is required, which the help menu says.
+func annotate(f *pflag.Flag, group string, hidden, required bool) {
+	if group != "" {
+		if f.Annotations == nil {
+			f.Annotations = map[string][]string{}
 		}
+		f.Annotations[groupAnnotation] = []string{group}
+	}
+	f.Hidden = hidden
+	if required {
+		f.Usage = "(required) " + f.Usage
 	}
 }
 
-func init() {
-	goProg = os.Getenv("GOPROG")
+// groupAnnotation is the pflag annotation a flag's //mcu:flag group is kept in.
+const groupAnnotation = "mcu:group"
 
-	if goProg != "" {
-		fset := token.NewFileSet()
+// flagGroup is one section of the flags in the help menu. The field is called
+// LocalFlags so that coloredcobra, which finds .LocalFlags.FlagUsages in the
+// template and styles it, styles each group as it would the whole list.
+type flagGroup struct {
+	Heading    string
+	LocalFlags *pflag.FlagSet
+}
 
-		file, err := parser.ParseFile(fset, goProg, nil, parser.ParseComments)
-		if err != nil {
-			fmt.Println("Error parsing Go file:", err)
-			os.Exit(1)
+// flagGroups splits a command's flags by group for the help menu: the
+// ungrouped ones first, under the usual heading, then each group in the order
+// its first flag was added. Hidden flags are left out, and so is a group with
+// nothing else in it.
+func flagGroups(fs *pflag.FlagSet) []flagGroup {
+	var groups []flagGroup
+	index := map[string]int{}
+	fs.VisitAll(func(f *pflag.Flag) {
+		if f.Hidden {
+			return
 		}
-
-		var commentAbove string
-
-		for _, decl := range file.Decls {
-			genDecl, ok := decl.(*ast.GenDecl)
-			if !ok || genDecl.Tok != token.VAR {
-				continue
+		var name string
+		if g := f.Annotations[groupAnnotation]; len(g) > 0 {
+			name = g[0]
+		}
+		i, ok := index[name]
+		if !ok {
+			heading := "Flags:"
+			if name != "" {
+				heading = strings.ToUpper(name[:1]) + name[1:] + " Flags:"
 			}
+			set := pflag.NewFlagSet(name, pflag.ContinueOnError)
+			set.SortFlags = false
+			i = len(groups)
+			index[name] = i
+			groups = append(groups, flagGroup{Heading: heading, LocalFlags: set})
+		}
+		groups[i].LocalFlags.AddFlag(f)
+	})
+	if i, ok := index[""]; ok && i > 0 {
+		groups = append(append([]flagGroup{groups[i]}, groups[:i]...), groups[i+1:]...)
+	}
+	return groups
+}
 
-			if genDecl.Doc != nil && len(genDecl.Doc.List) > 0 {
-				commentAbove = genDecl.Doc.List[0].Text
-			} else {
-				commentAbove = ""
-			}
+func init() {
+	cobra.AddTemplateFunc("flagGroups", flagGroups)
+	// coloredcobra defines HeadingStyle when main sets it up, replacing
+	// this; it is here so the template still works if it never does.
+	cobra.AddTemplateFunc("HeadingStyle", func(s string) string { return s })
+}
 
-			for _, spec := range genDecl.Specs {
-				valueSpec := spec.(*ast.ValueSpec)
-				for i, ident := range valueSpec.Names {
-					if ident.Obj == nil {
-						continue
-					}
-					varName := ident.Name
-					flagName := varName
-					flagDesc := ""
-					if isStringType(valueSpec.Type) {
-						flagDesc = fmt.Sprintf("main.%s", varName)
-					}
-					if commentAbove != "" {
-						flagDesc += " " + strings.TrimSpace(commentAbove) + "\n\r\x1b[1;34m"
-					}
-					if i < len(valueSpec.Names) && valueSpec.Comment != nil {
-						defaultValue := strings.TrimSpace(valueSpec.Comment.Text())
-						if strings.HasPrefix(defaultValue, "//") {
-							defaultValue = strings.TrimSpace(defaultValue[2:])
-						}
-						if !strings.Contains(flagDesc, "json") {
-							defaultValue, _ = script.Exec(`bash -c 'printf ` + defaultValue + `' `).String() //nolint:errcheck // a failed shell expansion leaves the default as written, which is the right fallback
-						}
-						if isStringType(valueSpec.Type) {
-							flashCmd.Flags().String(flagName, defaultValue, flagDesc)
-							efCmd.Flags().String(flagName, defaultValue, flagDesc)
-						}
-					}
-				}
-			}
-		}
-		_, err = script.Exec(`udisksctl help`).String()
-		u := ""
-		if err == nil {
-			flashCmd.Flags().StringVarP(&ttyUSB, "ser", "m", "", "block device for serial interface (i.e. \"/dev/ttyACM0\")\nif unspecified serial connection will not be attempted")
-			efCmd.Flags().StringVarP(&ttyUSB, "ser", "m", "", "block device for serial interface (i.e. \"/dev/ttyACM0\")\nif unspecified serial connection will not be attempted")
-			flashCmd.Flags().IntVarP(&baud, "baud", "b", 9600, "baud rate")
-			efCmd.Flags().IntVarP(&baud, "baud", "b", 9600, "baud rate")
-			flashCmd.Flags().DurationVarP(&sleepTime, "slp", "s", 3*time.Second, "seconds to wait before serial connection after flashing")
-			efCmd.Flags().DurationVarP(&sleepTime, "slp", "s", 3*time.Second, "seconds to wait before serial connection after flashing")
-			flashCmd.Flags().StringVarP(&blkDev, "dev", "y", "", "block device to flash (i.e. \"/dev/sdx\")\nif unspecified, tinygo flash command is generated")
-			efCmd.Flags().StringVarP(&blkDev, "dev", "y", "", "block device to flash (i.e. \"/dev/sdx\")\nif unspecified, tinygo flash command is generated")
-		} else {
-			u = "udisksctl not found ; mounting MCU block device not possible"
-		}
-		cmdLong := fmt.Sprintf("\nGOPROG=%s %s \n%s", goProg, func() string {
-			ret := ""
-			if strings.HasPrefix(os.Args[0], "/tmp/go-build") {
-				ret += " go run " + filepath.Base(os.Args[0]) + ".go "
-			} else {
-				ret += os.Args[0] + " "
-			}
-			for i := range os.Args {
-				if i > 0 {
-					ret += os.Args[i] + " "
-				}
-			}
-			return ret
-		}(), u)
-		flashCmd.Long += cmdLong
-		flashCmd.Flags().StringVarP(&target, "target", "z", "", "tinygo flash target")
-		efCmd.Long += cmdLong
-		efCmd.Flags().StringVarP(&target, "target", "z", "", "tinygo flash target")
-	} else {
+// loadProgram makes the flags GOPROG's variables and initializers become,
+// and the ones that only make sense with something to flash, then gives them
+// the selected profile's values.
+//
+// It is not an init: it compiles nothing, but it does run go list, parse the
+// source and look for udisksctl, and a mon, a schematic or mcu's own help has
+// no use for any of that. main calls it only for the commands that do, flash,
+// ef and eval, and for completing their flags; a GOPROG that does not load is
+// then that command's error, not every command's.
+func loadProgram() error {
+	if goProg == "" {
 		// The firmware is the obvious thing to flash, so it is the suggestion
 		// when GOPROG says nothing. The root of the repo is not scanned for a
 		// fallback any more: what lives there is this program, and offering to
 		// flash the flasher to a microcontroller is never what was meant.
-		gofile := "firmware/main.go"
+		gofile := defaultFirmware
 		if _, err := os.Stat(gofile); err != nil {
 			gofile = "/path/to/program.go"
 		}
 
-		cmdLong := fmt.Sprintf("\n\nGOPROG env not set\nGOPROG=%s %s \nGOPROG env should contain the the name of the .go program source file to compile and flash\nfor a menu of available flags set GOPROG env\n\nFor automatic flag generation with description and default values, use the format:\n\n//flag description\nvar SomeVar string //defaultvalue", gofile, func() string {
+		cmdLong := fmt.Sprintf("\n\nGOPROG env not set\nGOPROG=%s %s \nGOPROG env should contain the the name of the .go program source file, package directory or import path to compile and flash\nfor a menu of available flags set GOPROG env\n\nFor automatic flag generation with description and default values, use the format:\n\n//flag description\nvar SomeVar string //defaultvalue\n\nA //mcu:type line after the description (bool, int, duration, time, enum(a|b))\ngives the flag a type its values are checked against; without one it is inferred\nfrom the description and the default. A //mcu:flag line\n(name= type= default= help= group= choices= hidden required) says all of it in one place.", gofile, func() string {
 			ret := ""
 			if strings.HasPrefix(os.Args[0], "/tmp/go-build") {
 				ret += " go run " + filepath.Base(os.Args[0]) + ".go "
@@ -371,29 +372,163 @@ func init() {
 		}())
 		flashCmd.Long += cmdLong
 		efCmd.Long += cmdLong
+		return applyProfile(flashCmd, efCmd, evalCmd)
+	}
+	prog, err := goprog.Load(goProg)
+	if err != nil {
+		return err
+	}
+	progDir = prog.Dir
+	progDirs = prog.Dirs()
+
+	// mcu's own flags are defined first, so that a variable's flag, which
+	// goprog keeps clear of their names, is the one that steps aside if it
+	// still meets one.
+	_, err = script.Exec(`udisksctl help`).String()
+	u := ""
+	if err == nil {
+		flashCmd.Flags().StringVarP(&ttyUSB, "ser", "m", "", "block device for serial interface (i.e. \"/dev/ttyACM0\")\nif unspecified serial connection will not be attempted")
+		efCmd.Flags().StringVarP(&ttyUSB, "ser", "m", "", "block device for serial interface (i.e. \"/dev/ttyACM0\")\nif unspecified serial connection will not be attempted")
+		flashCmd.Flags().IntVarP(&baud, "baud", "b", 9600, "baud rate")
+		efCmd.Flags().IntVarP(&baud, "baud", "b", 9600, "baud rate")
+		flashCmd.Flags().DurationVarP(&sleepTime, "slp", "s", 3*time.Second, "seconds to wait before serial connection after flashing")
+		efCmd.Flags().DurationVarP(&sleepTime, "slp", "s", 3*time.Second, "seconds to wait before serial connection after flashing")
+		flashCmd.Flags().StringVarP(&blkDev, "dev", "y", "", "block device to flash (i.e. \"/dev/sdx\")\nif unspecified, tinygo flash command is generated")
+		efCmd.Flags().StringVarP(&blkDev, "dev", "y", "", "block device to flash (i.e. \"/dev/sdx\")\nif unspecified, tinygo flash command is generated")
+		addProductionFlags(flashCmd)
+		addWatchFlags(flashCmd, efCmd)
+	} else {
+		u = "udisksctl not found ; mounting MCU block device not possible"
 	}
+	cmdLong := fmt.Sprintf("\nGOPROG=%s %s \n%s", goProg, func() string {
+		ret := ""
+		if strings.HasPrefix(os.Args[0], "/tmp/go-build") {
+			ret += " go run " + filepath.Base(os.Args[0]) + ".go "
+		} else {
+			ret += os.Args[0] + " "
+		}
+		for i := range os.Args {
+			if i > 0 {
+				ret += os.Args[i] + " "
+			}
+		}
+		return ret
+	}(), u)
+	flashCmd.Long += cmdLong
+	flashCmd.Flags().StringVarP(&target, "target", "z", "", "tinygo flash target")
+	efCmd.Long += cmdLong
+	efCmd.Flags().StringVarP(&target, "target", "z", "", "tinygo flash target")
+	// eval and ef print the program back out as one file, so they only
+	// apply when GOPROG is one; a package is flashed with flash.
+	if goprog.MainFile(goProg) {
+		source, err = goprog.ParseSource(goProg, nil)
+		if err != nil {
+			return fmt.Errorf("parsing %s: %w", goProg, err)
+		}
+		for _, in := range goprog.Inits(source.File) {
+			if in.Err != nil {
+				warnFlag(source.Fset.Position(in.Pos), in.Name, in.Err)
+			}
+			jsonValue, err := getVar(source.File, in.Name)
+			if err != nil {
+				flagWarnings = append(flagWarnings, fmt.Sprintf("%s: %v", in.Name, err))
+				continue
+			}
+			if !flagFree(evalCmd, in.Flag, in.Name) || !flagFree(efCmd, in.Flag, in.Name) {
+				continue
+			}
+			srcValues[in.Flag] = jsonValue
+			initFlags[in.Name] = in.Flag
+			desc := in.Doc
+			if desc != "" {
+				desc = "// " + desc
+			}
+			for _, c := range []*cobra.Command{evalCmd, efCmd} {
+				c.Flags().String(in.Flag, jsonValue, desc+"\n\r\x1b[1;34m")
+				annotate(c.Flags().Lookup(in.Flag), in.Group, in.Hidden, in.Required)
+			}
+		}
+	}
+	for _, v := range prog.Vars {
+		if v.Err != nil {
+			warnFlag(prog.Fset.Position(v.Pos), v.Symbol(), v.Err)
+		}
+		flagDesc := v.Symbol()
+		if v.Doc != "" {
+			flagDesc += " // " + v.Doc + "\n\r\x1b[1;34m"
+		}
+		// Nothing is expanded here: this runs for --help and for completions
+		// too, and only flashing needs a timestamp or a git describe. The
+		// template is kept as the default and expanded by checkXFlags.
+		defaultValue := v.Default
+		literal := strings.Contains(flagDesc, "json")
+		if !literal && strings.Contains(defaultValue, "$") {
+			if t, err := goprog.Translate(defaultValue); err != nil {
+				flagWarnings = append(flagWarnings, fmt.Sprintf("%s: default %s is shell syntax mcu no longer runs (%v); it is used as written", v.Name, defaultValue, err))
+			} else {
+				flagWarnings = append(flagWarnings, fmt.Sprintf("%s: default %s is shell syntax; read as %s, which the comment should say instead", v.Name, defaultValue, t))
+				defaultValue = t
+			}
+		}
+		if !flagFree(flashCmd, v.Flag, v.Symbol()) || !flagFree(efCmd, v.Flag, v.Symbol()) {
+			continue
+		}
+		xvars = append(xvars, v)
+		for _, c := range []*cobra.Command{flashCmd, efCmd} {
+			f := c.Flags().VarPF(&xValue{v: v, tmpl: defaultValue, s: defaultValue, literal: literal}, v.Flag, "", flagDesc)
+			if v.Kind == goprog.Bool {
+				f.NoOptDefVal = "true"
+			}
+			annotate(f, v.Group, v.Hidden, v.Required)
+			if v.Kind == goprog.Enum {
+				_ = c.Flags().SetAnnotation(v.Flag, choicesAnnotation, v.Choices) //nolint:errcheck // the flag was just defined
+			}
+		}
+	}
+	return applyProfile(flashCmd, efCmd, evalCmd)
+}
+
+func init() {
 	_, err := script.Exec(`tinygo help`).String()
 	if err == nil {
 		RootCmd.AddCommand(flashCmd, efCmd)
 	} else {
-		out("tinygo not found ; flash subcommand not available\n")
+		notice("tinygo not found ; flash subcommand not available\n")
 	}
+	addProfileFlag(flashCmd, efCmd, evalCmd)
 	flashCmd.Flags().SortFlags = false
 	efCmd.Flags().SortFlags = false
 	evalCmd.Flags().SortFlags = false
 }
 
-func isStringType(expr ast.Expr) bool {
-	switch t := expr.(type) {
-	case *ast.Ident:
-		return t.Name == "string"
-	case *ast.SelectorExpr:
-		return false
-	case *ast.ArrayType:
-		return false
-	default:
-		return false
+// prepare does what the command args run, or complete, needs done before
+// cobra parses its flags. flash, ef and eval need the project file and the
+// profile, which choose GOPROG, and then loadProgram; nothing else needs
+// either, so a malformed mcu.yaml or a GOPROG that does not load is an error
+// for those commands and not for mon, schematic, completion or --help.
+func prepare(args []string) error {
+	if !needsProgram(args) {
+		return nil
+	}
+	var err error
+	if goProg, err = selectProfile(args, goProg); err != nil {
+		return err
+	}
+	if err := loadProgram(); err != nil {
+		return fmt.Errorf("loading GOPROG: %w", err)
 	}
+	return nil
+}
+
+// needsProgram reports whether args run, or complete, a command loadProgram
+// makes the flags of. The command is found the way cobra will find it; for a
+// completion, the shell's request is followed to the command being completed.
+func needsProgram(args []string) bool {
+	if len(args) > 0 && (args[0] == cobra.ShellCompRequestCmd || args[0] == cobra.ShellCompNoDescRequestCmd) {
+		args = args[1:]
+	}
+	c, _, err := RootCmd.Find(args)
+	return err == nil && (c == flashCmd || c == efCmd || c == evalCmd)
 }
 
 func getVar(node *ast.File, varName string) (string, error) {
@@ -425,6 +560,145 @@ func getVar(node *ast.File, varName string) (string, error) {
 	return "", fmt.Errorf("variable %s not found", varName)
 }
 
+// xvars are the variables set with -X, the main package's first and each
+// package's in declaration order.
+var xvars []goprog.Var
+
+// progDir is the main package's directory and progDirs every loaded
+// package's, which is where defaults are expanded and what --watch watches.
+var (
+	progDir  string
+	progDirs []string
+)
+
+// xFlags is the -X assignments for every generated string flag with a value,
+// as they go inside -ldflags.
+func xFlags(cmd *cobra.Command) string {
+	var ldFlags string
+	for _, v := range xvars {
+		if f := cmd.Flags().Lookup(v.Flag); f != nil && f.Value.String() != "" {
+			ldFlags += fmt.Sprintf(` -X '%s=%s'`, v.Symbol(), f.Value.String())
+		}
+	}
+	return ldFlags
+}
+
+// flagWarnings are warnings about how GOPROG annotates its variables: defaults
+// written for the old bash expansion, //mcu:flag lines that could not be
+// used, and flags whose names were taken. They are printed by the commands
+// that use the flags, once.
+var flagWarnings []string
+
+// warnFlag adds a warning about the variable name for each line of err, which
+// may be several errors joined, at pos: the //mcu:flag line it is about, so
+// that an editor can go straight to it.
+func warnFlag(pos token.Position, name string, err error) {
+	for _, line := range strings.Split(err.Error(), "\n") {
+		flagWarnings = append(flagWarnings, fmt.Sprintf("%s: %s: %s", pos, name, line))
+	}
+}
+
+// flagFree reports whether c can be given the flag for the variable name,
+// which it cannot if another variable's //mcu:flag line took the name first:
+// pflag panics at a second flag of the same name. A variable left without a
+// flag keeps what the source says, and an initializer can still be changed
+// with --config or --set.
+func flagFree(c *cobra.Command, flag, name string) bool {
+	if c.Flags().Lookup(flag) == nil {
+		return true
+	}
+	flagWarnings = append(flagWarnings, fmt.Sprintf("%s: --%s is already %s's, so %s has no flag there", name, flag, c.Name(), name))
+	return false
+}
+
+func printFlagWarnings(w io.Writer) {
+	for _, s := range flagWarnings {
+		fmt.Fprint(w, "\x1b[33mwarning: "+s+"\x1b[0m\n") //nolint:errcheck,gosec // see out
+	}
+	flagWarnings = nil
+}
+
+// checkXFlags expands every -X value's template and validates the result
+// against its variable's kind. Expansion happens here, and again on each call,
+// so a {{now}} default is the time of this build rather than the time mcu
+// started — which matters when --watch rebuilds for an hour.
+func checkXFlags(cmd *cobra.Command) error {
+	printFlagWarnings(os.Stderr)
+
+	env := goprog.Env{Dir: progDir}
+	var bad []string
+	for _, v := range xvars {
+		f := cmd.Flags().Lookup(v.Flag)
+		if f == nil {
+			continue
+		}
+		x, ok := f.Value.(*xValue)
+		if !ok {
+			continue
+		}
+		if err := x.expand(env); err != nil {
+			bad = append(bad, fmt.Sprintf("--%s: %v", v.Flag, err))
+		} else if v.Required && x.s == "" {
+			bad = append(bad, fmt.Sprintf("--%s is required", v.Flag))
+		}
+	}
+	if len(bad) > 0 {
+		return fmt.Errorf("%s", strings.Join(bad, "\n"))
+	}
+	return nil
+}
+
+// xValue is the flag for an -X variable. It holds a string, because that is
+// all -X can set, but refuses one the device would not parse as the
+// variable's kind, and reports the kind as its type so the help menu says
+// --offSet int rather than --offSet string.
+//
+// The value as given is a template (see goprog.Env); s is what it last
+// expanded to. A value with a template in it can only be validated once it
+// is expanded, so Set lets it through and expand checks it.
+type xValue struct {
+	v       goprog.Var
+	tmpl    string
+	s       string
+	literal bool // described as json, so braces are data, not a template
+}
+
+func (x *xValue) String() string { return x.s }
+
+func (x *xValue) Set(s string) error {
+	if x.literal || !strings.Contains(s, "{{") {
+		if err := x.v.Kind.Validate(s, x.v.Choices); err != nil {
+			return err
+		}
+	}
+	x.tmpl, x.s = s, s
+	return nil
+}
+
+func (x *xValue) expand(env goprog.Env) error {
+	s := x.tmpl
+	if !x.literal {
+		var err error
+		if s, err = env.Expand(s); err != nil {
+			return err
+		}
+	}
+	if s != "" {
+		if err := x.v.Kind.Validate(s, x.v.Choices); err != nil {
+			return err
+		}
+	}
+	x.s = s
+	return nil
+}
+
+func (x *xValue) Type() string {
+	if x.v.Kind == goprog.Enum {
+		return strings.Join(x.v.Choices, "|")
+	}
+	return x.v.Kind.String()
+}
+
 var flashCmd = &cobra.Command{
 	Use:   "flash",
 	Short: "mcu cli - tinygo flash command generator",
@@ -441,19 +715,41 @@ via flags autogenerated for this help menu.
 			fmt.Println("GOPROG not specified")
 			os.Exit(0)
 		}
+		if err := checkXFlags(cmd); err != nil {
+			out(err.Error() + "\n")
+			os.Exit(1)
+		}
+		// Each loops until interrupted, and neither knows how to be the
+		// other's cycle, so running one would quietly drop the other.
+		if watch && production {
+			out("--watch and --production cannot be combined\n")
+			os.Exit(1)
+		}
+		if watch {
+			// Checked on each cycle, since the file is being edited.
+			if err := runWatch(cmd, false); err != nil {
+				out(err.Error() + "\n")
+				os.Exit(1)
+			}
+			return
+		}
+		if err := checkPins(nil, false); err != nil {
+			out(err.Error() + "\n")
+			os.Exit(1)
+		}
+		if production {
+			if err := runProduction(cmd); err != nil {
+				out(err.Error() + "\n")
+				os.Exit(1)
+			}
+			return
+		}
 
 		cmdToRun := "tinygo flash "
 		if target != "" {
 			cmdToRun += ` -target=` + target + ` `
 		}
-		var ldFlags string
-		cmd.Flags().VisitAll(func(f *pflag.Flag) {
-			if f.Value.Type() == "string" {
-				if f.Value.String() != "" && strings.HasPrefix(f.Usage, "main.") && f.Name != "help" && f.Name != "ser" && f.Name != "dev" && f.Name != "target" {
-					ldFlags += fmt.Sprintf(` -X 'main.%s=%s'`, f.Name, f.Value.String())
-				}
-			}
-		})
+		ldFlags := xFlags(cmd)
 		if ldFlags != "" {
 			cmdToRun += ` -ldflags="` + ldFlags + `" `
 		}
@@ -560,6 +856,268 @@ via flags autogenerated for this help menu.
 	},
 }
 
+// sets are the --set edits for eval and ef, in the order given.
+var sets []string
+
+// applySets makes every --set edit in src. A variable can be replaced with its
+// own flag or edited with --set, but not both at once: one would undo the
+// other.
+func applySets(cmd *cobra.Command, src *goprog.Source) error {
+	for _, s := range sets {
+		e, err := goprog.ParseEdit(s)
+		if err != nil {
+			return err
+		}
+		if f := initFlag(cmd, e.Var); f != nil && changedFromSource(f) {
+			return fmt.Errorf("--set %s: --%s replaces all of %s; use one or the other", s, f.Name, e.Var)
+		}
+		if err := e.Apply(src); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// configFile is eval and ef's --config.
+var configFile string
+
+// applyConfig makes the changes --config asks for, and returns the variables
+// whose initializers it replaced. A variable's initializer is
+// replaced with the literal the document describes; a key naming an -X
+// variable sets its flag, unless the command line already did. eval has no -X
+// flags, so there such a key is only mentioned. As with --set, a variable
+// cannot be given both by the file and by its own flag.
+func applyConfig(cmd *cobra.Command, src *goprog.Source) ([]string, error) {
+	if configFile == "" {
+		return nil, nil
+	}
+	b, err := os.ReadFile(configFile) //nolint:gosec // the file --config names
+	if err != nil {
+		return nil, err
+	}
+	var doc map[string]any
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %w", configFile, err)
+	}
+	vars, rest, err := src.Configure(configFile, doc)
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range vars {
+		if f := initFlag(cmd, k); f != nil && changedFromSource(f) {
+			return nil, fmt.Errorf("%s: --%s replaces all of %s; use one or the other", configFile, f.Name, k)
+		}
+	}
+	var ignored []string
+	for _, k := range rest {
+		f := cmd.Flags().Lookup(xFlag(k))
+		if x, ok := flagValue(f); ok {
+			if f.Changed {
+				continue
+			}
+			if err := x.Set(fmt.Sprint(doc[k])); err != nil {
+				return nil, fmt.Errorf("%s: %s: %w", configFile, k, err)
+			}
+			if err := x.expand(goprog.Env{Dir: progDir}); err != nil {
+				return nil, fmt.Errorf("%s: %s: %w", configFile, k, err)
+			}
+			continue
+		}
+		if xFlag(k) != k || isXVar(k) {
+			ignored = append(ignored, k)
+			continue
+		}
+		return nil, fmt.Errorf("%s: GOPROG has no variable %s to configure", configFile, k)
+	}
+	if len(ignored) > 0 {
+		fmt.Fprintf(os.Stderr, "%s: %s ignores %s, which are set with -X when flashing\n", configFile, cmd.Name(), strings.Join(ignored, ", "))
+	}
+	return vars, nil
+}
+
+func flagValue(f *pflag.Flag) (*xValue, bool) {
+	if f == nil {
+		return nil, false
+	}
+	x, ok := f.Value.(*xValue)
+	return x, ok
+}
+
+// xFlag is the flag for the main package's -X variable name, which a
+// //mcu:flag line may have named differently, or name itself.
+func xFlag(name string) string {
+	for _, v := range xvars {
+		if v.Pkg == "main" && v.Name == name {
+			return v.Flag
+		}
+	}
+	return name
+}
+
+func isXVar(flag string) bool {
+	for _, v := range xvars {
+		if v.Flag == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// changedFromSource is rewrite's keep for eval and ef: a flag still holding
+// what the source said is left alone, which keeps the source's own
+// formatting and any --set edits inside it.
+func changedFromSource(f *pflag.Flag) bool {
+	return f.Value.String() != srcValues[f.Name]
+}
+
+// rewrite replaces the initializer of every generated non-string variable in
+// the source with its flag's value. keep, if not nil, decides flag by flag
+// whether to; changedFromSource leaves alone anything the command line did not
+// change, so neither a --set edit nor, in watch mode, an edit to the source is
+// overwritten by the value the source had when mcu started.
+func rewrite(cmd *cobra.Command, src *goprog.Source, keep func(*pflag.Flag) bool) error {
+	for _, in := range goprog.Inits(src.File) {
+		f := initFlag(cmd, in.Name)
+		// Only a flag generated for a variable; one the variable's
+		// name happens to share, such as --target, is not its value.
+		if f == nil {
+			continue
+		}
+		if _, generated := srcValues[f.Name]; !generated {
+			continue
+		}
+		if keep == nil || keep(f) {
+			if err := src.Replace(in.Value, f.Value.String(), "--"+f.Name); err != nil {
+				return fmt.Errorf("--%s: %w", f.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// rewritten applies --config, --set and the generated flags to src,
+// type-checks the result, and returns it gofmt'd.
+func rewritten(cmd *cobra.Command, src *goprog.Source) ([]byte, error) {
+	configured, err := applyConfig(cmd, src)
+	if err != nil {
+		return nil, err
+	}
+	for _, in := range goprog.Inits(src.File) {
+		f := initFlag(cmd, in.Name)
+		if in.Required && f != nil && !changedFromSource(f) && !slices.Contains(configured, in.Name) {
+			return nil, fmt.Errorf("--%s is required: %s's initializer is a placeholder (or give it with --config)", f.Name, in.Name)
+		}
+	}
+	if err := applySets(cmd, src); err != nil {
+		return nil, err
+	}
+	if err := rewrite(cmd, src, changedFromSource); err != nil {
+		return nil, err
+	}
+	problems, err := src.Check(filepath.Dir(src.Name))
+	if err != nil {
+		return nil, err
+	}
+	if len(problems) > 0 {
+		msgs := make([]string, len(problems))
+		for i, p := range problems {
+			msgs[i] = p.String()
+		}
+		return nil, fmt.Errorf("%s", strings.Join(msgs, "\n"))
+	}
+	return src.Bytes()
+}
+
+// evalDiff, evalWrite, evalExport, evalCheck and efKeep are eval's --diff,
+// --write, --export and --check and ef's --keep.
+var (
+	evalDiff   bool
+	evalWrite  bool
+	evalExport string
+	evalCheck  bool
+	efKeep     bool
+)
+
+// checkPins looks for pins claimed twice, and for pins the peripherals they
+// are given to cannot use, in the source about to be built: b, or GOPROG as
+// it is on disk if b is nil. A mistake here builds and flashes without
+// complaint, and shows up as a blank display or a clock that never answers.
+//
+// strict is eval --check, where a file the check cannot work out is an error.
+// Before a build it only means the file is not checked; GOPROG as a package,
+// or an initializer that calls something, is not a reason to refuse to flash.
+func checkPins(b []byte, strict bool) error {
+	if !goprog.MainFile(goProg) {
+		if strict {
+			return fmt.Errorf("GOPROG=%s is a package; --check reads a single file", goProg)
+		}
+		return nil
+	}
+	src, err := goprog.ParseSource(goProg, b)
+	if err != nil {
+		if strict {
+			return err
+		}
+		return nil
+	}
+	problems, err := goprog.CheckPins(src.Fset, src.File)
+	if err != nil {
+		if strict {
+			return fmt.Errorf("%s: cannot check the pins: %w", goProg, err)
+		}
+		return nil
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%s: pin conflicts:\n\t%s", goProg, strings.Join(problems, "\n\t"))
+	}
+	return nil
+}
+
+// export prints the configuration of the rewritten source, so that
+// eval --config old.yaml --set ... --export shows what the edits add up to.
+func export(b []byte) error {
+	src, err := goprog.ParseSource(goProg, b)
+	if err != nil {
+		return err
+	}
+	doc, err := goprog.Export(src.File)
+	if err != nil {
+		return fmt.Errorf("%s: %w", goProg, err)
+	}
+	switch evalExport {
+	case "json":
+		b, err = goprog.JSON(doc)
+	case "yaml":
+		var buf strings.Builder
+		enc := yaml.NewEncoder(&buf)
+		enc.SetIndent(2)
+		if err = enc.Encode(doc); err == nil {
+			err = enc.Close()
+		}
+		b = []byte(buf.String())
+	default:
+		return fmt.Errorf("--export %s: want yaml or json", evalExport)
+	}
+	if err != nil {
+		return err
+	}
+	out(string(b))
+	return nil
+}
+
+// writeBack replaces GOPROG with the rewritten source, keeping what was there
+// as GOPROG.bak.
+func writeBack(src *goprog.Source, b []byte) error {
+	fi, err := os.Stat(src.Name)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(src.Name+".bak", src.Src, fi.Mode().Perm()); err != nil {
+		return err
+	}
+	return os.WriteFile(src.Name, b, fi.Mode().Perm())
+}
+
 var evalCmd = &cobra.Command{
 	Use:   "eval",
 	Short: "generate updated source code",
@@ -569,35 +1127,58 @@ var evalCmd = &cobra.Command{
 	SilenceUsage:          true,
 	DisableSuggestions:    true,
 	DisableFlagsInUseLine: true,
+	Args:                  noArgs("--export=json"),
 	Run: func(cmd *cobra.Command, args []string) {
 		if goProg == "" {
 			fmt.Println("GOPROG not specified")
 			os.Exit(1)
 		}
-		ast.Inspect(node, func(n ast.Node) bool {
-			if vs, ok := n.(*ast.ValueSpec); ok {
-				for _, name := range vs.Names {
-					fn := name.Name
-					if fn == "help" || fn == "ser" || fn == "baud" || fn == "slp" || fn == "target" || fn == "dev" {
-						continue
-					}
-					flagValue, err := cmd.Flags().GetString(name.Name)
-					if err == nil {
-						vs.Values = []ast.Expr{&ast.BasicLit{
-							Kind:  token.STRING,
-							Value: flagValue,
-						}}
-					}
-				}
+		if !goprog.MainFile(goProg) {
+			out("GOPROG=" + goProg + " is a package; eval rewrites a single file\n")
+			os.Exit(1)
+		}
+		printFlagWarnings(os.Stderr)
+		if evalExport != "" && (evalDiff || evalWrite) {
+			out("--export prints the configuration instead of the source; it does not go with --diff or --write\n")
+			os.Exit(1)
+		}
+		if evalCheck && (evalExport != "" || evalDiff || evalWrite) {
+			out("--check prints what it finds instead of the source; it does not go with --export, --diff or --write\n")
+			os.Exit(1)
+		}
+		b, err := rewritten(cmd, source)
+		if err != nil {
+			out(err.Error() + "\n")
+			os.Exit(1)
+		}
+		if evalCheck {
+			if err := checkPins(b, true); err != nil {
+				out(err.Error() + "\n")
+				os.Exit(1)
 			}
-			return true
-		})
-
-		var buf bytes.Buffer
-		if err := printer.Fprint(&buf, fset, node); err != nil {
-			log.Fatalf("Failed to print source: %v", err)
+			out(goProg + ": no pin conflicts\n")
+			return
+		}
+		if evalExport != "" {
+			if err := export(b); err != nil {
+				out(err.Error() + "\n")
+				os.Exit(1)
+			}
+			return
+		}
+		if evalDiff {
+			out(goprog.Diff(goProg, source.Src, b))
+		}
+		if evalWrite {
+			if err := writeBack(source, b); err != nil {
+				out(err.Error() + "\n")
+				os.Exit(1)
+			}
+			out(fmt.Sprintf("wrote %s (was %s.bak)\n", goProg, goProg))
+		}
+		if !evalDiff && !evalWrite {
+			out(string(b))
 		}
-		fmt.Println(buf.String())
 	},
 }
 
@@ -615,37 +1196,39 @@ var efCmd = &cobra.Command{
 			fmt.Println("GOPROG not specified")
 			os.Exit(1)
 		}
-		ast.Inspect(node, func(n ast.Node) bool {
-			if vs, ok := n.(*ast.ValueSpec); ok {
-				for _, name := range vs.Names {
-					fn := name.Name
-					if fn == "help" || fn == "ser" || fn == "baud" || fn == "slp" || fn == "target" || fn == "dev" {
-						continue
-					}
-					fv, err := cmd.Flags().GetString(fn)
-					if err == nil && !strings.HasPrefix(cmd.Flags().Lookup(fn).Usage, "main.") {
-						vs.Values = []ast.Expr{&ast.BasicLit{
-							Kind:  token.STRING,
-							Value: fv,
-						}}
-					}
-				}
+		if !goprog.MainFile(goProg) {
+			out("GOPROG=" + goProg + " is a package; ef rewrites a single file, so use flash\n")
+			os.Exit(1)
+		}
+		// The -X flags are checked after --config has had its say; in
+		// watch mode, each cycle checks them.
+		if watch {
+			if err := runWatch(cmd, true); err != nil {
+				out(err.Error() + "\n")
+				os.Exit(1)
 			}
-			return true
-		})
-
-		var buf bytes.Buffer
-		if err := printer.Fprint(&buf, fset, node); err != nil {
-			log.Fatalf("Failed to print source: %v", err)
+			return
+		}
+		b, err := rewritten(cmd, source)
+		if err != nil {
+			out(err.Error() + "\n")
+			os.Exit(1)
+		}
+		if err := checkPins(b, false); err != nil {
+			out(err.Error() + "\n")
+			os.Exit(1)
+		}
+		if err := checkXFlags(cmd); err != nil {
+			out(err.Error() + "\n")
+			os.Exit(1)
 		}
-		//		fmt.Println(buf.String())
 
 		tempFile, err := os.CreateTemp(os.TempDir(), "*.go")
 		if err != nil {
 			fmt.Println("Error creating temporary file:", err)
 			return
 		}
-		_, err = tempFile.Write(buf.Bytes())
+		_, err = tempFile.Write(b)
 		if err != nil {
 			fmt.Println("Error writing to temporary file:", err)
 			return
@@ -655,14 +1238,7 @@ var efCmd = &cobra.Command{
 		if target != "" {
 			cmdToRun += ` -target=` + target + ` `
 		}
-		var ldFlags string
-		cmd.Flags().VisitAll(func(f *pflag.Flag) {
-			if f.Value.Type() == "string" {
-				if f.Value.String() != "" && strings.HasPrefix(f.Usage, "main.") && f.Name != "help" && f.Name != "ser" && f.Name != "dev" && f.Name != "target" {
-					ldFlags += fmt.Sprintf(` -X 'main.%s=%s'`, f.Name, f.Value.String())
-				}
-			}
-		})
+		ldFlags := xFlags(cmd)
 		if ldFlags != "" {
 			cmdToRun += ` -ldflags="` + ldFlags + `" `
 		}
@@ -689,7 +1265,11 @@ var efCmd = &cobra.Command{
 		} else {
 			out(cmdToRun + "\n")
 		}
-		os.Remove(tempFile.Name()) //nolint:errcheck,gosec // best-effort cleanup of a temp file
+		if efKeep {
+			out("generated source kept at " + tempFile.Name() + "\n")
+		} else {
+			os.Remove(tempFile.Name()) //nolint:errcheck,gosec // best-effort cleanup of a temp file
+		}
 		if ttyUSB != "" {
 			var ttyusb string
 			time.Sleep(sleepTime)
@@ -774,8 +1354,8 @@ const help = "Usage:\r\n" +
 	"  {{.UseLine}}{{if .HasAvailableSubCommands}}{{end}} {{if gt (len .Aliases) 0}}\r\n\r\n" +
 	"{{.NameAndAliases}}{{end}}{{if .HasAvailableSubCommands}}\r\n\r\n" +
 	"Available Commands:{{range .Commands}}{{if (or .IsAvailableCommand)}}\r\n  " +
-	"{{rpad .Name .NamePadding }} {{.Short}}{{end}}{{end}}{{end}}{{if .HasAvailableLocalFlags}}\r\n\r\n" +
-	"Flags:\r\n" +
-	"{{.LocalFlags.FlagUsages | trimTrailingWhitespaces}}{{end}}{{if .HasAvailableInheritedFlags}}\r\n\r\n" +
+	"{{rpad .Name .NamePadding }} {{.Short}}{{end}}{{end}}{{end}}{{if .HasAvailableLocalFlags}}{{range flagGroups .LocalFlags}}\r\n\r\n" +
+	"{{HeadingStyle .Heading}}\r\n" +
+	"{{.LocalFlags.FlagUsages | trimTrailingWhitespaces}}{{end}}{{end}}{{if .HasAvailableInheritedFlags}}\r\n\r\n" +
 	"Global Flags:\r\n" +
 	"{{.InheritedFlags.FlagUsages | trimTrailingWhitespaces}}{{end}}\r\n\r\n\033[0m"
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// A GOPROG that does not load is the error of the commands that build it, and
+// nothing to a schematic or to mcu's own help.
+func TestABadProgramOnlyStopsTheCommandsThatLoadIt(t *testing.T) {
+	savedProg, savedProject := goProg, project
+	t.Cleanup(func() { goProg, project = savedProg, savedProject })
+	noProfiles(t)
+
+	goProg = filepath.Join(t.TempDir(), "missing.go")
+	for _, args := range []string{"schematic --plain", "completion bash", "mon --help", "--help"} {
+		if err := prepare(strings.Fields(args)); err != nil {
+			t.Errorf("%s: %v", args, err)
+		}
+	}
+	for _, args := range []string{"eval", "eval --export", "__complete eval --"} {
+		if err := prepare(strings.Fields(args)); err == nil || !strings.Contains(err.Error(), "loading GOPROG") {
+			t.Errorf("%s: error %v, want a GOPROG load failure", args, err)
+		}
+	}
+}
+
+// A firmware variable named after one of mcu's flags gets the qualified flag
+// -X would name it by, and mcu's own flag is left as it was.
+func TestAVariableNamedLikeAnMCUFlagDoesNotShadowIt(t *testing.T) {
+	savedProg, savedProject, savedSource, savedVars := goProg, project, source, xvars
+	t.Cleanup(func() { goProg, project, source, xvars = savedProg, savedProject, savedSource, savedVars })
+	noProfiles(t)
+
+	goProg = filepath.Join(t.TempDir(), "main.go")
+	src := "package main\n\n// where it reads its settings\nvar config string //x\n\nvar set = [2]int{1, 2}\n\nfunc main() {}\n"
+	if err := os.WriteFile(goProg, []byte(src), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	config, set := efCmd.Flags().Lookup("config"), efCmd.Flags().Lookup("set")
+	if err := prepare([]string{"eval"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if efCmd.Flags().Lookup("config") != config || efCmd.Flags().Lookup("set") != set {
+		t.Error("ef's --config or --set was replaced")
+	}
+	if f := flashCmd.Flags().Lookup("config"); f != nil {
+		t.Errorf("flash has --config: %s", f.Usage)
+	}
+	for _, c := range []struct {
+		cmd  string
+		flag string
+		ok   bool
+	}{
+		{"flash", "main.config", flashCmd.Flags().Lookup("main.config") != nil},
+		{"ef", "main.config", efCmd.Flags().Lookup("main.config") != nil},
+		{"ef", "main.set", efCmd.Flags().Lookup("main.set") != nil},
+		{"eval", "main.set", evalCmd.Flags().Lookup("main.set") != nil},
+	} {
+		if !c.ok {
+			t.Errorf("%s has no --%s", c.cmd, c.flag)
+		}
+	}
+	if got := xFlag("config"); got != "main.config" {
+		t.Errorf("config's flag is --%s, want --main.config", got)
+	}
+}
+
+// noProfiles points mcu at a project file with nothing in it, so that the
+// repo's own mcu.yaml does not choose the program under test.
+func noProfiles(t *testing.T) {
+	t.Helper()
+	config := filepath.Join(t.TempDir(), configName)
+	if err := os.WriteFile(config, []byte("profiles: {}\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("MCUCONFIG", config)
+}
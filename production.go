@@ -0,0 +1,396 @@
+// Production line mode: flash a stream of boards as they are plugged in.
+//
+// Assembling kits means flashing dozens of Picos in a row, and the ordinary
+// flash command does one and then attaches a monitor. `mcu flash --production`
+// builds the firmware once and then loops: wait for a board in BOOTSEL mode to
+// appear as an RPI-RP2 volume, copy the image onto it, wait for the tty it
+// comes back as, optionally check that it says what a working board says, and
+// log the result. It runs until interrupted.
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/bitfield/script"
+	"github.com/spf13/cobra"
+	"github.com/tarm/serial"
+)
+
+// bootLabel is the volume label of an RP2040 in BOOTSEL mode.
+const bootLabel = "RPI-RP2"
+
+var (
+	production  bool
+	prodExpect  string
+	prodCSV     string
+	prodTimeout time.Duration
+)
+
+// addProductionFlags is called from the flash command's setup rather than from
+// an init here, so the flags exist before a profile is applied to them.
+func addProductionFlags(c *cobra.Command) {
+	c.Flags().BoolVar(&production, "production", false, "build once, then flash every "+bootLabel+" volume that appears until interrupted")
+	c.Flags().StringVar(&prodExpect, "expect", "", "with --production, serial output a working board must print (e.g. \"configured lcd\")")
+	c.Flags().StringVar(&prodCSV, "csv", "production.csv", "with --production, file each board's result is appended to")
+	c.Flags().DurationVar(&prodTimeout, "timeout", 30*time.Second, "with --production, how long each step may take before the board fails")
+}
+
+// boardResult is one row of the production log.
+type boardResult struct {
+	When     time.Time
+	Serial   string // USB serial number, from the boot volume
+	TTY      string
+	Pass     bool
+	Duration time.Duration
+	Detail   string // why it failed, or what it said
+}
+
+func runProduction(cmd *cobra.Command) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	dir, err := os.MkdirTemp("", "mcu-production-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir) //nolint:errcheck // best-effort cleanup of a temp dir
+
+	// A UF2 is what the boot volume takes, and building it once is the point:
+	// every board on the line gets the identical image.
+	uf2 := filepath.Join(dir, "firmware.uf2")
+	t := target
+	if t == "" {
+		t = "pico"
+	}
+	build := "tinygo build -target=" + t
+	if x := xFlags(cmd); x != "" {
+		build += ` -ldflags="` + x + `" `
+	}
+	build += " -o " + uf2 + " " + goProg
+	out(build + "\n")
+	if _, err := script.Exec(`bash -c '` + build + `'`).Stdout(); err != nil {
+		return fmt.Errorf("build failed: %w", err)
+	}
+
+	if _, err := script.Exec(`bash -c  'set -x ; sudo echo "sudo cache" ;  set +x'`).Stdout(); err != nil {
+		return err
+	}
+
+	results, err := openResultLog(prodCSV)
+	if err != nil {
+		return err
+	}
+	defer results.Close() //nolint:errcheck,gosec // every row is flushed as it is written
+
+	var pass, fail int
+	for n := 1; ; n++ {
+		out(fmt.Sprintf("board %d: waiting for %s ...\n", n, bootLabel))
+		dev, err := waitFor(ctx, bootVolume)
+		if err != nil {
+			break
+		}
+		r := flashBoard(ctx, dev, uf2)
+		if ctx.Err() != nil {
+			break
+		}
+		if r.Pass {
+			pass++
+		} else {
+			fail++
+		}
+		if err := results.write(r); err != nil {
+			return err
+		}
+		status := "\x1b[32mPASS\x1b[0m"
+		if !r.Pass {
+			status = "\x1b[31mFAIL\x1b[0m"
+		}
+		out(fmt.Sprintf("board %d: %s %-16s %-14s %5.1fs  %s   [pass %d  fail %d]\n",
+			n, status, r.Serial, r.TTY, r.Duration.Seconds(), r.Detail, pass, fail))
+
+		// A board that failed while still in BOOTSEL would otherwise be
+		// picked straight back up as the next one, and fail again forever.
+		if bootVolume() == dev {
+			out("remove the board to continue\n")
+			if _, err := waitFor(ctx, func() string {
+				if bootVolume() != dev {
+					return "gone"
+				}
+				return ""
+			}); err != nil {
+				break
+			}
+		}
+	}
+	out(fmt.Sprintf("\n%d boards: %d passed, %d failed; log in %s\n", pass+fail, pass, fail, prodCSV))
+	return nil
+}
+
+// flashBoard takes one board from BOOTSEL to a verdict. Every step is bounded
+// by --timeout: a board that never comes back is a failed board, not a hung
+// line.
+func flashBoard(ctx context.Context, dev, uf2 string) boardResult {
+	r := boardResult{When: time.Now(), Serial: usbSerial(dev)}
+	fail := func(format string, a ...any) boardResult {
+		r.Detail = fmt.Sprintf(format, a...)
+		r.Duration = time.Since(r.When)
+		return r
+	}
+
+	before := ttys()
+	step, cancel := context.WithTimeout(ctx, prodTimeout)
+	defer cancel()
+
+	// udisksctl can hang on a board whose flash is bad, and a desktop may be
+	// automounting the same volume at the same moment, so the mount runs on
+	// its own and it is the mount point that is waited for.
+	mp := mountPoint(dev)
+	if mp == "" {
+		mounted := make(chan error, 1)
+		go func() {
+			_, err := script.Exec(`udisksctl mount -b ` + dev).String()
+			mounted <- err
+		}()
+		var err error
+		if mp, err = waitFor(step, func() string { return mountPoint(dev) }); err != nil {
+			select {
+			case err := <-mounted:
+				if err != nil {
+					return fail("mount %s: %v", dev, err)
+				}
+			default:
+			}
+			return fail("%s did not mount", dev)
+		}
+	}
+	if err := copyFile(uf2, filepath.Join(mp, filepath.Base(uf2))); err != nil {
+		return fail("copy to %s: %v", mp, err)
+	}
+
+	// The board reboots into the new firmware as soon as the image is
+	// written, and the volume goes away. Waiting for that keeps this board
+	// from being counted again as the next one.
+	if _, err := waitFor(step, func() string {
+		if bootVolume() != dev {
+			return "gone"
+		}
+		return ""
+	}); err != nil {
+		return fail("%s did not reboot", dev)
+	}
+
+	tty, err := waitFor(step, func() string { return newTTY(before) })
+	if err != nil {
+		return fail("no new tty appeared")
+	}
+	r.TTY = tty
+
+	if prodExpect != "" {
+		if _, err := script.Exec(`sudo chmod a+rw ` + tty).String(); err != nil {
+			return fail("chmod %s: %v", tty, err)
+		}
+		if err := expectSerial(step, tty, prodExpect); err != nil {
+			return fail("%v", err)
+		}
+		r.Detail = fmt.Sprintf("saw %q", prodExpect)
+	}
+	r.Pass = true
+	r.Duration = time.Since(r.When)
+	return r
+}
+
+// waitFor polls until f returns something, or the context ends.
+func waitFor(ctx context.Context, f func() string) (string, error) {
+	tick := time.NewTicker(250 * time.Millisecond)
+	defer tick.Stop()
+	for {
+		if s := f(); s != "" {
+			return s, nil
+		}
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-tick.C:
+		}
+	}
+}
+
+// bootVolume is the block device of a board in BOOTSEL mode, or "".
+func bootVolume() string {
+	dev, err := filepath.EvalSymlinks("/dev/disk/by-label/" + bootLabel)
+	if err != nil {
+		return ""
+	}
+	return dev
+}
+
+// mountPoint is where dev is mounted, or "".
+func mountPoint(dev string) string {
+	f, err := os.Open("/proc/mounts")
+	if err != nil {
+		return ""
+	}
+	defer f.Close() //nolint:errcheck,gosec // read-only
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) >= 2 && fields[0] == dev {
+			// /proc/mounts escapes spaces in paths as \040.
+			return strings.ReplaceAll(fields[1], `\040`, " ")
+		}
+	}
+	return ""
+}
+
+// usbSerial finds the USB serial number of the device behind a block device,
+// by walking up its sysfs path to the USB device that owns it. The RP2040 boot
+// ROM reports the flash chip's unique ID here, so it identifies the board
+// rather than the port it was plugged into.
+func usbSerial(dev string) string {
+	p, err := filepath.EvalSymlinks("/sys/class/block/" + filepath.Base(dev))
+	if err != nil {
+		return ""
+	}
+	for ; p != "/" && p != "/sys/devices"; p = filepath.Dir(p) {
+		if b, err := os.ReadFile(filepath.Join(p, "serial")); err == nil { //nolint:gosec // a sysfs attribute
+			return strings.TrimSpace(string(b))
+		}
+	}
+	return ""
+}
+
+// ttys is the set of USB CDC serial devices present now.
+func ttys() map[string]bool {
+	m := map[string]bool{}
+	found, _ := filepath.Glob("/dev/ttyACM*") //nolint:errcheck // the pattern is constant and valid
+	for _, t := range found {
+		m[t] = true
+	}
+	return m
+}
+
+// newTTY returns a serial device that was not present before, or "".
+func newTTY(before map[string]bool) string {
+	for t := range ttys() {
+		if !before[t] {
+			return t
+		}
+	}
+	return ""
+}
+
+// expectSerial reads from the tty until want appears in the output. The port
+// is retried while it refuses to open, because udev may not have finished with
+// it yet when it first appears.
+func expectSerial(ctx context.Context, tty, want string) error {
+	var port *serial.Port
+	var err error
+	for port == nil {
+		port, err = serial.OpenPort(&serial.Config{Name: tty, Baud: baud, ReadTimeout: 500 * time.Millisecond})
+		if err != nil {
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("open %s: %w", tty, err)
+			case <-time.After(250 * time.Millisecond):
+			}
+		}
+	}
+	defer port.Close() //nolint:errcheck,gosec // done with the board
+
+	var seen strings.Builder
+	buf := make([]byte, 256)
+	for ctx.Err() == nil {
+		n, err := port.Read(buf)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("read %s: %w", tty, err)
+		}
+		seen.Write(buf[:n])
+		if strings.Contains(seen.String(), want) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%q not seen on %s", want, tty)
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src) //nolint:gosec // the image this run just built
+	if err != nil {
+		return err
+	}
+	defer in.Close() //nolint:errcheck,gosec // read-only
+
+	o, err := os.Create(dst) //nolint:gosec // the boot volume the board exposed
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(o, in); err != nil {
+		o.Close() //nolint:errcheck,gosec // the copy error is the one worth reporting
+		return err
+	}
+	// The board reboots the moment the last block lands, which can make the
+	// close fail on a volume that no longer exists. The data is already there
+	// by then, so Sync is the check that matters.
+	if err := o.Sync(); err != nil {
+		o.Close() //nolint:errcheck,gosec // the sync error is the one worth reporting
+		return err
+	}
+	o.Close() //nolint:errcheck,gosec // see above
+	return nil
+}
+
+// resultLog is the CSV the production run appends to, one row per board.
+type resultLog struct {
+	f *os.File
+	w *csv.Writer
+}
+
+func openResultLog(path string) (*resultLog, error) {
+	_, statErr := os.Stat(path)
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644) //nolint:gosec // a log meant to be read
+	if err != nil {
+		return nil, err
+	}
+	l := &resultLog{f: f, w: csv.NewWriter(f)}
+	if os.IsNotExist(statErr) {
+		if err := l.w.Write([]string{"time", "serial", "tty", "result", "duration_s", "detail"}); err != nil {
+			f.Close() //nolint:errcheck,gosec // the write error is the one worth reporting
+			return nil, err
+		}
+		l.w.Flush()
+	}
+	if err := l.w.Error(); err != nil {
+		f.Close() //nolint:errcheck,gosec // as above
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *resultLog) write(r boardResult) error {
+	res := "pass"
+	if !r.Pass {
+		res = "fail"
+	}
+	if err := l.w.Write([]string{
+		r.When.Format(time.RFC3339), r.Serial, r.TTY, res,
+		fmt.Sprintf("%.1f", r.Duration.Seconds()), r.Detail,
+	}); err != nil {
+		return err
+	}
+	// Flushed per row, so a run stopped with ^C has logged every board it
+	// finished.
+	l.w.Flush()
+	return l.w.Error()
+}
+
+func (l *resultLog) Close() error { return l.f.Close() }
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// The log is a CSV with one header, however many runs append to it, and a row
+// per board that a spreadsheet reads back as it was written.
+func TestResultLogAppendsARowPerBoard(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "production.csv")
+	when := time.Date(2026, 10, 19, 14, 30, 0, 0, time.UTC)
+	runs := [][]boardResult{
+		{
+			{When: when, Serial: "E6614C311B4A7A2D", TTY: "/dev/ttyACM0", Pass: true, Duration: 4200 * time.Millisecond, Detail: `saw "configured lcd"`},
+			{When: when.Add(time.Minute), Serial: "E6614C311B4A7A2E", Duration: 30 * time.Second, Detail: "no new tty appeared"},
+		},
+		{
+			{When: when.Add(time.Hour), TTY: "/dev/ttyACM1", Pass: true, Duration: 3 * time.Second},
+		},
+	}
+	for _, run := range runs {
+		l, err := openResultLog(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, r := range run {
+			if err := l.write(r); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := l.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close() //nolint:errcheck // read-only
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"time", "serial", "tty", "result", "duration_s", "detail"},
+		{"2026-10-19T14:30:00Z", "E6614C311B4A7A2D", "/dev/ttyACM0", "pass", "4.2", `saw "configured lcd"`},
+		{"2026-10-19T14:31:00Z", "E6614C311B4A7A2E", "", "fail", "30.0", "no new tty appeared"},
+		{"2026-10-19T15:30:00Z", "", "/dev/ttyACM1", "pass", "3.0", ""},
+	}
+	if !slices.EqualFunc(rows, want, slices.Equal) {
+		t.Errorf("log\n%q\nwant\n%q", rows, want)
+	}
+}
+
+func TestOpenResultLogReportsAnUnwritablePath(t *testing.T) {
+	if _, err := openResultLog(filepath.Join(t.TempDir(), "missing", "production.csv")); err == nil {
+		t.Error("opened a log in a directory that does not exist")
+	}
+}
+
+func TestCopyFileCopiesTheImage(t *testing.T) {
+	dir := t.TempDir()
+	src, dst := filepath.Join(dir, "firmware.uf2"), filepath.Join(dir, "volume.uf2")
+	img := []byte("UF2\n\x57\x51\x5d\x9e")
+	if err := os.WriteFile(src, img, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := copyFile(src, dst); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := os.ReadFile(dst); err != nil || !slices.Equal(got, img) {
+		t.Errorf("copied %q, %v", got, err)
+	}
+	if err := copyFile(src, filepath.Join(dir, "missing", "volume.uf2")); err == nil {
+		t.Error("copied to a volume that is not there")
+	}
+}
@@ -0,0 +1,341 @@
+// Named flash profiles.
+//
+// The same few configurations get flashed over and over — the kitchen clock,
+// the relay board, the bible scroller — and each one needs GOPROG, a target,
+// the device paths and a handful of generated flag values. A profile in
+// mcu.yaml names that set once:
+//
+//	profiles:
+//	  pico:
+//	    target: pico
+//	    dev: /dev/sdd1
+//	    ser: /dev/ttyACM?
+//	  kitchen:
+//	    extends: pico
+//	    goprog: firmware/main.go
+//	    flags:
+//	      offSet: "9"
+//
+// and `mcu flash --profile kitchen` flashes it. A profile supplies defaults and
+// nothing more: anything given on the command line still wins.
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"go.yaml.in/yaml/v3"
+)
+
+// configName is the project file looked for in the working directory and
+// each of its parents, the way go looks for go.mod.
+const configName = "mcu.yaml"
+
+// Profile is one named configuration. The named fields are the ones every
+// profile needs; Flags carries the rest, keyed by flag name, so a variable
+// generated from GOPROG can be set without this type knowing about it.
+type Profile struct {
+	Extends string            `yaml:"extends,omitempty"`
+	GoProg  string            `yaml:"goprog,omitempty"`
+	Target  string            `yaml:"target,omitempty"`
+	Dev     string            `yaml:"dev,omitempty"`
+	Ser     string            `yaml:"ser,omitempty"`
+	Baud    int               `yaml:"baud,omitempty"`
+	Slp     string            `yaml:"slp,omitempty"`
+	Flags   map[string]string `yaml:"flags,omitempty"`
+}
+
+// Project is the whole of mcu.yaml.
+type Project struct {
+	Profiles map[string]*Profile `yaml:"profiles"`
+
+	path string // where it was read from, for messages
+}
+
+var (
+	project     *Project
+	profileName string
+	profile     *Profile // the selected profile with its parents merged in
+)
+
+// findConfig returns the nearest mcu.yaml at or above the working directory,
+// or "" if there is none. MCUCONFIG names one explicitly.
+func findConfig() string {
+	if p := os.Getenv("MCUCONFIG"); p != "" {
+		return p
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		return ""
+	}
+	for {
+		p := filepath.Join(dir, configName)
+		if _, err := os.Stat(p); err == nil {
+			return p
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// loadProject reads a project file. Most invocations never ask for a profile,
+// but a malformed file is still reported rather than ignored: a typo in it
+// would otherwise surface as a profile that silently flashes the wrong thing.
+func loadProject(path string) (*Project, error) {
+	data, err := os.ReadFile(path) //nolint:gosec // the path is the project file the user pointed at
+	if err != nil {
+		return nil, err
+	}
+	p := &Project{path: path}
+	if err := yaml.Unmarshal(data, p); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return p, nil
+}
+
+// resolve merges a profile with everything it extends, parents first, so the
+// child's values win. A cycle is an error rather than a hang.
+func (p *Project) resolve(name string) (*Profile, error) {
+	var chain []*Profile
+	seen := map[string]bool{}
+	for n := name; n != ""; {
+		if seen[n] {
+			return nil, fmt.Errorf("profile %q extends itself through %q", name, n)
+		}
+		seen[n] = true
+		pr, ok := p.Profiles[n]
+		if !ok {
+			if n == name {
+				return nil, fmt.Errorf("no profile named %q in %s", name, p.path)
+			}
+			return nil, fmt.Errorf("profile %q extends %q, which does not exist", name, n)
+		}
+		chain = append(chain, pr)
+		n = pr.Extends
+	}
+
+	out := &Profile{Flags: map[string]string{}}
+	for i := len(chain) - 1; i >= 0; i-- {
+		pr := chain[i]
+		if pr.GoProg != "" {
+			out.GoProg = pr.GoProg
+		}
+		if pr.Target != "" {
+			out.Target = pr.Target
+		}
+		if pr.Dev != "" {
+			out.Dev = pr.Dev
+		}
+		if pr.Ser != "" {
+			out.Ser = pr.Ser
+		}
+		if pr.Baud != 0 {
+			out.Baud = pr.Baud
+		}
+		if pr.Slp != "" {
+			out.Slp = pr.Slp
+		}
+		for k, v := range pr.Flags {
+			out.Flags[k] = v
+		}
+	}
+	out.Extends = chain[0].Extends
+	return out, nil
+}
+
+// values is the profile as flag name to value, which is the only form the
+// commands need it in.
+func (p *Profile) values() map[string]string {
+	v := map[string]string{}
+	for k, s := range p.Flags {
+		v[k] = s
+	}
+	for k, s := range map[string]string{"target": p.Target, "dev": p.Dev, "ser": p.Ser, "slp": p.Slp} {
+		if s != "" {
+			v[k] = s
+		}
+	}
+	if p.Baud != 0 {
+		v["baud"] = strconv.Itoa(p.Baud)
+	}
+	return v
+}
+
+// argValue finds the value of a long flag in args, os.Args without the program,
+// before cobra has parsed anything. The profile decides which GOPROG is read,
+// and the flags are generated from GOPROG, so it has to be known before the
+// flags exist.
+func argValue(args []string, name string) string {
+	for i, a := range args {
+		if a == "--" {
+			break
+		}
+		if v, ok := strings.CutPrefix(a, "--"+name+"="); ok {
+			return v
+		}
+		if a == "--"+name && i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return ""
+}
+
+// readProject loads the nearest project file into project, if there is one.
+// It is read when something asks for it, not as mcu starts: a command that
+// has no use for profiles is not stopped by a typo in the file.
+func readProject() error {
+	path := findConfig()
+	if path == "" {
+		project = nil
+		return nil
+	}
+	var err error
+	if project, err = loadProject(path); err != nil {
+		return fmt.Errorf("reading project file: %w", err)
+	}
+	return nil
+}
+
+// selectProfile loads the project file and the profile named on the command
+// line, if any, and returns the GOPROG to use.
+//
+// A profile that names a program overrides the GOPROG environment variable.
+// The profile was asked for on this command line; the environment may have
+// been exported hours ago for something else, and flashing the kitchen clock
+// with the relay board's flags is the mistake profiles exist to prevent.
+func selectProfile(args []string, envGoProg string) (string, error) {
+	if err := readProject(); err != nil {
+		return "", err
+	}
+
+	profileName = argValue(args, "profile")
+	if profileName == "" {
+		return envGoProg, nil
+	}
+	if project == nil {
+		return "", fmt.Errorf("--profile %s given but no %s found", profileName, configName)
+	}
+	var err error
+	if profile, err = project.resolve(profileName); err != nil {
+		return "", err
+	}
+	if profile.GoProg != "" {
+		return project.rel(profile.GoProg), nil
+	}
+	return envGoProg, nil
+}
+
+// rel resolves a path written in the project file. It is relative to the file,
+// not to wherever mcu happens to be run from, since the file is found by
+// searching upwards; it is then made relative to the working directory again
+// where that is possible, so the generated tinygo command stays readable.
+func (p *Project) rel(path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	abs := filepath.Join(filepath.Dir(p.path), path)
+	if wd, err := os.Getwd(); err == nil {
+		if r, err := filepath.Rel(wd, abs); err == nil {
+			return r
+		}
+	}
+	return abs
+}
+
+// addProfileFlag gives the commands --profile. selectProfile reads it before
+// cobra does; the flag is there so cobra accepts it and --help lists it.
+func addProfileFlag(cmds ...*cobra.Command) {
+	for _, c := range cmds {
+		c.Flags().String("profile", "", "named profile from "+configName+" supplying defaults for these flags")
+	}
+}
+
+// applyProfile makes the selected profile's values the defaults of the given
+// commands' flags. Setting the default rather than the value is what lets the
+// command line override it, and it is also what shows in --help, so the help
+// menu says what will actually be flashed.
+func applyProfile(cmds ...*cobra.Command) error {
+	if profile == nil {
+		return nil
+	}
+	used := map[string]bool{}
+	for name, v := range profile.values() {
+		for _, c := range cmds {
+			f := c.Flags().Lookup(name)
+			if f == nil {
+				continue
+			}
+			if err := f.Value.Set(v); err != nil {
+				return fmt.Errorf("profile %s: --%s: %w", profileName, name, err)
+			}
+			f.DefValue = f.Value.String()
+			used[name] = true
+		}
+	}
+	for name := range profile.values() {
+		if !used[name] {
+			notice(fmt.Sprintf("profile %s: no flag named --%s for %s\n", profileName, name, goProg))
+		}
+	}
+	return nil
+}
+
+func init() {
+	RootCmd.AddCommand(profilesCmd)
+}
+
+var profilesCmd = &cobra.Command{
+	Use:                   "profiles",
+	Short:                 "list the named profiles in " + configName,
+	SilenceErrors:         true,
+	SilenceUsage:          true,
+	DisableSuggestions:    true,
+	DisableFlagsInUseLine: true,
+	RunE: func(_ *cobra.Command, _ []string) error {
+		if err := readProject(); err != nil {
+			return err
+		}
+		if project == nil {
+			return fmt.Errorf("no %s found in this directory or any parent", configName)
+		}
+		names := make([]string, 0, len(project.Profiles))
+		for n := range project.Profiles {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+
+		out(project.path + "\n\n")
+		for _, n := range names {
+			p, err := project.resolve(n)
+			if err != nil {
+				return err
+			}
+			line := n
+			if p.Extends != "" {
+				line += " (extends " + p.Extends + ")"
+			}
+			out(line + "\n")
+			if p.GoProg != "" {
+				out(fmt.Sprintf("    %-10s %s\n", "goprog", p.GoProg))
+			}
+			vals := p.values()
+			keys := make([]string, 0, len(vals))
+			for k := range vals {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				out(fmt.Sprintf("    %-10s %s\n", k, vals[k]))
+			}
+		}
+		return nil
+	},
+}
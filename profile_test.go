@@ -0,0 +1,164 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// A profile is its parents' values with its own on top, nearest last.
+func TestResolveMergesParentsFirst(t *testing.T) {
+	p := &Project{Profiles: map[string]*Profile{
+		"pico":    {Target: "pico", Dev: "/dev/sdd1", Baud: 9600, Flags: map[string]string{"offSet": "0", "rtcFuture": "false"}},
+		"clock":   {Extends: "pico", GoProg: "firmware/main.go", Flags: map[string]string{"offSet": "9"}},
+		"kitchen": {Extends: "clock", Dev: "/dev/sde1"},
+	}}
+	got, err := p.resolve("kitchen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Target != "pico" || got.Dev != "/dev/sde1" || got.GoProg != "firmware/main.go" || got.Baud != 9600 {
+		t.Errorf("resolved %+v", got)
+	}
+	if got.Flags["offSet"] != "9" || got.Flags["rtcFuture"] != "false" {
+		t.Errorf("flags %v", got.Flags)
+	}
+	if got.Extends != "clock" {
+		t.Errorf("extends %q, want the profile's own", got.Extends)
+	}
+	if p.Profiles["pico"].Flags["offSet"] != "0" {
+		t.Error("resolving a child changed its parent")
+	}
+}
+
+// Everything resolve refuses, it refuses with an error that names the profile
+// asked for, rather than by hanging or by flashing something else.
+func TestResolveRefusesWhatItCannotMerge(t *testing.T) {
+	p := &Project{path: "mcu.yaml", Profiles: map[string]*Profile{
+		"self":    {Extends: "self"},
+		"a":       {Extends: "b"},
+		"b":       {Extends: "a"},
+		"orphan":  {Extends: "missing"},
+		"through": {Extends: "a"},
+	}}
+	for _, tc := range []struct {
+		name, want string
+	}{
+		{"self", `profile "self" extends itself through "self"`},
+		{"a", `profile "a" extends itself through "a"`},
+		{"through", `profile "through" extends itself through "a"`},
+		{"orphan", `profile "orphan" extends "missing", which does not exist`},
+		{"nope", `no profile named "nope" in mcu.yaml`},
+	} {
+		if _, err := p.resolve(tc.name); err == nil || err.Error() != tc.want {
+			t.Errorf("%s: error %v, want %s", tc.name, err, tc.want)
+		}
+	}
+}
+
+func TestArgValueReadsALongFlagBeforeCobraDoes(t *testing.T) {
+	for _, tc := range []struct {
+		args string
+		want string
+	}{
+		{"flash --profile kitchen", "kitchen"},
+		{"flash --profile=kitchen --target pico", "kitchen"},
+		{"flash --target pico --profile kitchen", "kitchen"},
+		{"flash --profile=", ""},
+		{"flash --profile", ""},
+		{"flash -- --profile kitchen", ""},
+		{"flash --profiles kitchen", ""},
+		{"flash", ""},
+	} {
+		if got := argValue(strings.Fields(tc.args), "profile"); got != tc.want {
+			t.Errorf("%s: %q, want %q", tc.args, got, tc.want)
+		}
+	}
+}
+
+// selectProfile's choice of GOPROG, from a project file MCUCONFIG names.
+func TestSelectProfileChoosesTheProgram(t *testing.T) {
+	savedProject, savedName, savedProfile := project, profileName, profile
+	t.Cleanup(func() { project, profileName, profile = savedProject, savedName, savedProfile })
+
+	dir := t.TempDir()
+	config := filepath.Join(dir, configName)
+	if err := os.WriteFile(config, []byte(`profiles:
+  pico: {target: pico}
+  kitchen: {extends: pico, goprog: firmware/main.go}
+  loop: {extends: loop}
+`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("MCUCONFIG", config)
+
+	for _, tc := range []struct {
+		args, env, want, err string
+	}{
+		{args: "flash", env: "relay.go", want: "relay.go"},
+		{args: "flash --profile pico", env: "relay.go", want: "relay.go"},
+		{args: "flash --profile kitchen", env: "relay.go", want: filepath.Join(dir, "firmware/main.go")},
+		{args: "flash --profile nope", err: `no profile named "nope"`},
+		{args: "flash --profile loop", err: "extends itself"},
+	} {
+		got, err := selectProfile(strings.Fields(tc.args), tc.env)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%s: error %v, want %s", tc.args, err, tc.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tc.args, err)
+			continue
+		}
+		// The program is given relative to the working directory.
+		if abs, _ := filepath.Abs(got); abs != tc.want && got != tc.want {
+			t.Errorf("%s: GOPROG %s, want %s", tc.args, got, tc.want)
+		}
+	}
+
+	if err := os.WriteFile(config, []byte("profiles: [\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := selectProfile(nil, ""); err == nil || !strings.Contains(err.Error(), config) {
+		t.Errorf("a malformed project file: error %v", err)
+	}
+
+	t.Setenv("MCUCONFIG", "")
+	t.Chdir(t.TempDir())
+	project = nil
+	if _, err := selectProfile([]string{"--profile", "pico"}, ""); err == nil || !strings.Contains(err.Error(), "no "+configName) {
+		t.Errorf("no project file: error %v", err)
+	}
+}
+
+// The project file is read by the commands that take a profile, so one that
+// does not parse is their error and nobody else's.
+func TestAMalformedProjectFileOnlyStopsTheCommandsThatUseIt(t *testing.T) {
+	savedProject, savedName, savedProfile, savedProg := project, profileName, profile, goProg
+	t.Cleanup(func() { project, profileName, profile, goProg = savedProject, savedName, savedProfile, savedProg })
+
+	config := filepath.Join(t.TempDir(), configName)
+	if err := os.WriteFile(config, []byte("profiles: [\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("MCUCONFIG", config)
+	for _, args := range []string{"schematic --plain", "completion bash", "mon --help", "--help"} {
+		if err := prepare(strings.Fields(args)); err != nil {
+			t.Errorf("%s: %v", args, err)
+		}
+	}
+	for _, args := range []string{"eval", "__complete eval --", "profiles"} {
+		var err error
+		if args == "profiles" {
+			err = profilesCmd.RunE(profilesCmd, nil)
+		} else {
+			err = prepare(strings.Fields(args))
+		}
+		if err == nil || !strings.Contains(err.Error(), config) {
+			t.Errorf("%s: error %v, want one naming %s", args, err, config)
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package schematic
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// PinType is what a pin does electrically, which is what an electrical rule
+// check needs and a pin's name does not say: GP0 may be an input or an output,
+// and VCC is an input on a display and an output on a regulator.
+type PinType string
+
+// The pin types, as a schematic file writes them. They are KiCad's, less the
+// distinctions a board-level drawing has no use for.
+const (
+	Untyped       PinType = ""              // not known; the checks assume nothing about it
+	Input         PinType = "input"         // driven by something else: an enable, a clock into a peripheral
+	Output        PinType = "output"        // drives its net high and low
+	Bidirectional PinType = "bidirectional" // either, as a GPIO or a data bus is
+	OpenDrain     PinType = "open_drain"    // pulls its net low, and needs a pull-up to go high
+	PowerIn       PinType = "power_in"      // a supply or ground the part needs to work
+	PowerOut      PinType = "power_out"     // a supply or ground the part provides
+	PassivePin    PinType = "passive"       // neither drives nor needs driving: a resistor's end, a backlight
+	NoConnect     PinType = "no_connect"    // must not be wired
+)
+
+// PinTypes is every pin type but Untyped, in the order the constants are.
+var PinTypes = []PinType{Input, Output, Bidirectional, OpenDrain, PowerIn, PowerOut, PassivePin, NoConnect}
+
+// pinType is the type of one of the board's pins, Untyped unless Types says.
+func (b *Board) pinType(pin string) PinType { return b.Types[pin] }
+
+// drives reports whether a pin of type t can set the level of an input on its
+// net. A passive may be a pull-up, and an untyped pin may be anything, so
+// both are given the benefit of the doubt.
+func (t PinType) drives() bool {
+	switch t {
+	case Input, PowerIn, NoConnect:
+		return false
+	}
+	return true
+}
+
+// Rule is one of the checks ERC makes, by the name its severity is set by.
+type Rule string
+
+// The rules.
+const (
+	RuleOutputConflict   Rule = "output-conflict"   // two outputs on one net, each driving against the other
+	RuleUndrivenInput    Rule = "undriven-input"    // an input whose net nothing drives
+	RuleUndrivenPower    Rule = "undriven-power"    // a power input whose net no supply is on
+	RuleUnconnectedPower Rule = "unconnected-power" // a power input with no wire to it
+	RuleUnconnectedInput Rule = "unconnected-input" // an input with no wire to it
+	RuleI2CPullUp        Rule = "i2c-pull-up"       // an I2C line with no passive on it to pull it up
+	RuleNoConnect        Rule = "no-connect"        // a wire to a pin that must not have one
+	RuleLogicLevel       Rule = "logic-level"       // an output too low for the input it drives to read it as high
+	RuleOvervoltage      Rule = "overvoltage"       // an output or supply above what the pin it drives tolerates
+	RuleUndervoltage     Rule = "undervoltage"      // a supply below what the part on it needs
+	RuleOverBudget       Rule = "over-budget"       // a rail whose loads draw more than its source can give
+)
+
+// Rules is every rule, in the order ERC reports them for a net.
+var Rules = []Rule{
+	RuleOutputConflict, RuleUndrivenInput, RuleUndrivenPower, RuleUnconnectedPower,
+	RuleUnconnectedInput, RuleI2CPullUp, RuleNoConnect,
+	RuleLogicLevel, RuleOvervoltage, RuleUndervoltage, RuleOverBudget,
+}
+
+// Severity is how much a rule's violations matter.
+type Severity int
+
+// The severities. An ignored rule is not checked.
+const (
+	SeverityIgnore Severity = iota
+	SeverityWarning
+	SeverityError
+)
+
+func (v Severity) String() string {
+	switch v {
+	case SeverityIgnore:
+		return "ignore"
+	case SeverityWarning:
+		return "warning"
+	case SeverityError:
+		return "error"
+	}
+	return fmt.Sprintf("Severity(%d)", int(v))
+}
+
+// ParseSeverity reads a severity as String writes it.
+func ParseSeverity(s string) (Severity, error) {
+	for _, v := range []Severity{SeverityIgnore, SeverityWarning, SeverityError} {
+		if strings.EqualFold(s, v.String()) {
+			return v, nil
+		}
+	}
+	return 0, fmt.Errorf("severity %q: want ignore, warning or error", s)
+}
+
+// DefaultSeverity is each rule's severity unless a schematic's Severity says
+// otherwise. What will not work is an error; what may work, because the part
+// does more than its pins say, is a warning: most I2C modules carry their own
+// pull-ups, an unconnected input may be pulled on the board, and a part run
+// below its rated supply or a little under an input's threshold often works
+// on the bench. What damages a part — a voltage above its maximum, a source
+// asked for more than it gives — is an error.
+var DefaultSeverity = map[Rule]Severity{
+	RuleOutputConflict:   SeverityError,
+	RuleUndrivenInput:    SeverityError,
+	RuleUndrivenPower:    SeverityError,
+	RuleUnconnectedPower: SeverityError,
+	RuleUnconnectedInput: SeverityWarning,
+	RuleI2CPullUp:        SeverityWarning,
+	RuleNoConnect:        SeverityError,
+	RuleLogicLevel:       SeverityWarning,
+	RuleOvervoltage:      SeverityError,
+	RuleUndervoltage:     SeverityWarning,
+	RuleOverBudget:       SeverityError,
+}
+
+// Violation is one problem ERC finds.
+type Violation struct {
+	Rule     Rule
+	Severity Severity
+	Pins     []string // the pins it is about, as board.pin
+	Msg      string
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("%s: %s [%s]", v.Severity, v.Msg, v.Rule)
+}
+
+// severity is the rule's severity for this schematic.
+func (s *Schematic) severity(r Rule) Severity {
+	if v, ok := s.Severity[r]; ok {
+		return v
+	}
+	return DefaultSeverity[r]
+}
+
+// ERC checks the wiring against the pins' types, as the electrical rule check
+// of a schematic editor does. Validate says whether the schematic can be drawn;
+// ERC says whether the circuit it draws can work: that no two outputs fight,
+// that every input and every supply pin has something on the other end, that
+// an I2C bus has its pull-ups, and, for the pins whose Levels are known, that
+// each input can read what drives it and each rail can supply its loads.
+//
+// A pin with no type is assumed to be whatever makes the check pass, so a
+// schematic of boards that are not from the part library has nothing to
+// report; typing its pins is what makes the checks mean something. The pins
+// of one board on the same rail are one net, as they are for KiCadNetlist, so
+// a Pico with one of its eight grounds wired has them all wired.
+//
+// The violations are reported net by net, and then pin by pin for the pins
+// with no wire, each with the severity Severity gives its rule. A rule at
+// SeverityIgnore is not checked.
+func (s *Schematic) ERC() ([]Violation, error) {
+	groups, err := s.connect()
+	if err != nil {
+		return nil, err
+	}
+	var vs []Violation
+	reportRefs := func(r Rule, refs []string, format string, args ...any) {
+		if sev := s.severity(r); sev != SeverityIgnore {
+			vs = append(vs, Violation{r, sev, refs, fmt.Sprintf(format, args...)})
+		}
+	}
+	report := func(r Rule, pins []netPin, format string, args ...any) {
+		refs := make([]string, len(pins))
+		for i, p := range pins {
+			refs[i] = p.ref()
+		}
+		reportRefs(r, refs, format, args...)
+	}
+
+	wired := map[string]bool{}
+	for _, g := range groups {
+		byType := map[PinType][]netPin{}
+		var drivers []netPin
+		driving := map[string]bool{} // by board and rail, so a Pico's grounds are one driver
+		driven, powered, pulled, i2c := false, false, false, g.nets["I2C"] > 0
+		for _, p := range g.pins {
+			wired[p.ref()] = true
+			t := p.board.pinType(p.name)
+			byType[t] = append(byType[t], p)
+			if t == Output || t == PowerOut {
+				key := p.ref()
+				if r := rail(p.board.label(p.name)); r != "" {
+					key = p.board.Name + "." + r
+				}
+				if !driving[key] {
+					driving[key] = true
+					drivers = append(drivers, p)
+				}
+			}
+			driven = driven || t.drives()
+			powered = powered || t == PowerOut || t == Untyped
+			pulled = pulled || p.board.Passive
+			i2c = i2c || isI2C(p.board.label(p.name)) || isI2C(p.name)
+		}
+		name := netName(g)
+
+		if len(drivers) > 1 {
+			report(RuleOutputConflict, drivers, "%s: %s drive it against each other", name, joinRefs(drivers))
+		}
+		if ins := byType[Input]; len(ins) > 0 && !driven {
+			report(RuleUndrivenInput, ins, "%s: nothing drives %s", name, joinRefs(ins))
+		}
+		if ins := byType[PowerIn]; len(ins) > 0 && !powered {
+			report(RuleUndrivenPower, ins, "%s: nothing supplies %s", name, joinRefs(ins))
+		}
+		if i2c && !pulled {
+			report(RuleI2CPullUp, g.pins, "%s: an I2C line with no pull-up on it", name)
+		}
+		if ncs := byType[NoConnect]; len(ncs) > 0 {
+			report(RuleNoConnect, ncs, "%s: %s must not be connected", name, joinRefs(ncs))
+		}
+		checkLevels(g, name, report)
+	}
+	overBudget(budgets(groups), reportRefs)
+
+	for _, b := range s.Boards {
+		for _, p := range b.kicadPins() {
+			np := netPin{b, p.name, p.num}
+			if wired[np.ref()] {
+				continue
+			}
+			switch b.pinType(p.name) {
+			case PowerIn:
+				report(RuleUnconnectedPower, []netPin{np}, "%s is not connected: %s has no supply", np.ref(), b.title())
+			case Input:
+				report(RuleUnconnectedInput, []netPin{np}, "%s is not connected", np.ref())
+			}
+		}
+	}
+	return vs, nil
+}
+
+// netName is how a violation names a net: by its wires' Net, and its first
+// pin, since several nets may share a Net.
+func netName(g netGroup) string {
+	nets := make([]string, 0, len(g.nets))
+	for n := range g.nets {
+		if n != "" {
+			nets = append(nets, n)
+		}
+	}
+	sort.Strings(nets)
+	if len(nets) == 0 {
+		return "net at " + g.pins[0].ref()
+	}
+	return fmt.Sprintf("%s net at %s", strings.Join(nets, "/"), g.pins[0].ref())
+}
+
+func joinRefs(ps []netPin) string {
+	refs := make([]string, len(ps))
+	for i, p := range ps {
+		refs[i] = p.ref()
+	}
+	return strings.Join(refs, ", ")
+}
+
+// isI2C reports whether a label names an I2C line: SDA or SCL, with or without
+// a bus number, in any of its slash-separated parts.
+func isI2C(label string) bool {
+	for _, part := range strings.Split(label, "/") {
+		switch strings.ToUpper(strings.TrimRight(strings.TrimSpace(part), "0123456789")) {
+		case "SDA", "SCL":
+			return true
+		}
+	}
+	return false
+}
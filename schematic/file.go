@@ -0,0 +1,426 @@
+package schematic
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"go.yaml.in/yaml/v3"
+)
+
+// File is a schematic as it is written down: the boards and wires under the
+// same names they have in Go, the nets the wires may use, and the drawing
+// settings worth keeping with the wiring. In YAML:
+//
+//	theme: light           # dark, the default, or light
+//	nets: [GND, PWR, I2C]  # if given, a wire on any other net is an error
+//	boards:
+//	  - name: pico
+//	    title: Pi-Pico
+//	    col: 1
+//	    first_pin: 1
+//	    left: [GP0, GP1, GND1]
+//	    labels: {GND1: GND}
+//	  - name: rtc
+//	    right: [GND, VCC, SDA, SCL]
+//	    passive: false
+//	wires:
+//	  - {from: pico.GP0, to: rtc.SDA, net: I2C}
+//
+// JSON has the same keys, and TOML writes boards and wires as [[boards]] and
+// [[wires]] tables. A board's key is its field's name in snake case; an empty
+// string in left or right is a Gap.
+//
+// A board may name a part instead of listing its pins, when the file is read
+// with a Library:
+//
+//	boards:
+//	  - name: lcd
+//	    part: hd44780
+//	    col: 2
+//
+// What the board does give — a title, labels, pins, types, levels — is kept
+// over the part's.
+//
+// A pin's electrical type, for ERC, is given under types, and a rule's
+// severity under erc:
+//
+//	erc: {i2c-pull-up: ignore, unconnected-input: error}
+//	boards:
+//	  - name: rtc
+//	    right: [GND, VCC, SDA, SCL]
+//	    types: {GND: power_in, VCC: power_in, SDA: open_drain, SCL: input}
+//	    levels: {VCC: {v_min: 4.5, v_max: 5.5, ma: 1.5}, SDA: {v_ih: 2.2}}
+//
+// and the wires to draw as net labels under net_labels, with a wire's name
+// for its label:
+//
+//	net_labels: {nets: [GND], length: 60}
+//	wires:
+//	  - {from: pico.GP0, to: rtc.SDA, net: I2C, name: SDA}
+//
+// What Optimize chooses is kept as lanes, and as a board's order in its column
+// and its mirrored:
+//
+//	lanes: direction
+//	boards:
+//	  - name: lcd
+//	    part: hd44780
+//	    mirrored: true
+//
+// It exists so that retargeting the diagram is an edit to a file rather than
+// to Go and a rebuild of mcu.
+type File struct {
+	Theme  string            `json:"theme,omitempty" yaml:"theme,omitempty" toml:"theme,omitempty"`
+	Gutter int               `json:"gutter,omitempty" yaml:"gutter,omitempty" toml:"gutter,omitzero"`
+	Lanes  LaneOrder         `json:"lanes,omitempty" yaml:"lanes,omitempty" toml:"lanes,omitempty"`
+	Nets   []string          `json:"nets,omitempty" yaml:"nets,omitempty" toml:"nets,omitempty"`
+	ERC    map[string]string `json:"erc,omitempty" yaml:"erc,omitempty" toml:"erc,omitempty"`
+
+	NetLabels *NetLabels `json:"net_labels,omitempty" yaml:"net_labels,omitempty" toml:"net_labels,omitempty"`
+
+	Boards []*Board `json:"boards" yaml:"boards" toml:"boards"`
+	Wires  []Wire   `json:"wires" yaml:"wires" toml:"wires"`
+}
+
+// Library makes a board of a part, named name, as parts.New does. It is how a
+// schematic file's boards are made from the parts they name, without this
+// package knowing what parts there are.
+type Library func(part, name string) (*Board, error)
+
+// Load reads a schematic file, in the format its extension names: .yaml or
+// .yml, .json or .toml. It is validated as it is read, and every problem is
+// reported, each at the line of the board or wire it is in:
+//
+//	wiring.yaml:42:17: wire 12: board "lcd" has no pin "BIT8"
+func Load(name string, data []byte) (*Schematic, error) {
+	return LoadWith(name, data, nil)
+}
+
+// LoadWith is Load for a file whose boards may name parts, which lib makes.
+// With a nil lib, a board that names a part has to list its pins as well.
+func LoadWith(name string, data []byte, lib Library) (*Schematic, error) {
+	var f File
+	var at positions
+	var err error
+	switch ext := strings.ToLower(filepath.Ext(name)); ext {
+	case ".yaml", ".yml", ".json":
+		// JSON is YAML, as far as the parser is concerned, so both get
+		// line numbers from the same place.
+		at, err = decodeYAML(name, data, &f)
+	case ".toml":
+		at, err = decodeTOML(name, data, &f)
+	default:
+		return nil, fmt.Errorf("%s: a schematic file is .yaml, .json or .toml, not %q", name, ext)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Schematic{Boards: f.Boards, Wires: f.Wires, Gutter: f.Gutter, Lanes: f.Lanes, NetLabels: f.NetLabels}
+	var errs []error
+	if !slices.Contains(LaneOrders, f.Lanes) {
+		errs = append(errs, fmt.Errorf("%s: lanes %q: want direction, crossings, or nothing", at.of(name, "lanes"), f.Lanes))
+	}
+	switch f.Theme {
+	case "", Dark.Name:
+	case Light.Name:
+		s.Theme = &Light
+	default:
+		errs = append(errs, fmt.Errorf("%s: theme %q: want dark or light", at.of(name, "theme"), f.Theme))
+	}
+	for _, r := range slices.Sorted(maps.Keys(f.ERC)) {
+		if !slices.Contains(Rules, Rule(r)) {
+			errs = append(errs, fmt.Errorf("%s: there is no rule %q", at.of(name, "erc."+r), r))
+			continue
+		}
+		v, err := ParseSeverity(f.ERC[r])
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s: %w", at.of(name, "erc."+r), r, err))
+			continue
+		}
+		if s.Severity == nil {
+			s.Severity = map[Rule]Severity{}
+		}
+		s.Severity[Rule(r)] = v
+	}
+	for i, b := range s.Boards {
+		switch {
+		case b == nil:
+			errs = append(errs, fmt.Errorf("%s: board %d is empty", at.of(name, fmt.Sprintf("boards[%d]", i)), i))
+		case b.Part != "" && lib != nil:
+			pb, err := lib(b.Part, b.Name)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: board %d: %w", at.of(name, fmt.Sprintf("boards[%d].part", i)), i, err))
+				continue
+			}
+			s.Boards[i] = fromPart(pb, b)
+		case b.Part != "" && len(b.Left) == 0 && len(b.Right) == 0:
+			errs = append(errs, fmt.Errorf("%s: board %d is a %s, but there is no part library to find its pins in", at.of(name, fmt.Sprintf("boards[%d].part", i)), i, b.Part))
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	if len(f.Nets) > 0 {
+		for i, w := range s.Wires {
+			if !slices.Contains(f.Nets, w.Net) {
+				errs = append(errs, fmt.Errorf("%s: wire %d: net %q is not one of %s", at.of(name, fmt.Sprintf("wires[%d].net", i)), i, w.Net, strings.Join(f.Nets, ", ")))
+			}
+		}
+		if f.NetLabels != nil {
+			for _, n := range f.NetLabels.Nets {
+				if !slices.Contains(f.Nets, n) {
+					errs = append(errs, fmt.Errorf("%s: net %q is not one of %s", at.of(name, "net_labels.nets"), n, strings.Join(f.Nets, ", ")))
+				}
+			}
+		}
+	}
+	for _, err := range s.Validate() {
+		var e *Error
+		path := ""
+		if errors.As(err, &e) {
+			switch {
+			case e.Board >= 0:
+				path = fmt.Sprintf("boards[%d]", e.Board)
+			case e.Wire >= 0 && e.End != "":
+				path = fmt.Sprintf("wires[%d].%s", e.Wire, e.End)
+			case e.Wire >= 0:
+				path = fmt.Sprintf("wires[%d]", e.Wire)
+			}
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", at.of(name, path), err))
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return s, nil
+}
+
+// fromPart is the board a file describes as b, made from the part p: the part's
+// pins, title, numbering, types and levels, with whatever b gives in their
+// place.
+func fromPart(p, b *Board) *Board {
+	p.Col = b.Col
+	p.Passive = p.Passive || b.Passive
+	if b.Title != "" {
+		p.Title = b.Title
+	}
+	if b.Mirrored {
+		// The board's pins, if it lists them, are as drawn already.
+		p.mirror()
+	}
+	if len(b.Left) > 0 || len(b.Right) > 0 {
+		p.Left, p.Right = b.Left, b.Right
+		// The part's types are kept for the pins the board still has.
+		maps.DeleteFunc(p.Types, func(pin string, _ PinType) bool { return !p.hasPin(pin) })
+		maps.DeleteFunc(p.Levels, func(pin string, _ Level) bool { return !p.hasPin(pin) })
+	}
+	if b.FirstPin != 0 {
+		p.FirstPin = b.FirstPin
+	}
+	for pin, l := range b.Labels {
+		if p.Labels == nil {
+			p.Labels = map[string]string{}
+		}
+		p.Labels[pin] = l
+	}
+	for pin, t := range b.Types {
+		if p.Types == nil {
+			p.Types = map[string]PinType{}
+		}
+		p.Types[pin] = t
+	}
+	for pin, l := range b.Levels {
+		if p.Levels == nil {
+			p.Levels = map[string]Level{}
+		}
+		p.Levels[pin] = l
+	}
+	return p
+}
+
+// Dump writes s in the form Load reads, as "yaml", "json" or "toml".
+func Dump(s *Schematic, format string) ([]byte, error) {
+	f := File{Gutter: s.Gutter, Lanes: s.Lanes, NetLabels: s.NetLabels, Boards: s.Boards, Wires: s.Wires}
+	for r, v := range s.Severity {
+		if f.ERC == nil {
+			f.ERC = map[string]string{}
+		}
+		f.ERC[string(r)] = v.String()
+	}
+	if s.Theme != nil && s.Theme.Name != Dark.Name {
+		f.Theme = s.Theme.Name
+	}
+	for _, w := range s.Wires {
+		if w.Net != "" && !slices.Contains(f.Nets, w.Net) {
+			f.Nets = append(f.Nets, w.Net)
+		}
+	}
+	switch format {
+	case "yaml":
+		var n yaml.Node
+		if err := n.Encode(&f); err != nil {
+			return nil, err
+		}
+		flow(&n)
+		var buf bytes.Buffer
+		enc := yaml.NewEncoder(&buf)
+		enc.SetIndent(2)
+		if err := enc.Encode(&n); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), enc.Close()
+	case "json":
+		b, err := json.MarshalIndent(&f, "", "  ")
+		return append(b, '\n'), err
+	case "toml":
+		var buf bytes.Buffer
+		err := toml.NewEncoder(&buf).Encode(&f)
+		return buf.Bytes(), err
+	}
+	return nil, fmt.Errorf("cannot write a schematic as %q: want yaml, json or toml", format)
+}
+
+// flow puts each list of pins, map of labels and wire on one line, which is
+// how they read best: a wire is one thing, not three.
+func flow(n *yaml.Node) {
+	for _, c := range n.Content {
+		flow(c)
+	}
+	switch n.Kind {
+	case yaml.SequenceNode, yaml.MappingNode:
+		for _, c := range n.Content {
+			if c.Kind != yaml.ScalarNode {
+				return
+			}
+		}
+		n.Style = yaml.FlowStyle
+	}
+}
+
+// positions is where each board, wire and field of them starts in the file,
+// by path: "boards[2]", "wires[5].to".
+type positions map[string]string
+
+// of is the position of path, or of the nearest thing containing it that has
+// one, prefixed with the file name.
+func (p positions) of(name, path string) string {
+	for path != "" {
+		if pos, ok := p[path]; ok {
+			return name + ":" + pos
+		}
+		i := strings.LastIndexAny(path, ".[")
+		if i < 0 {
+			break
+		}
+		path = path[:i]
+	}
+	return name
+}
+
+func decodeYAML(name string, data []byte, f *File) (positions, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, yamlError(name, err)
+	}
+	if len(root.Content) == 0 {
+		return nil, fmt.Errorf("%s: empty", name)
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(f); err != nil {
+		return nil, yamlError(name, err)
+	}
+	at := positions{}
+	var walk func(n *yaml.Node, path string)
+	walk = func(n *yaml.Node, path string) {
+		if path != "" {
+			at[path] = fmt.Sprintf("%d:%d", n.Line, n.Column)
+		}
+		switch n.Kind {
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(n.Content); i += 2 {
+				key := n.Content[i].Value
+				if path != "" {
+					key = path + "." + key
+				}
+				walk(n.Content[i+1], key)
+			}
+		case yaml.SequenceNode:
+			for i, c := range n.Content {
+				walk(c, fmt.Sprintf("%s[%d]", path, i))
+			}
+		}
+	}
+	walk(root.Content[0], "")
+	return at, nil
+}
+
+var yamlLine = regexp.MustCompile(`^(?:yaml: )?line (\d+): `)
+
+// yamlError puts the file name in front of each of the decoder's "line n:"
+// messages, so they read like a compiler's.
+func yamlError(name string, err error) error {
+	var msgs []string
+	var te *yaml.TypeError
+	if errors.As(err, &te) {
+		msgs = te.Errors
+	} else {
+		msgs = []string{err.Error()}
+	}
+	errs := make([]error, len(msgs))
+	for i, m := range msgs {
+		if sm := yamlLine.FindStringSubmatch(m); sm != nil {
+			errs[i] = fmt.Errorf("%s:%s: %s", name, sm[1], m[len(sm[0]):])
+		} else {
+			errs[i] = fmt.Errorf("%s: %s", name, strings.TrimPrefix(m, "yaml: "))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// decodeTOML reads a TOML file. The TOML decoder does not say where a key
+// was, so a board or wire is found by counting [[boards]] and [[wires]]
+// headers; one written inline is reported without a line.
+func decodeTOML(name string, data []byte, f *File) (positions, error) {
+	md, err := toml.Decode(string(data), f)
+	if err != nil {
+		var pe toml.ParseError
+		if errors.As(err, &pe) {
+			return nil, fmt.Errorf("%s:%d:%d: %s", name, pe.Position.Line, pe.Position.Col, pe.Message)
+		}
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if keys := md.Undecoded(); len(keys) > 0 {
+		unknown := make([]string, len(keys))
+		for i, k := range keys {
+			unknown[i] = k.String()
+		}
+		return nil, fmt.Errorf("%s: unknown keys %s", name, strings.Join(unknown, ", "))
+	}
+	at := positions{}
+	count := map[string]int{}
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "[[") {
+			continue
+		}
+		end := strings.Index(line, "]]")
+		if end < 0 {
+			continue
+		}
+		table := strings.TrimSpace(line[2:end])
+		at[fmt.Sprintf("%s[%d]", table, count[table])] = strconv.Itoa(i + 1)
+		count[table]++
+	}
+	return at, nil
+}
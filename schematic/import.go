@@ -0,0 +1,393 @@
+package schematic
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ImportKiCad builds a schematic from a KiCad netlist (.net), as KiCad 6 and
+// later write it, or as KiCadNetlist does.
+//
+// Each component becomes a board named by its reference. If lib has the part
+// the component is an instance of, or the part parts names for its reference,
+// the board is that part's; otherwise its pins are the library part's in the
+// netlist, in one column in the order of their numbers. A net reaches any
+// number of pins, and a wire joins two, so each net is wired as a star from
+// the board that is on the most nets — the microcontroller, as a rule. The
+// wires take the net's name as their Net, less the -(ref-PadN) KiCad adds to
+// an unnamed or repeated one.
+//
+// The boards are arranged around the board with the most wires, each on the
+// side of it its wires go to, as the firmware's diagram is.
+func ImportKiCad(name string, data []byte, lib Library, parts map[string]string) (*Schematic, error) {
+	nl, err := parseKiCadNetlist(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	imp := newImporter(lib)
+	byNum := map[string]map[int]string{} // ref → pin number → pin name
+	for _, c := range nl.comps {
+		part, explicit := parts[c.ref]
+		if !explicit {
+			part = c.part
+		}
+		b, err := imp.fromLibrary(part, c.ref, explicit)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		if b == nil {
+			b = &Board{Name: c.ref, FirstPin: 1}
+			pins := slices.Clone(nl.libparts[c.part])
+			// A netlist may not list a part's pins, or not all of them;
+			// the nets still say which pins there are.
+			for _, n := range nl.nets {
+				for _, nd := range n.nodes {
+					if nd.ref == c.ref && !slices.ContainsFunc(pins, func(p kicadPin) bool { return p.num == nd.pin }) {
+						pins = append(pins, kicadPin{nd.pin, nd.function, nd.typ})
+					}
+				}
+			}
+			slices.SortFunc(pins, func(a, b kicadPin) int { return cmp.Compare(a.num, b.num) })
+			b.Left, b.Labels, b.Types = pinColumn(pins)
+		}
+		if c.value != "" {
+			b.Title = c.value
+		}
+		byNum[c.ref] = map[int]string{}
+		for _, p := range b.kicadPins() {
+			byNum[c.ref][p.num] = p.name
+		}
+		imp.add(b)
+	}
+
+	// The hub of a net is the board on the most nets, which is the
+	// microcontroller, rather than the board with the most pins on this
+	// one, which for a display's supply and backlight is the display.
+	nets := map[string]int{}
+	for _, n := range nl.nets {
+		seen := map[string]bool{}
+		for _, nd := range n.nodes {
+			if !seen[nd.ref] {
+				seen[nd.ref] = true
+				nets[nd.ref]++
+			}
+		}
+	}
+	for _, n := range nl.nets {
+		var refs []string
+		for _, nd := range n.nodes {
+			b := imp.s.board(nd.ref)
+			if b == nil {
+				return nil, fmt.Errorf("%s: net %s: no component %s", name, n.name, nd.ref)
+			}
+			pin := byNum[nd.ref][nd.pin]
+			if nd.function != "" && b.hasPin(nd.function) {
+				pin = nd.function
+			}
+			if pin == "" {
+				return nil, fmt.Errorf("%s: net %s: %s has no pin %d", name, n.name, nd.ref, nd.pin)
+			}
+			refs = append(refs, nd.ref+"."+pin)
+		}
+		imp.star(kicadNetName(n.name), refs, nets)
+	}
+	imp.arrange()
+	return imp.s, nil
+}
+
+// pinColumn is a board's pins from a netlist's: a Gap for each number missing,
+// so the pins keep their numbers, and a pin that shares its name with another,
+// as a part's grounds often do, named for its number and labelled with the
+// name. The pins keep their types.
+func pinColumn(pins []kicadPin) (col []string, labels map[string]string, types map[string]PinType) {
+	count := map[string]int{}
+	for _, p := range pins {
+		count[p.name]++
+	}
+	next := 1
+	for _, p := range pins {
+		for ; next < p.num; next++ {
+			col = append(col, Gap)
+		}
+		next = p.num + 1
+		pin := p.name
+		if pin == "" || pin == "~" || count[p.name] > 1 || strings.Contains(pin, ".") {
+			pin = strings.ReplaceAll(cmp.Or(strings.Trim(p.name, "~"), "P"), ".", "_") + strconv.Itoa(p.num)
+			if labels == nil {
+				labels = map[string]string{}
+			}
+			labels[pin] = cmp.Or(strings.Trim(p.name, "~"), strconv.Itoa(p.num))
+		}
+		if p.typ != Untyped {
+			if types == nil {
+				types = map[string]PinType{}
+			}
+			types[pin] = p.typ
+		}
+		col = append(col, pin)
+	}
+	return col, labels, types
+}
+
+var kicadSuffix = regexp.MustCompile(`-\([^()]*-Pad[^()]*\)$`)
+
+// kicadNetName is the net a KiCad net name stands for: without the sheet path
+// of a local label or the suffix that made it unique, and nothing at all for a
+// net KiCad named only for its pads.
+func kicadNetName(n string) string {
+	n = kicadSuffix.ReplaceAllString(n, "")
+	if i := strings.LastIndexByte(n, '/'); i >= 0 {
+		n = n[i+1:]
+	}
+	if n == "Net" {
+		return ""
+	}
+	return n
+}
+
+// ImportCSV builds a schematic from a table of wires, one to a row: the pin it
+// is from, the pin it goes to, both as board.pin, and optionally its net. A
+// first row of from, to and net is a header, and is skipped. A table with no
+// wires in it is an error, not an empty drawing.
+//
+//	from,to,net
+//	pico.GP4,rtc.SDA,I2C
+//
+// A board is the library's part if parts names one for it, or if its name is
+// a part's; otherwise its pins are the ones the table uses, in the order it
+// first uses them, in one column. The boards are arranged as ImportKiCad
+// arranges them.
+func ImportCSV(name string, data []byte, lib Library, parts map[string]string) (*Schematic, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+	r.Comment = '#'
+
+	imp := newImporter(lib)
+	var errs []error
+	for first := true; ; first = false {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		// FieldPos is only for a row Read returned; a row it could
+		// not read says where it went wrong itself.
+		var pe *csv.ParseError
+		if errors.As(err, &pe) {
+			return nil, fmt.Errorf("%s:%d: %w", name, pe.Line, pe.Err)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		line, _ := r.FieldPos(0)
+		if first && len(row) > 0 && strings.EqualFold(strings.TrimSpace(row[0]), "from") {
+			continue
+		}
+		if len(row) < 2 {
+			errs = append(errs, fmt.Errorf("%s:%d: a wire is from,to or from,to,net", name, line))
+			continue
+		}
+		w := Wire{From: strings.TrimSpace(row[0]), To: strings.TrimSpace(row[1])}
+		if len(row) > 2 {
+			w.Net = strings.TrimSpace(row[2])
+		}
+		for _, ref := range []string{w.From, w.To} {
+			bn, pin, err := splitRef(ref)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s:%d: %w", name, line, err))
+				continue
+			}
+			b := imp.s.board(bn)
+			if b == nil {
+				part, explicit := parts[bn]
+				if !explicit {
+					part = bn
+				}
+				// A part that is not found is reported once, at the
+				// first row the board is on, and the board made of the
+				// pins the table uses, so the rows after it are checked.
+				if b, err = imp.fromLibrary(part, bn, explicit); err != nil {
+					errs = append(errs, fmt.Errorf("%s:%d: %w", name, line, err))
+				}
+				if b == nil {
+					b = &Board{Name: bn}
+				}
+				imp.add(b)
+			}
+			if !b.hasPin(pin) {
+				if b.Part != "" {
+					errs = append(errs, fmt.Errorf("%s:%d: a %s has no pin %q", name, line, b.Part, pin))
+					continue
+				}
+				b.Left = append(b.Left, pin)
+			}
+		}
+		imp.s.Wires = append(imp.s.Wires, w)
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	if len(imp.s.Wires) == 0 {
+		return nil, fmt.Errorf("%s: no wires; a wire is a row of from,to or from,to,net", name)
+	}
+	imp.arrange()
+	return imp.s, nil
+}
+
+// importer is a schematic being built from a netlist or a table.
+type importer struct {
+	s   *Schematic
+	lib Library
+}
+
+func newImporter(lib Library) *importer {
+	return &importer{s: &Schematic{}, lib: lib}
+}
+
+func (imp *importer) add(b *Board) { imp.s.Boards = append(imp.s.Boards, b) }
+
+// fromLibrary is the board the library makes of the part, or nil if there is
+// no library or, unless the part was asked for by name, the library does not
+// have it.
+func (imp *importer) fromLibrary(part, name string, explicit bool) (*Board, error) {
+	if imp.lib == nil || part == "" {
+		if explicit {
+			return nil, fmt.Errorf("%s: no part library to find %s in", name, part)
+		}
+		return nil, nil
+	}
+	b, err := imp.lib(part, name)
+	if err != nil {
+		if explicit {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		return nil, nil
+	}
+	return b, nil
+}
+
+// star wires the pins of one net from the board ranked highest, the first
+// of them on a tie. A hub with several pins on the net that are one rail, as
+// the Pico's grounds are, shares the wires out among them, one at a time, so
+// no one pin carries them all; any other pins of the hub are wired from its
+// first.
+func (imp *importer) star(net string, refs []string, rank map[string]int) {
+	if len(refs) < 2 {
+		return
+	}
+	onBoard := map[string][]string{}
+	var boards []string
+	for _, ref := range refs {
+		bn, _, _ := splitRef(ref) //nolint:errcheck // built as board.pin
+		if onBoard[bn] == nil {
+			boards = append(boards, bn)
+		}
+		onBoard[bn] = append(onBoard[bn], ref)
+	}
+	hub := boards[0]
+	for _, bn := range boards[1:] {
+		if rank[bn] > rank[hub] {
+			hub = bn
+		}
+	}
+
+	hubs := onBoard[hub][:1]
+	b := imp.s.board(hub)
+	_, first, _ := splitRef(hubs[0]) //nolint:errcheck // as above
+	for _, ref := range onBoard[hub][1:] {
+		_, pin, _ := splitRef(ref) //nolint:errcheck // as above
+		if r := rail(b.label(pin)); r != "" && r == rail(b.label(first)) {
+			hubs = append(hubs, ref)
+		} else {
+			imp.s.Wires = append(imp.s.Wires, Wire{From: hubs[0], To: ref, Net: net})
+		}
+	}
+	i := 0
+	for _, bn := range boards {
+		if bn == hub {
+			continue
+		}
+		for _, ref := range onBoard[bn] {
+			imp.s.Wires = append(imp.s.Wires, Wire{From: hubs[i%len(hubs)], To: ref, Net: net})
+			i++
+		}
+	}
+}
+
+// rail is the supply or ground a pin's label names, with any number that tells
+// it from the part's other pins of the same rail taken off: GND for GND3. A
+// rail that ends in a digit of its own, 3V3, is kept whole. It is "" for a
+// signal.
+func rail(label string) string {
+	for _, r := range []string{label, strings.TrimRight(label, "0123456789")} {
+		if isRail(r) {
+			return strings.ToUpper(r)
+		}
+	}
+	return ""
+}
+
+// arrange puts the board with the most wires in the middle and every other
+// board on the side of it most of its wires go to, facing it, so that the
+// wires run across rather than around. Boards with no wires to the middle one
+// go on its right.
+func (imp *importer) arrange() {
+	s := imp.s
+	if len(s.Boards) < 2 {
+		return
+	}
+	wires := map[string]int{}
+	for _, w := range s.Wires {
+		for _, ref := range []string{w.From, w.To} {
+			bn, _, _ := splitRef(ref) //nolint:errcheck // checked when the wire was made
+			wires[bn]++
+		}
+	}
+	hub := s.Boards[0]
+	for _, b := range s.Boards[1:] {
+		if wires[b.Name] > wires[hub.Name] {
+			hub = b
+		}
+	}
+	hub.Col = 1
+	for _, b := range s.Boards {
+		if b == hub {
+			continue
+		}
+		left, right := 0, 0
+		for _, w := range s.Wires {
+			for _, pair := range [][2]string{{w.From, w.To}, {w.To, w.From}} {
+				hb, pin, _ := splitRef(pair[0]) //nolint:errcheck // as above
+				ob, _, _ := splitRef(pair[1])   //nolint:errcheck // as above
+				if hb != hub.Name || ob != b.Name {
+					continue
+				}
+				if slices.Contains(hub.Left, pin) {
+					left++
+				} else {
+					right++
+				}
+			}
+		}
+		b.Col = 2
+		if left > right {
+			b.Col = 0
+		}
+		// A board with pins down one side turns them to face the hub.
+		switch {
+		case b.Col == 0 && len(b.Right) == 0:
+			b.Left, b.Right = nil, b.Left
+		case b.Col == 2 && len(b.Left) == 0:
+			b.Left, b.Right = b.Right, nil
+		}
+	}
+}
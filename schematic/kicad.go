@@ -0,0 +1,397 @@
+package schematic
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// KiCadNetlist writes the schematic as a KiCad netlist (.net), which Pcbnew
+// reads with File > Import > Netlist, so a prototype on jumper wires becomes a
+// board layout without its connections being entered a second time.
+//
+// Each board is a component whose reference is the board's name, and each pin
+// is numbered as the drawing numbers it — down the left and back up the right
+// from FirstPin, or from 1 for an unnumbered board. The wires are merged into
+// nets: every pin a chain of wires reaches is one net, and so are the pins of a
+// board on the same rail, since the eight GNDs on a Pico are one ground
+// however many wires go to them. A net takes the name of its wires' Net, made
+// unique where several nets share one, as KiCad names them: DAT-(lcd-Pad7).
+//
+// The footprints are left to KiCad's footprint assignment, which knows the
+// footprint libraries; the component timestamps are derived from the board
+// names, so importing the netlist again updates the layout rather than adding
+// a second copy of every part.
+func (s *Schematic) KiCadNetlist() (string, error) {
+	nets, err := s.kicadNets()
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	b.WriteString("(export (version \"E\")\n")
+	b.WriteString("  (design\n    (tool \"mcu schematic\"))\n")
+
+	b.WriteString("  (components")
+	for _, bd := range s.Boards {
+		fmt.Fprintf(&b, "\n    (comp (ref %s)\n      (value %s)\n      (libsource (lib \"mcu\") (part %s) (description \"\"))\n      (sheetpath (names \"/\") (tstamps \"/\"))\n      (tstamps %s))",
+			kicadQuote(bd.Name), kicadQuote(bd.title()), kicadQuote(s.libpart(bd)), kicadQuote(kicadUUID(bd.Name)))
+	}
+	b.WriteString(")\n")
+
+	b.WriteString("  (libparts")
+	done := map[string]bool{}
+	for _, bd := range s.Boards {
+		part := s.libpart(bd)
+		if done[part] {
+			continue
+		}
+		done[part] = true
+		fmt.Fprintf(&b, "\n    (libpart (lib \"mcu\") (part %s)\n      (pins", kicadQuote(part))
+		for _, p := range bd.kicadPins() {
+			fmt.Fprintf(&b, "\n        (pin (num %s) (name %s) (type %s))", kicadQuote(strconv.Itoa(p.num)), kicadQuote(p.name), kicadQuote(kicadPinType(p.typ)))
+		}
+		b.WriteString("))")
+	}
+	b.WriteString(")\n")
+
+	b.WriteString("  (nets")
+	for i, n := range nets {
+		fmt.Fprintf(&b, "\n    (net (code %s) (name %s)", kicadQuote(strconv.Itoa(i+1)), kicadQuote(n.name))
+		for _, nd := range n.nodes {
+			fmt.Fprintf(&b, "\n      (node (ref %s) (pin %s) (pinfunction %s) (pintype %s))",
+				kicadQuote(nd.ref), kicadQuote(strconv.Itoa(nd.pin)), kicadQuote(nd.function), kicadQuote(kicadPinType(nd.typ)))
+		}
+		b.WriteString(")")
+	}
+	b.WriteString("))\n")
+	return b.String(), nil
+}
+
+// kicadPin is a pin with the number a netlist gives it.
+type kicadPin struct {
+	num  int
+	name string
+	typ  PinType
+}
+
+// kicadPinType is the electrical type KiCad has for t. KiCad's ERC treats an
+// untyped pin as passive, which is what it is written as, so that a netlist
+// of untyped boards raises nothing there either.
+func kicadPinType(t PinType) string {
+	switch t {
+	case Untyped:
+		return "passive"
+	case OpenDrain:
+		return "open_collector"
+	}
+	return string(t)
+}
+
+// pinTypeOf is the pin type for one of KiCad's: the reverse of kicadPinType,
+// with a passive pin, KiCad's default for a connector, and the types this
+// package does not have taken as Untyped.
+func pinTypeOf(kicad string) PinType {
+	switch kicad {
+	case "open_collector", "open_emitter":
+		return OpenDrain
+	case "tri_state":
+		return Bidirectional
+	case "passive":
+		return Untyped
+	}
+	if t := PinType(kicad); slices.Contains(PinTypes, t) {
+		return t
+	}
+	return Untyped
+}
+
+// kicadPins numbers the board's pins as pinNumber does, treating an
+// unnumbered board as starting from 1, in the order of their numbers.
+func (b *Board) kicadPins() []kicadPin {
+	n := *b
+	if n.FirstPin == 0 {
+		n.FirstPin = 1
+	}
+	var pins []kicadPin
+	for _, side := range []Side{Left, Right} {
+		col := b.Left
+		if side == Right {
+			col = b.Right
+		}
+		for i, p := range col {
+			if p != Gap {
+				pins = append(pins, kicadPin{n.pinNumber(side, i), p, b.pinType(p)})
+			}
+		}
+	}
+	slices.SortFunc(pins, func(a, b kicadPin) int { return cmp.Compare(a.num, b.num) })
+	return pins
+}
+
+// libpart is the library part a board is an instance of: its Part, unless
+// another board of the same Part has other pins, or it has none.
+func (s *Schematic) libpart(b *Board) string {
+	if b.Part == "" {
+		return b.Name
+	}
+	for _, o := range s.Boards {
+		if o == b {
+			break
+		}
+		if o.Part == b.Part && !slices.Equal(o.kicadPins(), b.kicadPins()) {
+			return b.Name
+		}
+	}
+	return b.Part
+}
+
+type kicadNet struct {
+	name  string
+	nodes []kicadNode
+}
+
+type kicadNode struct {
+	ref      string
+	pin      int
+	function string // the pin's name
+	typ      PinType
+}
+
+// kicadNets merges the wires into nets, in order of their names.
+func (s *Schematic) kicadNets() ([]kicadNet, error) {
+	groups, err := s.connect()
+	if err != nil {
+		return nil, err
+	}
+
+	// Name each net for the Net most of its wires are on, and count how
+	// many nets want each name.
+	var nets []kicadNet
+	wants := map[string]int{}
+	for _, g := range groups {
+		name, most := "", 0
+		for n, c := range g.nets {
+			if c > most || c == most && n < name {
+				name, most = n, c
+			}
+		}
+		wants[name]++
+		n := kicadNet{name: name}
+		for _, p := range g.pins {
+			n.nodes = append(n.nodes, kicadNode{p.board.Name, p.num, p.name, p.board.pinType(p.name)})
+		}
+		nets = append(nets, n)
+	}
+	for i, n := range nets {
+		if n.name == "" || wants[n.name] > 1 {
+			first := n.nodes[0]
+			nets[i].name = fmt.Sprintf("%s-(%s-Pad%d)", cmp.Or(n.name, "Net"), first.ref, first.pin)
+		}
+	}
+	slices.SortFunc(nets, func(a, b kicadNet) int { return cmp.Compare(a.name, b.name) })
+	return nets, nil
+}
+
+// kicadQuote writes s as a KiCad string, which escapes only the quote, the
+// backslash and the line breaks.
+func kicadQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`).Replace(s) + `"`
+}
+
+// kicadUUID is a UUID that is always the same for the same board name, in the
+// form KiCad writes its timestamps.
+func kicadUUID(name string) string {
+	h := sha256.Sum256([]byte("mcu schematic/" + name))
+	h[6] = h[6]&0x0f | 0x50 // version 5: name-based
+	h[8] = h[8]&0x3f | 0x80 // the RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", h[0:4], h[4:6], h[6:8], h[8:10], h[10:16])
+}
+
+// sexpr is an s-expression as KiCad writes them: an atom, or a list whose
+// first element is usually the atom naming it.
+type sexpr struct {
+	atom string
+	list []*sexpr
+	leaf bool
+}
+
+// head is the name of a list: its first atom.
+func (e *sexpr) head() string {
+	if e.leaf || len(e.list) == 0 || !e.list[0].leaf {
+		return ""
+	}
+	return e.list[0].atom
+}
+
+// all is every sublist of e named head.
+func (e *sexpr) all(head string) []*sexpr {
+	var found []*sexpr
+	for _, c := range e.list {
+		if c.head() == head {
+			found = append(found, c)
+		}
+	}
+	return found
+}
+
+// first is the first sublist of e named head, or an empty list.
+func (e *sexpr) first(head string) *sexpr {
+	if found := e.all(head); len(found) > 0 {
+		return found[0]
+	}
+	return &sexpr{}
+}
+
+// value is the atom in e's sublist (head value), or "".
+func (e *sexpr) value(head string) string {
+	l := e.first(head).list
+	if len(l) < 2 || !l[1].leaf {
+		return ""
+	}
+	return l[1].atom
+}
+
+// parseSexpr reads one s-expression, and nothing after it but space.
+func parseSexpr(data string) (*sexpr, error) {
+	p := &sexprParser{s: data, line: 1}
+	e, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	p.space()
+	if p.i < len(p.s) {
+		return nil, fmt.Errorf("line %d: %q after the end", p.line, p.s[p.i:min(p.i+10, len(p.s))])
+	}
+	return e, nil
+}
+
+type sexprParser struct {
+	s    string
+	i    int
+	line int
+}
+
+func (p *sexprParser) space() {
+	for p.i < len(p.s) && strings.IndexByte(" \t\r\n", p.s[p.i]) >= 0 {
+		if p.s[p.i] == '\n' {
+			p.line++
+		}
+		p.i++
+	}
+}
+
+func (p *sexprParser) next() (*sexpr, error) {
+	p.space()
+	if p.i >= len(p.s) {
+		return nil, fmt.Errorf("line %d: unexpected end", p.line)
+	}
+	switch p.s[p.i] {
+	case '(':
+		p.i++
+		e := &sexpr{}
+		for {
+			p.space()
+			if p.i >= len(p.s) {
+				return nil, fmt.Errorf("line %d: a list is not closed", p.line)
+			}
+			if p.s[p.i] == ')' {
+				p.i++
+				return e, nil
+			}
+			c, err := p.next()
+			if err != nil {
+				return nil, err
+			}
+			e.list = append(e.list, c)
+		}
+	case ')':
+		return nil, fmt.Errorf("line %d: unexpected )", p.line)
+	case '"':
+		var b strings.Builder
+		for p.i++; p.i < len(p.s); p.i++ {
+			switch c := p.s[p.i]; c {
+			case '"':
+				p.i++
+				return &sexpr{atom: b.String(), leaf: true}, nil
+			case '\\':
+				if p.i++; p.i < len(p.s) {
+					switch p.s[p.i] {
+					case 'n':
+						b.WriteByte('\n')
+					case 'r':
+						b.WriteByte('\r')
+					default:
+						b.WriteByte(p.s[p.i])
+					}
+				}
+			default:
+				if c == '\n' {
+					p.line++
+				}
+				b.WriteByte(c)
+			}
+		}
+		return nil, fmt.Errorf("line %d: a string is not closed", p.line)
+	default:
+		start := p.i
+		for p.i < len(p.s) && strings.IndexByte(" \t\r\n()\"", p.s[p.i]) < 0 {
+			p.i++
+		}
+		return &sexpr{atom: p.s[start:p.i], leaf: true}, nil
+	}
+}
+
+// kicadNetlist is what a KiCad netlist says: the components, the pins of
+// their library parts, and the nets joining them.
+type kicadNetlist struct {
+	comps    []kicadComp
+	libparts map[string][]kicadPin // by part
+	nets     []kicadNet
+}
+
+type kicadComp struct {
+	ref, value, part string
+}
+
+// parseKiCadNetlist reads a netlist in the form KiCadNetlist writes it, which
+// is the form KiCad 6 and later write it too.
+func parseKiCadNetlist(data string) (*kicadNetlist, error) {
+	e, err := parseSexpr(data)
+	if err != nil {
+		return nil, err
+	}
+	if e.head() != "export" {
+		return nil, fmt.Errorf("not a KiCad netlist: it starts with (%s", e.head())
+	}
+	nl := &kicadNetlist{libparts: map[string][]kicadPin{}}
+	for _, c := range e.first("components").all("comp") {
+		nl.comps = append(nl.comps, kicadComp{ref: c.value("ref"), value: c.value("value"), part: c.first("libsource").value("part")})
+	}
+	for _, lp := range e.first("libparts").all("libpart") {
+		var pins []kicadPin
+		for _, p := range lp.first("pins").all("pin") {
+			num, err := strconv.Atoi(p.value("num"))
+			if err != nil {
+				return nil, fmt.Errorf("part %s: pin %q is not a number", lp.value("part"), p.value("num"))
+			}
+			pins = append(pins, kicadPin{num, p.value("name"), pinTypeOf(p.value("type"))})
+		}
+		nl.libparts[lp.value("part")] = pins
+	}
+	for _, n := range e.first("nets").all("net") {
+		net := kicadNet{name: n.value("name")}
+		for _, nd := range n.all("node") {
+			num, err := strconv.Atoi(nd.value("pin"))
+			if err != nil {
+				return nil, fmt.Errorf("net %s: pin %q is not a number", net.name, nd.value("pin"))
+			}
+			net.nodes = append(net.nodes, kicadNode{nd.value("ref"), num, nd.value("pinfunction"), pinTypeOf(nd.value("pintype"))})
+		}
+		nl.nets = append(nl.nets, net)
+	}
+	return nl, nil
+}
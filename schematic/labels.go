@@ -0,0 +1,443 @@
+package schematic
+
+import (
+	"fmt"
+	"image/color"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// NetLabels chooses wires to draw as net labels rather than as wires: a short
+// stub from each pin, ending in the name of the net, as a schematic editor
+// draws a connection too far or too tangled to run a line for. Two pins with
+// the same label are connected.
+//
+// With two displays the drawing has over forty wires, and most of the canvas
+// is lanes. Ground and supply are the worst of it — every part has them, and
+// their wires cross everything else on the way — and a symbol at each pin says
+// as much as the wire did. A net on Nets is drawn as labels throughout, a
+// ground net with a ground symbol and a supply with a supply symbol; Length
+// and Crossings catch the wires that are costly to draw whatever their net.
+// Zero leaves that test off.
+type NetLabels struct {
+	Nets      []string `json:"nets,omitempty" yaml:"nets,omitempty" toml:"nets,omitempty"`               // every wire on these nets, e.g. GND and PWR
+	Length    int      `json:"length,omitempty" yaml:"length,omitempty" toml:"length,omitzero"`          // every wire longer than this, in cells of its path
+	Crossings int      `json:"crossings,omitempty" yaml:"crossings,omitempty" toml:"crossings,omitzero"` // every wire crossed by more than this many others
+}
+
+// labelKind is the symbol a label is drawn with.
+type labelKind int
+
+const (
+	signalLabel labelKind = iota
+	groundLabel
+	supplyLabel
+)
+
+// netLabel is one label: the pin it hangs off and the name it carries.
+type netLabel struct {
+	pin  PinPos
+	name string
+	kind labelKind
+	wire Wire // the first labelled wire at the pin, for its color
+}
+
+// labelStub is the cells of wire between a pin and its label's symbol.
+const labelStub = 2
+
+// labelWidth is the cells a label takes beside its pin: the stub, the symbol,
+// a space, and the name.
+func labelWidth(name string) int { return labelStub + 2 + utf8.RuneCountInString(name) }
+
+// extent is the x of the label's far end: the last cell it takes to the right
+// of a right-hand pin, or the first to the left of a left-hand one.
+func (l netLabel) extent() int {
+	if l.pin.Side == Right {
+		return l.pin.X + labelWidth(l.name)
+	}
+	return l.pin.X - labelWidth(l.name)
+}
+
+// withLabels is s as it is laid out and routed when NetLabels takes wires out
+// of it: s without them, with room for their labels beside the boards, and the
+// labels to draw. Without NetLabels, or with no wire chosen, it is s.
+func (s *Schematic) withLabels() (*Schematic, []Wire, error) {
+	if s.NetLabels == nil {
+		return s, nil, nil
+	}
+	chosen, err := s.labelled()
+	if err != nil || !slices.Contains(chosen, true) {
+		return s, nil, err
+	}
+	d := *s
+	d.Wires = nil
+	var labelled []Wire
+	for i, w := range s.Wires {
+		if chosen[i] {
+			labelled = append(labelled, w)
+		} else {
+			d.Wires = append(d.Wires, w)
+		}
+	}
+	names, err := s.labelNames(chosen)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Every label fits between its board and the first lane, whichever
+	// side of the gutter it is on; the boards in the first column move
+	// right if one of their left-hand pins is labelled.
+	first := 0
+	for i, b := range s.Boards {
+		if i == 0 || b.Col < first {
+			first = b.Col
+		}
+	}
+	for i, w := range s.Wires {
+		if !chosen[i] {
+			continue
+		}
+		d.labelRoom = max(d.labelRoom, labelWidth(names[i].name))
+		for _, ref := range []string{w.From, w.To} {
+			bn, pin, _ := splitRef(ref) //nolint:errcheck // Validate has passed
+			if b := s.board(bn); b.Col == first && slices.Contains(b.Left, pin) {
+				d.labelLeft = true
+			}
+		}
+	}
+	return &d, labelled, nil
+}
+
+// labelled is which of the wires NetLabels chooses, by index. The length and
+// crossing tests are made on the drawing with every wire in it. A pin is
+// either wired or labelled, so a wire sharing a pin with a labelled one is
+// labelled too, or its line would run through the label.
+func (s *Schematic) labelled() ([]bool, error) {
+	nl := s.NetLabels
+	chosen := make([]bool, len(s.Wires))
+	for i, w := range s.Wires {
+		chosen[i] = slices.Contains(nl.Nets, w.Net)
+	}
+	if nl.Length > 0 || nl.Crossings > 0 {
+		pos, err := s.Layout()
+		if err != nil {
+			return nil, err
+		}
+		rs, err := s.routes(pos)
+		if err != nil {
+			return nil, err
+		}
+		cross := crossings(rs)
+		for j, r := range rs {
+			if nl.Length > 0 && pathLength(r.pts) > nl.Length || nl.Crossings > 0 && cross[j] > nl.Crossings {
+				chosen[r.n] = true
+			}
+		}
+	}
+	for changed := true; changed; {
+		changed = false
+		pins := map[string]bool{}
+		for i, w := range s.Wires {
+			if chosen[i] {
+				pins[w.From], pins[w.To] = true, true
+			}
+		}
+		for i, w := range s.Wires {
+			if !chosen[i] && (pins[w.From] || pins[w.To]) {
+				chosen[i], changed = true, true
+			}
+		}
+	}
+	return chosen, nil
+}
+
+// labelNames names each chosen wire's net, and says which symbol it takes. A
+// label says what it is connected to, and two labels alike are connected, so
+// a name is given to a whole net rather than to a wire: the Name of a wire on
+// it; else its Net, if that Net is this one net and no other; else the rail a
+// supply or ground is, VBUS or GND; else the board and pin at the far end of
+// its first wire, LCD_BIT0.
+func (s *Schematic) labelNames(chosen []bool) (map[int]netLabel, error) {
+	groups, err := s.connect()
+	if err != nil {
+		return nil, err
+	}
+	groupOf := map[string]int{}
+	for gi, g := range groups {
+		for _, p := range g.pins {
+			groupOf[p.ref()] = gi
+		}
+	}
+	netGroups := map[string]map[int]bool{}
+	for _, w := range s.Wires {
+		if netGroups[w.Net] == nil {
+			netGroups[w.Net] = map[int]bool{}
+		}
+		netGroups[w.Net][groupOf[w.From]] = true
+	}
+
+	byGroup := map[int]netLabel{}
+	for gi, g := range groups {
+		var l netLabel
+		var first *Wire
+		for i, w := range s.Wires {
+			if groupOf[w.From] != gi {
+				continue
+			}
+			if first == nil {
+				first = &s.Wires[i]
+			}
+			if l.name == "" && w.Name != "" {
+				l.name = w.Name
+			}
+		}
+		if l.name == "" && first.Net != "" && len(netGroups[first.Net]) == 1 {
+			l.name = first.Net
+		}
+		supply := ""
+		for _, p := range g.pins {
+			r := rail(p.board.label(p.name))
+			switch {
+			case r == "GND" || r == "VSS":
+				l.kind = groundLabel
+			case r != "" && !strings.HasPrefix(r, "LED") && r != "AREF" && l.kind == signalLabel:
+				l.kind = supplyLabel
+			}
+			// The source's name for the rail is the rail's name, and
+			// failing a typed source, the first wire's.
+			if r != "" && (supply == "" || p.board.pinType(p.name) == PowerOut) {
+				supply = r
+			}
+		}
+		if l.kind == groundLabel {
+			supply = "GND"
+		}
+		if l.name == "" && l.kind != signalLabel {
+			l.name = supply
+		}
+		if l.name == "" {
+			bn, pin, _ := splitRef(first.To) //nolint:errcheck // Validate has passed
+			l.name = strings.ToUpper(bn) + "_" + s.board(bn).label(pin)
+		}
+		byGroup[gi] = l
+	}
+
+	names := map[int]netLabel{}
+	for i, w := range s.Wires {
+		if chosen[i] {
+			l := byGroup[groupOf[w.From]]
+			l.wire = w
+			names[i] = l
+		}
+	}
+	return names, nil
+}
+
+// netLabels places a label at each end of each labelled wire, once per pin.
+func (s *Schematic) netLabels(pos map[string]PinPos, labelled []Wire) ([]netLabel, error) {
+	if len(labelled) == 0 {
+		return nil, nil
+	}
+	chosen := make([]bool, len(s.Wires))
+	for i, w := range s.Wires {
+		chosen[i] = slices.Contains(labelled, w)
+	}
+	names, err := s.labelNames(chosen)
+	if err != nil {
+		return nil, err
+	}
+	var ls []netLabel
+	seen := map[string]bool{}
+	for i, w := range s.Wires {
+		if !chosen[i] {
+			continue
+		}
+		for _, ref := range []string{w.From, w.To} {
+			if seen[ref] {
+				continue
+			}
+			seen[ref] = true
+			p, ok := pos[ref]
+			if !ok {
+				return nil, fmt.Errorf("unplaced pin %q", ref)
+			}
+			l := names[i]
+			l.pin = p
+			ls = append(ls, l)
+		}
+	}
+	return ls, nil
+}
+
+// pathLength is the cells a path runs through, end to end.
+func pathLength(pts []point) int {
+	n := 0
+	for i := 1; i < len(pts); i++ {
+		lo, hi := minMax(pts[i-1].X, pts[i].X)
+		n += hi - lo
+		lo, hi = minMax(pts[i-1].Y, pts[i].Y)
+		n += hi - lo
+	}
+	return n
+}
+
+// crossings counts, for each route, the times another route crosses it: a
+// horizontal run of one passing through a vertical run of the other, away from
+// the ends of both. Runs that overlap along their length share lanes only by
+// mistake, and are not counted.
+func crossings(rs []route) []int {
+	n := make([]int, len(rs))
+	for i := range rs {
+		for j := i + 1; j < len(rs); j++ {
+			c := segmentCrossings(rs[i].pts, rs[j].pts) + segmentCrossings(rs[j].pts, rs[i].pts)
+			n[i] += c
+			n[j] += c
+		}
+	}
+	return n
+}
+
+// segmentCrossings counts where a horizontal run of a crosses a vertical run
+// of b.
+func segmentCrossings(a, b []point) int {
+	n := 0
+	for i := 1; i < len(a); i++ {
+		h0, h1 := a[i-1], a[i]
+		if h0.Y != h1.Y {
+			continue
+		}
+		xlo, xhi := minMax(h0.X, h1.X)
+		for j := 1; j < len(b); j++ {
+			v0, v1 := b[j-1], b[j]
+			if v0.X != v1.X {
+				continue
+			}
+			ylo, yhi := minMax(v0.Y, v1.Y)
+			if xlo < v0.X && v0.X < xhi && ylo < h0.Y && h0.Y < yhi {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// overlaps reports whether a and b run along the same line for a cell or more
+// anywhere, where a crossing would only meet.
+func overlaps(a, b []point) bool {
+	for i := 1; i < len(a); i++ {
+		for j := 1; j < len(b); j++ {
+			a0, a1, b0, b1 := a[i-1], a[i], b[j-1], b[j]
+			switch {
+			case a0.X == a1.X && b0.X == b1.X && a0.X == b0.X:
+				alo, ahi := minMax(a0.Y, a1.Y)
+				blo, bhi := minMax(b0.Y, b1.Y)
+				if alo <= bhi && blo <= ahi {
+					return true
+				}
+			case a0.Y == a1.Y && b0.Y == b1.Y && a0.Y == b0.Y:
+				alo, ahi := minMax(a0.X, a1.X)
+				blo, bhi := minMax(b0.X, b1.X)
+				if alo <= bhi && blo <= ahi {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// drawLabel draws a label on the text canvas: the stub, the symbol — ⊥ for a
+// ground, ⊤ for a supply, and for a signal an arrow pointing away, to where
+// the wire goes — and the name.
+func drawLabel(c *canvas, l netLabel, col string) {
+	dir := 1
+	if l.pin.Side == Left {
+		dir = -1
+	}
+	for i := 1; i <= labelStub; i++ {
+		c.set(l.pin.X+dir*i, l.pin.Y, '─', col)
+	}
+	sym := map[labelKind]rune{groundLabel: '⊥', supplyLabel: '⊤', signalLabel: '▷'}[l.kind]
+	if l.kind == signalLabel && dir < 0 {
+		sym = '◁'
+	}
+	x := l.pin.X + dir*(labelStub+1)
+	c.set(x, l.pin.Y, sym, col)
+	if dir > 0 {
+		c.text(x+2, l.pin.Y, l.name, col)
+	} else {
+		c.text(x-1-utf8.RuneCountInString(l.name), l.pin.Y, l.name, col)
+	}
+}
+
+// svgLabel draws a label in the SVG: the stub as a line, a ground as three
+// bars narrowing away from it, a supply as one bar across it, and a signal as
+// a flag around its name pointing away from the pin.
+func svgLabel(b *strings.Builder, l netLabel, color string, th *Theme) {
+	dir := 1
+	if l.pin.Side == Left {
+		dir = -1
+	}
+	y := cy(l.pin.Y)
+	x0 := cx(l.pin.X) + dir*svgCellW/2
+	x1 := cx(l.pin.X + dir*labelStub)
+	fmt.Fprintf(b, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="%s" stroke-width="1.2"/>`, x0, y, x1, y, color)
+	name := utf8.RuneCountInString(l.name) * svgCellW
+	switch l.kind {
+	case groundLabel:
+		for i, half := range []int{6, 4, 2} {
+			x := x1 + dir*3*i
+			fmt.Fprintf(b, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="%s" stroke-width="1.2"/>`, x, y-half, x, y+half, color)
+		}
+	case supplyLabel:
+		fmt.Fprintf(b, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="%s" stroke-width="1.2"/>`, x1, y-6, x1, y+6, color)
+	default:
+		// A flag: square at the stub, pointed at the far end.
+		h := svgCellH/2 - 1
+		far := x1 + dir*(name+svgCellW+svgCellW/2)
+		fmt.Fprintf(b, `<polygon points="%d,%d %d,%d %d,%d %d,%d %d,%d" fill="%s" stroke="%s" stroke-width="1"/>`,
+			x1, y-h, far-dir*h, y-h, far, y, far-dir*h, y+h, x1, y+h, th.Background, color)
+	}
+	anchor := "start"
+	if dir < 0 {
+		anchor = "end"
+	}
+	fmt.Fprintf(b, `<text x="%d" y="%d" fill="%s" text-anchor="%s" dominant-baseline="middle">%s</text>`,
+		x1+dir*svgCellW, y, color, anchor, escape(l.name))
+}
+
+// pngLabel draws a label in the PNG as svgLabel draws it in the SVG.
+func pngLabel(r *raster, l netLabel, c color.RGBA, th *Theme) {
+	dir := 1
+	if l.pin.Side == Left {
+		dir = -1
+	}
+	y := cy(l.pin.Y)
+	x0 := cx(l.pin.X) + dir*svgCellW/2
+	x1 := cx(l.pin.X + dir*labelStub)
+	r.line(x0, y, x1, y, 1, c)
+	name := utf8.RuneCountInString(l.name) * svgCellW
+	switch l.kind {
+	case groundLabel:
+		for i, half := range []int{6, 4, 2} {
+			x := x1 + dir*3*i
+			r.line(x, y-half, x, y+half, 1, c)
+		}
+	case supplyLabel:
+		r.line(x1, y-6, x1, y+6, 1, c)
+	default:
+		// The flag is filled a row at a time, each row stopping where the
+		// point has narrowed to, then outlined.
+		h := svgCellH/2 - 1
+		far := x1 + dir*(name+svgCellW+svgCellW/2)
+		bg := hexColor(th.Background)
+		for dy := -h; dy <= h; dy++ {
+			r.fill(x1, y+dy, far-dir*abs(dy), y+dy, bg)
+		}
+		pts := []point{{x1, y - h}, {far - dir*h, y - h}, {far, y}, {far - dir*h, y + h}, {x1, y + h}, {x1, y - h}}
+		for i := 1; i < len(pts); i++ {
+			r.line(pts[i-1].X, pts[i-1].Y, pts[i].X, pts[i].Y, 1, c)
+		}
+	}
+	r.text(x1+dir*svgCellW, r.middle(y), l.name, c, dir < 0)
+}
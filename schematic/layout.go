@@ -2,6 +2,7 @@ package schematic
 
 import (
 	"fmt"
+	"slices"
 	"sort"
 )
 
@@ -17,6 +18,9 @@ func (s *Schematic) Layout() (map[string]PinPos, error) {
 	if gutter == 0 {
 		gutter = s.neededGutter()
 	}
+	// A label runs from its pin into the gutter, and the wires' lanes start
+	// after it.
+	gutter += 2 * s.labelRoom
 
 	// Group boards into columns. Boards sharing a Col stack vertically; the
 	// column is as wide as its widest board. Declaration order decides both
@@ -42,6 +46,9 @@ func (s *Schematic) Layout() (map[string]PinPos, error) {
 
 	pos := map[string]PinPos{}
 	x := 0
+	if s.labelLeft {
+		x = s.labelRoom
+	}
 	for _, c := range cols {
 		for _, b := range c.boards {
 			w, _ := b.boxSize()
@@ -85,6 +92,58 @@ func (s *Schematic) Layout() (map[string]PinPos, error) {
 	return pos, nil
 }
 
+// drawing is a schematic laid out and routed, as the renderers draw it: the
+// wires' routes, the net labels in place of the wires NetLabels takes out, and
+// the cells it all covers.
+type drawing struct {
+	rs     []route
+	labels []netLabel
+	w, h   int
+}
+
+// draw lays s out and routes it.
+func (s *Schematic) draw() (*drawing, error) {
+	d, labelled, err := s.withLabels()
+	if err != nil {
+		return nil, err
+	}
+	pos, err := d.Layout()
+	if err != nil {
+		return nil, err
+	}
+	rs, err := d.routes(pos)
+	if err != nil {
+		return nil, err
+	}
+	labels, err := s.netLabels(pos, labelled)
+	if err != nil {
+		return nil, err
+	}
+
+	dr := &drawing{rs: rs, labels: labels}
+	for _, b := range s.Boards {
+		dr.w = max(dr.w, b.X+b.W)
+		dr.h = max(dr.h, b.Y+b.H)
+	}
+	// Size to what the routing actually used, not to the worst case: a
+	// single detour must not leave a page of blank rows under the drawing.
+	//
+	// Both axes, not just the height. A wire that wraps around the right-hand
+	// board runs past the rightmost box, and the canvas silently discards
+	// out-of-bounds writes — so sizing from the boards alone truncates that
+	// wire into a drawing that still looks finished.
+	for _, r := range rs {
+		for _, p := range r.pts {
+			dr.w = max(dr.w, p.X+1)
+			dr.h = max(dr.h, p.Y+1)
+		}
+	}
+	for _, l := range labels {
+		dr.w = max(dr.w, l.extent()+1)
+	}
+	return dr, nil
+}
+
 // placePins records where every pin of a placed board landed.
 func placePins(pos map[string]PinPos, b *Board) {
 	{
@@ -153,11 +212,13 @@ type point struct{ X, Y int }
 // routed around the outside instead of straight through the board art.
 type route struct {
 	wire     Wire
+	n        int // its index in Wires
 	from, to PinPos
 	pts      []point
 	lane     int // x of the main vertical segment (asciigraph uses this)
 	y0, y1   int
 	wrapped  bool
+	detoured bool // sent under the boards, by buildDetour
 }
 
 // routes assigns every wire a lane such that two wires whose vertical segments
@@ -165,7 +226,7 @@ type route struct {
 // a breakpoint per wire by hand.
 func (s *Schematic) routes(pos map[string]PinPos) ([]route, error) {
 	rs := make([]route, 0, len(s.Wires))
-	for _, w := range s.Wires {
+	for n, w := range s.Wires {
 		a, ok := pos[w.From]
 		if !ok {
 			return nil, fmt.Errorf("unplaced pin %q", w.From)
@@ -178,7 +239,7 @@ func (s *Schematic) routes(pos map[string]PinPos) ([]route, error) {
 		if a.X > b.X {
 			a, b = b, a
 		}
-		rs = append(rs, route{wire: w, from: a, to: b, y0: a.Y, y1: b.Y})
+		rs = append(rs, route{wire: w, n: n, from: a, to: b, y0: a.Y, y1: b.Y})
 	}
 
 	// Assign lanes in source order. A bundle running from a block of
@@ -218,13 +279,30 @@ func (s *Schematic) routes(pos map[string]PinPos) ([]route, error) {
 	for i := range rs {
 		r := &rs[i]
 		lo, hi := minMax(r.y0, r.y1)
-		gutterStart := r.from.X + 2
-		gutterEnd := r.to.X - 2
+		// The gutter is between the boards, wherever on them the pins
+		// are: a wrapped wire's pin on the far edge of its board does not
+		// make the board part of it.
+		gutterStart := r.from.Board.X + r.from.Board.W + 1 + s.labelRoom
+		gutterEnd := r.to.Board.X - 2 - s.labelRoom
 		if gutterEnd < gutterStart {
 			gutterEnd = gutterStart
 		}
 
-		placed := false
+		// A pin on the edge facing away from its partner cannot be reached
+		// directly: the straight path would run through its own board. Send
+		// it out of its own side and over the top instead. Row -1 upwards
+		// is reserved margin, allocated per wrapped wire so they nest.
+		r.from.exitsAway = r.from.Side == Left
+		r.to.exitsAway = r.to.Side == Right
+		r.wrapped = r.from.exitsAway || r.to.exitsAway
+		// Nothing is wrong with either pin of a detour; a board simply
+		// stands in the way. Go under everything rather than through it.
+		r.detoured = !r.wrapped && s.crossesBoard(r.from, r.to)
+
+		// A wire running down the page, given the lane nearest its
+		// target, nests inside the ones above it that it would otherwise
+		// cross on its way out; see LaneOrder.
+		var free []int
 		for lane := gutterStart; lane <= gutterEnd; lane++ {
 			clash := false
 			for _, sp := range occupied[lane] {
@@ -233,35 +311,57 @@ func (s *Schematic) routes(pos map[string]PinPos) ([]route, error) {
 					break
 				}
 			}
-			if clash {
-				continue
+			if !clash {
+				free = append(free, lane)
 			}
-			occupied[lane] = append(occupied[lane], span{lo, hi})
-			r.lane = lane
-			placed = true
-			break
 		}
-		if !placed {
+		if s.Lanes == LanesByDirection && r.y1 > r.y0 {
+			slices.Reverse(free)
+		}
+		switch {
+		case s.Lanes == LanesByCrossings && !r.detoured:
+			// Of the free lanes, the one whose path crosses fewest of
+			// the wires already placed. A path running along another,
+			// as one given a lane that is not free does, reads as one
+			// wire, and is worse than any number of crossings; with
+			// no lane free, it is the one it runs along least.
+			lanes := free
+			if len(lanes) == 0 {
+				for lane := gutterStart; lane <= gutterEnd; lane++ {
+					lanes = append(lanes, lane)
+				}
+			}
+			best, least := lanes[0], -1
+			for _, lane := range lanes {
+				r.lane = lane
+				pts := buildPath(*r, wrapRow)
+				n := 0
+				for _, q := range rs[:i] {
+					n += segmentCrossings(pts, q.pts) + segmentCrossings(q.pts, pts)
+					if overlaps(pts, q.pts) {
+						n += len(rs) * len(rs)
+					}
+				}
+				if least < 0 || n < least {
+					best, least = lane, n
+				}
+			}
+			r.lane = best
+			occupied[r.lane] = append(occupied[r.lane], span{lo, hi})
+		case len(free) > 0:
+			r.lane = free[0]
+			occupied[r.lane] = append(occupied[r.lane], span{lo, hi})
+		default:
 			// Every lane is taken across this wire's span. Fall back to
 			// the middle and accept a crossing rather than dropping it.
 			r.lane = (gutterStart + gutterEnd) / 2
 		}
 
-		// A pin on the edge facing away from its partner cannot be reached
-		// directly: the straight path would run through its own board. Send
-		// it out of its own side and over the top instead. Row -1 upwards
-		// is reserved margin, allocated per wrapped wire so they nest.
-		r.from.exitsAway = r.from.Side == Left
-		r.to.exitsAway = r.to.Side == Right
-		r.wrapped = r.from.exitsAway || r.to.exitsAway
-
 		switch {
 		case r.wrapped:
 			r.pts = buildPath(*r, wrapRow)
 			wrapRow--
-		case s.crossesBoard(r.from, r.to):
-			// Nothing is wrong with either pin; a board simply stands in
-			// the way. Go under everything rather than through it.
+		case r.detoured:
 			r.pts = buildDetour(*r, detourRow)
 			detourRow++
 		default:
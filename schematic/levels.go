@@ -0,0 +1,310 @@
+package schematic
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Level is what a pin needs and gives electrically, in volts and milliamps,
+// for the checks that a type alone cannot make: that a 3.3 V output is high
+// enough for the input it drives and not too high for it, that a supply is in
+// the range the part runs on, and that the parts on a rail draw no more than
+// its source can give. A zero is not known, and is not checked.
+//
+// Which fields mean something depends on the pin's type:
+//
+//	power_out   V is the voltage it supplies, MA the most it can supply
+//	power_in    VMin and VMax the supply it runs on, MA what it draws
+//	output      V is the voltage it drives high to
+//	input       VIH the lowest it reads as high, VMax the highest it tolerates
+//	passive     MA what it draws, as a backlight does
+//
+// and a bidirectional pin is both an output and an input. A power_out pin may
+// also name, as Upstream, the pin of its own board its supply is made from:
+// the Pico's 3V3 comes out of a regulator on VSYS, so whatever 3V3 supplies
+// is drawn from VSYS as well.
+type Level struct {
+	V        float64 `json:"v,omitempty" yaml:"v,omitempty" toml:"v,omitzero"`
+	VMin     float64 `json:"v_min,omitempty" yaml:"v_min,omitempty" toml:"v_min,omitzero"`
+	VMax     float64 `json:"v_max,omitempty" yaml:"v_max,omitempty" toml:"v_max,omitzero"`
+	VIH      float64 `json:"v_ih,omitempty" yaml:"v_ih,omitempty" toml:"v_ih,omitzero"`
+	MA       float64 `json:"ma,omitempty" yaml:"ma,omitempty" toml:"ma,omitzero"`
+	Upstream string  `json:"upstream,omitempty" yaml:"upstream,omitempty" toml:"upstream,omitempty"`
+}
+
+// level is the level of one of the board's pins, all zero unless Levels says.
+func (b *Board) level(pin string) Level { return b.Levels[pin] }
+
+// Budget is the current on one rail: the pin supplying it, and what each pin
+// on it draws.
+type Budget struct {
+	Source string  // the power_out pin, as board.pin
+	V      float64 // what it supplies
+	Limit  float64 // the most it can supply, in mA; 0 if not known
+	Loads  []RailLoad
+
+	source netPin
+	net    string // how a violation names the rail
+}
+
+// RailLoad is what one pin on a rail draws. A load that is a Rail is another
+// rail's source, made from this one, and draws what that rail's loads do.
+type RailLoad struct {
+	Pin  string // as board.pin
+	MA   float64
+	Rail bool
+}
+
+// Total is what the rail's loads draw, in mA.
+func (b Budget) Total() float64 {
+	t := 0.0
+	for _, l := range b.Loads {
+		t += l.MA
+	}
+	return t
+}
+
+// Headroom is what the source can give beyond what is drawn from it, in mA:
+// negative for a rail over budget.
+func (b Budget) Headroom() float64 { return b.Limit - b.Total() }
+
+// Budgets sums the current on each rail a power_out pin supplies, in the order
+// of the rails' first pins. A rail whose source has neither a voltage nor a
+// limit is left out, having nothing to report.
+//
+// A rail made from another is one of that rail's loads, drawing everything it
+// supplies, so a rail's total is what its source really gives: on the Pico, a
+// part on 3V3 counts against VSYS and VBUS too. A rail that is only upstream
+// of others, with nothing wired to it, is listed after them.
+func (s *Schematic) Budgets() ([]Budget, error) {
+	groups, err := s.connect()
+	if err != nil {
+		return nil, err
+	}
+	return budgets(groups), nil
+}
+
+// budgets is Budgets for nets already connected.
+func budgets(groups []netGroup) []Budget {
+	var bs []Budget
+	index := map[string]int{}
+	for _, g := range groups {
+		if b, ok := budget(g); ok {
+			index[b.Source] = len(bs)
+			bs = append(bs, b)
+		}
+	}
+
+	// upstream is the rail bs[i] is made from, added if nothing is wired
+	// to it, or -1. A loop of Upstreams, which a board in a file could
+	// describe, ends where it would come round again.
+	parent := map[int]int{}
+	upstream := func(i int) int {
+		src := bs[i].source
+		up := src.board.level(src.name).Upstream
+		if up == "" || up == src.name {
+			return -1
+		}
+		np := netPin{board: src.board, name: up}
+		j, ok := index[np.ref()]
+		if !ok {
+			l := src.board.level(up)
+			if l.V == 0 && l.MA == 0 {
+				return -1
+			}
+			j = len(bs)
+			index[np.ref()] = j
+			bs = append(bs, Budget{Source: np.ref(), V: l.V, Limit: l.MA, source: np, net: np.ref()})
+		}
+		for k, ok := j, true; ok; k, ok = parent[k] {
+			if k == i {
+				return -1
+			}
+		}
+		return j
+	}
+	for i := 0; i < len(bs); i++ {
+		if j := upstream(i); j >= 0 {
+			parent[i] = j
+		}
+	}
+
+	// A rail is charged to its parent once its own children are charged to
+	// it, so the figure passed up is the whole of what it supplies.
+	charged := map[int]bool{}
+	var charge func(int)
+	charge = func(i int) {
+		if charged[i] {
+			return
+		}
+		charged[i] = true
+		for c := range bs {
+			if p, ok := parent[c]; ok && p == i {
+				charge(c)
+				bs[i].Loads = append(bs[i].Loads, RailLoad{Pin: bs[c].Source, MA: bs[c].Total(), Rail: true})
+			}
+		}
+	}
+	for i := range bs {
+		charge(i)
+	}
+	return bs
+}
+
+// budget is the rail a net is, if one of its pins is a source of known level.
+// A net with two sources is an output-conflict, and counted against the first.
+func budget(g netGroup) (Budget, bool) {
+	var b Budget
+	for _, p := range g.pins {
+		if p.board.pinType(p.name) != PowerOut {
+			continue
+		}
+		if l := p.board.level(p.name); l.V != 0 || l.MA != 0 {
+			b = Budget{Source: p.ref(), V: l.V, Limit: l.MA, source: p, net: netName(g)}
+			break
+		}
+	}
+	if b.Source == "" {
+		return b, false
+	}
+	for _, p := range g.pins {
+		if l := p.board.level(p.name); l.MA != 0 && p.board.pinType(p.name) != PowerOut {
+			b.Loads = append(b.Loads, RailLoad{Pin: p.ref(), MA: l.MA})
+		}
+	}
+	return b, true
+}
+
+// levelRules are the rules checkLevels reports under, which PowerReport lists.
+var levelRules = []Rule{RuleLogicLevel, RuleOvervoltage, RuleUndervoltage, RuleOverBudget}
+
+// checkLevels reports, for one net, each driver too low for an input it
+// drives or too high for it; overBudget, which needs every rail at once, does
+// the budgets. A pin is not checked against itself, nor against another
+// pin of its own board on the same rail.
+func checkLevels(g netGroup, name string, report func(Rule, []netPin, string, ...any)) {
+	for _, d := range g.pins {
+		dt, dl := d.board.pinType(d.name), d.board.level(d.name)
+		drives := dt == Output || dt == Bidirectional || dt == PowerOut
+		if !drives || dl.V == 0 {
+			continue
+		}
+		verb := "drives"
+		if dt == PowerOut {
+			verb = "supplies"
+		}
+		for _, r := range g.pins {
+			if r.board == d.board {
+				continue
+			}
+			rt, rl := r.board.pinType(r.name), r.board.level(r.name)
+			switch rt {
+			case Input, Bidirectional, OpenDrain:
+				if rl.VMax != 0 && dl.V > rl.VMax {
+					report(RuleOvervoltage, []netPin{d, r}, "%s: %s %s %s at %s, above the %s it tolerates", name, d.ref(), verb, r.ref(), volts(dl.V), volts(rl.VMax))
+				} else if rl.VIH != 0 && dl.V < rl.VIH {
+					report(RuleLogicLevel, []netPin{d, r}, "%s: %s %s %s high at %s, below the %s it reads as high", name, d.ref(), verb, r.ref(), volts(dl.V), volts(rl.VIH))
+				}
+			case PowerIn:
+				if rl.VMax != 0 && dl.V > rl.VMax {
+					report(RuleOvervoltage, []netPin{d, r}, "%s: %s %s %s at %s, above the %s it tolerates", name, d.ref(), verb, r.ref(), volts(dl.V), volts(rl.VMax))
+				} else if rl.VMin != 0 && dl.V < rl.VMin {
+					report(RuleUndervoltage, []netPin{d, r}, "%s: %s %s %s at %s, below the %s it needs", name, d.ref(), verb, r.ref(), volts(dl.V), volts(rl.VMin))
+				}
+			}
+		}
+	}
+}
+
+// overBudget reports each rail whose loads, the rails made from it included,
+// draw more than its source can give.
+func overBudget(bs []Budget, report func(Rule, []string, string, ...any)) {
+	for _, b := range bs {
+		if b.Limit == 0 || b.Total() <= b.Limit {
+			continue
+		}
+		refs := []string{b.Source}
+		for _, l := range b.Loads {
+			refs = append(refs, l.Pin)
+		}
+		report(RuleOverBudget, refs, "%s: %s supplies %s, over the %s it can", b.net, b.Source, milliamps(b.Total()), milliamps(b.Limit))
+	}
+}
+
+// PowerReport is the rails' budgets as a table — for each rail, its source,
+// its voltage, what is drawn from it and by what, and the headroom left — and
+// under it what ERC finds wrong with the levels and the budgets.
+//
+//	RAIL              V       LOAD       LIMIT     HEADROOM
+//	----              -       ----       -----     --------
+//	pico.3V3          3.3 V   1.5 mA     300 mA    298.5 mA  99%
+//	  rtc.VCC                 1.5 mA
+//	pico.VSYS         4.7 V   23 mA      500 mA    477 mA  95%
+//	  lcd2.VCC                1.5 mA
+//	  lcd2.LED+               20 mA
+//	  pico.3V3 rail           1.5 mA
+//	pico.VBUS         5 V     44.5 mA    500 mA    455.5 mA  91%
+//	  lcd.VCC                 1.5 mA
+//	  lcd.LED+                20 mA
+//	  pico.VSYS rail          23 mA
+//
+//	warning: PWR net at rtc.VCC: pico.3V3 supplies rtc.VCC at 3.3 V, below the 4.5 V it needs [undervoltage]
+func (s *Schematic) PowerReport() (string, error) {
+	bs, err := s.Budgets()
+	if err != nil {
+		return "", err
+	}
+	vs, err := s.ERC()
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	row := func(rail, v, load, limit, headroom string) {
+		fmt.Fprintln(&b, strings.TrimRight(fmt.Sprintf("%-16s  %-6s  %-9s  %-8s  %s", rail, v, load, limit, headroom), " "))
+	}
+	row("RAIL", "V", "LOAD", "LIMIT", "HEADROOM")
+	row("----", "-", "----", "-----", "--------")
+	for _, bg := range bs {
+		limit, headroom := "?", ""
+		if bg.Limit != 0 {
+			limit = milliamps(bg.Limit)
+			// Rounded down, so a rail with any load is never at 100%.
+			headroom = fmt.Sprintf("%s  %.0f%%", milliamps(bg.Headroom()), math.Floor(100*bg.Headroom()/bg.Limit))
+		}
+		v := "?"
+		if bg.V != 0 {
+			v = volts(bg.V)
+		}
+		row(bg.Source, v, milliamps(bg.Total()), limit, headroom)
+		for _, l := range bg.Loads {
+			pin := "  " + l.Pin
+			if l.Rail {
+				pin += " rail"
+			}
+			row(pin, "", milliamps(l.MA), "", "")
+		}
+	}
+	first := true
+	for _, v := range vs {
+		if slices.Contains(levelRules, v.Rule) {
+			if first {
+				b.WriteString("\n")
+				first = false
+			}
+			fmt.Fprintln(&b, v)
+		}
+	}
+	return b.String(), nil
+}
+
+// volts and milliamps write a quantity to the hundredth, which is as precise
+// as any datasheet figure here, and hides the float rounding of a sum.
+func volts(v float64) string      { return hundredths(v) + " V" }
+func milliamps(ma float64) string { return hundredths(ma) + " mA" }
+
+func hundredths(x float64) string {
+	return strconv.FormatFloat(math.Round(x*100)/100, 'f', -1, 64)
+}
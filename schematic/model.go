@@ -14,6 +14,8 @@ package schematic
 
 import (
 	"fmt"
+	"maps"
+	"slices"
 	"sort"
 	"strings"
 )
@@ -34,37 +36,55 @@ const Gap = ""
 // Board is one component. Left and Right are the pin names in each column,
 // listed top to bottom; use Gap for a blank row.
 type Board struct {
-	Name  string // referenced by wires, e.g. "pico"
-	Title string // shown on the drawing; defaults to Name
-	Left  []string
-	Right []string
+	Name  string   `json:"name" yaml:"name" toml:"name"`                                  // referenced by wires, e.g. "pico"
+	Title string   `json:"title,omitempty" yaml:"title,omitempty" toml:"title,omitempty"` // shown on the drawing; defaults to Name
+	Part  string   `json:"part,omitempty" yaml:"part,omitempty" toml:"part,omitempty"`    // the library part it was made from, if any
+	Left  []string `json:"left,omitempty" yaml:"left,omitempty" toml:"left,omitempty"`
+	Right []string `json:"right,omitempty" yaml:"right,omitempty" toml:"right,omitempty"`
 
 	// Col is the horizontal slot this board occupies. Boards sharing a Col
 	// are stacked vertically, which is how a microcontroller ends up with
 	// two peripherals down one side of it. Left to zero for every board,
 	// they are simply placed left to right in declaration order.
-	Col int
+	Col int `json:"col,omitempty" yaml:"col,omitempty" toml:"col,omitzero"`
 
 	// Labels overrides what is printed for a pin, keyed by pin name. A wire
 	// has to name exactly one pin, so a part with eight grounds needs eight
 	// distinct names — but the silkscreen says GND on all eight, and the
 	// drawing should too. Without this the bookkeeping leaks into the
 	// picture and invents pins that do not exist on the hardware.
-	Labels map[string]string
+	Labels map[string]string `json:"labels,omitempty" yaml:"labels,omitempty" toml:"labels,omitempty"`
 
 	// Passive marks a part that does not drive a signal — a resistor
 	// network, a supply rail, a connector. A pin may carry several wires
 	// when the extra ones go to a passive: a pull-up tapping a bus line is
 	// normal, two GPIOs wired together is not.
-	Passive bool
+	Passive bool `json:"passive,omitempty" yaml:"passive,omitempty" toml:"passive,omitempty"`
 
 	// FirstPin, when non-zero, numbers the pins on the drawing the way the
 	// datasheet does: down the left column from FirstPin, then up the right
 	// column, as a DIP is numbered.
-	FirstPin int
+	FirstPin int `json:"first_pin,omitempty" yaml:"first_pin,omitempty" toml:"first_pin,omitzero"`
+
+	// Mirrored says Left and Right have been swapped from the datasheet's
+	// view of the part, as it is seen from behind, so that its pins face
+	// the boards they are wired to. It is what Optimize sets when it turns
+	// a board over; the pins keep their numbers, which run down the right
+	// column and back up the left.
+	Mirrored bool `json:"mirrored,omitempty" yaml:"mirrored,omitempty" toml:"mirrored,omitempty"`
+
+	// Types says what each pin does electrically, keyed by pin name, for
+	// ERC. A pin not in it is Untyped, and the checks give it the benefit
+	// of the doubt; a board from the part library has its pins typed.
+	Types map[string]PinType `json:"types,omitempty" yaml:"types,omitempty" toml:"types,omitempty"`
+
+	// Levels gives the voltages and currents of the pins whose are known,
+	// keyed by pin name, for the level and current checks ERC makes and
+	// PowerReport.
+	Levels map[string]Level `json:"levels,omitempty" yaml:"levels,omitempty" toml:"levels,omitempty"`
 
 	// Computed by Layout. Origin is the top-left of the box.
-	X, Y, W, H int
+	X, Y, W, H int `json:"-" yaml:"-" toml:"-"`
 }
 
 // Wire is a connection between two pins, written as "board.pin".
@@ -72,9 +92,14 @@ type Board struct {
 // Net groups wires that carry the same signal, which is what colors them. It
 // is deliberately not derived from the pin names: "pico.GND" to "lcd.GND" and
 // "pico.GP22" to "lcd.BIT0" are both wires, but only the first is a power net.
+//
+// Name is what the wire's net is called where it is drawn as a net label; see
+// NetLabels. Without one, a label is named for the net's Net or rail.
 type Wire struct {
-	From, To string
-	Net      string
+	From string `json:"from" yaml:"from" toml:"from"`
+	To   string `json:"to" yaml:"to" toml:"to"`
+	Net  string `json:"net,omitempty" yaml:"net,omitempty" toml:"net,omitempty"`
+	Name string `json:"name,omitempty" yaml:"name,omitempty" toml:"name,omitempty"`
 }
 
 // Schematic is the whole drawing: the boards and what joins them.
@@ -88,6 +113,22 @@ type Schematic struct {
 
 	// Theme is the palette to draw with. Nil means Dark.
 	Theme *Theme
+
+	// Severity overrides DefaultSeverity for ERC, by rule.
+	Severity map[Rule]Severity
+
+	// NetLabels chooses wires to draw as net labels. Nil draws every wire.
+	NetLabels *NetLabels
+
+	// Lanes is how the wires are given lanes in the gutters.
+	Lanes LaneOrder
+
+	// labelRoom and labelLeft are set on the copy of a schematic that is
+	// drawn with net labels: the widest label, which each gutter leaves
+	// room for on both sides, and whether the first column needs that room
+	// to its left too.
+	labelRoom int
+	labelLeft bool
 }
 
 // PinPos is where a pin ended up after layout.
@@ -131,13 +172,13 @@ func (s *Schematic) Validate() []error {
 	var errs []error
 
 	seen := map[string]bool{}
-	for _, b := range s.Boards {
+	for i, b := range s.Boards {
 		if b.Name == "" {
-			errs = append(errs, fmt.Errorf("a board has no Name"))
+			errs = append(errs, boardError(i, "a board has no Name"))
 			continue
 		}
 		if seen[b.Name] {
-			errs = append(errs, fmt.Errorf("two boards are named %q", b.Name))
+			errs = append(errs, boardError(i, "two boards are named %q", b.Name))
 		}
 		seen[b.Name] = true
 
@@ -148,63 +189,194 @@ func (s *Schematic) Validate() []error {
 					continue
 				}
 				if pins[p] {
-					errs = append(errs, fmt.Errorf("board %q has two pins named %q", b.Name, p))
+					errs = append(errs, boardError(i, "board %q has two pins named %q", b.Name, p))
 				}
 				pins[p] = true
 			}
 		}
+		for _, p := range slices.Sorted(maps.Keys(b.Types)) {
+			switch {
+			case !pins[p]:
+				errs = append(errs, boardError(i, "board %q types pin %q, which it does not have", b.Name, p))
+			case !slices.Contains(PinTypes, b.Types[p]):
+				errs = append(errs, boardError(i, "board %q: pin %q has no type %q", b.Name, p, b.Types[p]))
+			}
+		}
+		for _, p := range slices.Sorted(maps.Keys(b.Levels)) {
+			if !pins[p] {
+				errs = append(errs, boardError(i, "board %q gives a level for pin %q, which it does not have", b.Name, p))
+			}
+		}
 	}
 
 	// Count wires per pin so a pin driven twice is visible. This is a
 	// warning in spirit, but returning it as an error keeps one code path.
 	uses := map[string]int{}
+	last := map[string]int{} // the last wire on each pin, to point at
 	passive := map[string]bool{}
 	for _, b := range s.Boards {
 		passive[b.Name] = b.Passive
 	}
 
 	for i, w := range s.Wires {
-		for _, ref := range []string{w.From, w.To} {
+		for _, end := range []string{"from", "to"} {
+			ref := w.From
+			if end == "to" {
+				ref = w.To
+			}
 			bn, pn, err := splitRef(ref)
 			if err != nil {
-				errs = append(errs, fmt.Errorf("wire %d: %w", i, err))
+				errs = append(errs, wireError(i, end, "wire %d: %v", i, err))
 				continue
 			}
 			b := s.board(bn)
 			if b == nil {
-				errs = append(errs, fmt.Errorf("wire %d: no board named %q", i, bn))
+				errs = append(errs, wireError(i, end, "wire %d: no board named %q", i, bn))
 				continue
 			}
 			if !b.hasPin(pn) {
-				errs = append(errs, fmt.Errorf("wire %d: board %q has no pin %q", i, bn, pn))
+				errs = append(errs, wireError(i, end, "wire %d: board %q has no pin %q", i, bn, pn))
 				continue
 			}
 			// A wire that lands on a passive does not count as a driver at
 			// either end.
 			if !passive[bn] && !endsOnPassive(w, passive) {
 				uses[ref]++
+				last[ref] = i
 			}
 		}
 		if w.From == w.To {
-			errs = append(errs, fmt.Errorf("wire %d: %q connects to itself", i, w.From))
+			errs = append(errs, wireError(i, "", "wire %d: %q connects to itself", i, w.From))
 		}
 	}
 
 	// Report multiply-driven pins in a stable order.
 	var multi []string
 	for ref, n := range uses {
-		if n > 1 && !isBusPin(ref) {
-			multi = append(multi, fmt.Sprintf("%s (%d wires)", ref, n))
+		if n > 1 && !isBusPin(ref) && !s.isPowerPin(ref) {
+			multi = append(multi, ref)
 		}
 	}
 	sort.Strings(multi)
-	for _, m := range multi {
-		errs = append(errs, fmt.Errorf("pin carries more than one wire: %s", m))
+	for _, ref := range multi {
+		errs = append(errs, wireError(last[ref], "", "pin carries more than one wire: %s (%d wires)", ref, uses[ref]))
 	}
 
 	return errs
 }
 
+// netPin is one pin of a net.
+type netPin struct {
+	board *Board
+	name  string
+	num   int // as KiCadNetlist numbers it
+}
+
+func (p netPin) ref() string { return p.board.Name + "." + p.name }
+
+// netGroup is the pins a chain of wires connects, and the Net of each wire in
+// it, by how many wires are on it.
+type netGroup struct {
+	pins []netPin // in board order, then pin number
+	nets map[string]int
+}
+
+// connect merges the wires into the nets they make. Every pin a chain of
+// wires reaches is one net, and so are the pins of one board that are on the
+// same rail, as the Pico's eight grounds are, however many wires go to them.
+// Pins no wire reaches are in no net.
+func (s *Schematic) connect() ([]netGroup, error) {
+	parent := map[string]string{}
+	var find func(string) string
+	find = func(r string) string {
+		if p, ok := parent[r]; ok && p != r {
+			root := find(p)
+			parent[r] = root
+			return root
+		}
+		return r
+	}
+	union := func(a, b string) { parent[find(a)] = find(b) }
+
+	var all []netPin
+	pins := map[string]bool{}
+	for _, b := range s.Boards {
+		rails := map[string]string{}
+		for _, p := range b.kicadPins() {
+			np := netPin{b, p.name, p.num}
+			all = append(all, np)
+			pins[np.ref()] = true
+			if r := rail(b.label(p.name)); r != "" {
+				if first, ok := rails[r]; ok {
+					union(np.ref(), first)
+				} else {
+					rails[r] = np.ref()
+				}
+			}
+		}
+	}
+
+	for i, w := range s.Wires {
+		for _, ref := range []string{w.From, w.To} {
+			if !pins[ref] {
+				return nil, fmt.Errorf("wire %d: no pin %q", i, ref)
+			}
+		}
+		union(w.From, w.To)
+	}
+	nets := map[string]map[string]int{} // root → Net → wires
+	for _, w := range s.Wires {
+		root := find(w.From)
+		if nets[root] == nil {
+			nets[root] = map[string]int{}
+		}
+		nets[root][w.Net]++
+	}
+
+	// all is in board order and each board's pins in number order, so
+	// each group's pins are too.
+	var roots []string
+	byRoot := map[string]*netGroup{}
+	for _, p := range all {
+		root := find(p.ref())
+		if nets[root] == nil {
+			continue
+		}
+		g, ok := byRoot[root]
+		if !ok {
+			g = &netGroup{nets: nets[root]}
+			byRoot[root] = g
+			roots = append(roots, root)
+		}
+		g.pins = append(g.pins, p)
+	}
+	groups := make([]netGroup, len(roots))
+	for i, r := range roots {
+		groups[i] = *byRoot[r]
+	}
+	return groups, nil
+}
+
+// Error is one of the problems Validate finds, with what it is about: the
+// index of the board or of the wire, and for a wire, which end. A schematic
+// read from a file uses them to say where in the file the problem is.
+type Error struct {
+	Board int    // -1 if the problem is not a board's
+	Wire  int    // -1 if the problem is not a wire's
+	End   string // "from", "to", or "" for the wire as a whole
+	Msg   string
+}
+
+func (e *Error) Error() string { return e.Msg }
+
+func boardError(i int, format string, args ...any) *Error {
+	return &Error{Board: i, Wire: -1, Msg: fmt.Sprintf(format, args...)}
+}
+
+func wireError(i int, end, format string, args ...any) *Error {
+	return &Error{Board: -1, Wire: i, End: end, Msg: fmt.Sprintf(format, args...)}
+}
+
 // isBusPin reports whether a pin is expected to carry several wires. Power and
 // ground legitimately fan out; a GPIO does not.
 func isBusPin(ref string) bool {
@@ -219,6 +391,17 @@ func isBusPin(ref string) bool {
 	return false
 }
 
+// isPowerPin reports whether a pin is typed as a supply, in or out, which may
+// fan out whatever it is called: isBusPin knows VCC, but not a board's V+.
+func (s *Schematic) isPowerPin(ref string) bool {
+	bn, pin, err := splitRef(ref)
+	if err != nil {
+		return false
+	}
+	b := s.board(bn)
+	return b != nil && (b.pinType(pin) == PowerIn || b.pinType(pin) == PowerOut)
+}
+
 func (b *Board) hasPin(name string) bool {
 	for _, col := range [][]string{b.Left, b.Right} {
 		for _, p := range col {
@@ -284,15 +467,30 @@ func isRail(label string) bool {
 
 // pinNumber returns the datasheet pin number for a column entry, numbering
 // down the left column and back up the right, or 0 if the board is unnumbered.
+// A board with one column is a single row of header pins, numbered from one
+// end, whichever side it is drawn on. A Mirrored board is numbered down the
+// right and back up the left, so each pin keeps its number.
 func (b *Board) pinNumber(side Side, i int) int {
 	if b.FirstPin == 0 {
 		return 0
 	}
-	if side == Left {
+	down, up := b.Left, b.Right
+	if b.Mirrored {
+		down, up = up, down
+		side = Right - side
+	}
+	if side == Left || len(down) == 0 {
 		return b.FirstPin + i
 	}
-	// Right column counts back from the far end.
-	return b.FirstPin + len(b.Left) + len(b.Right) - 1 - i
+	// The second column counts back from the far end.
+	return b.FirstPin + len(down) + len(up) - 1 - i
+}
+
+// mirror turns the board over: its columns swap sides, and its pins keep
+// their numbers.
+func (b *Board) mirror() {
+	b.Left, b.Right = b.Right, b.Left
+	b.Mirrored = !b.Mirrored
 }
 
 // endsOnPassive reports whether either end of the wire is a passive part.
@@ -0,0 +1,160 @@
+package schematic
+
+import (
+	"fmt"
+	"time"
+)
+
+// LaneOrder is how routes gives the wires lanes in a gutter. Each wire takes
+// the first lane free across its span, in the order of the rows the wires
+// leave from; what differs is which end of the gutter it looks from.
+type LaneOrder string
+
+// The lane orders.
+const (
+	// LanesFromSource has every wire look from its source's end, which
+	// nests a bundle running up the page and crosses one running down.
+	LanesFromSource LaneOrder = ""
+
+	// LanesByDirection has a wire running down the page look from its
+	// target's end, so that a bundle nests whichever way it runs.
+	LanesByDirection LaneOrder = "direction"
+
+	// LanesByCrossings has each wire take, of the lanes free across its
+	// span, the one where it crosses the fewest of the wires before it.
+	LanesByCrossings LaneOrder = "crossings"
+)
+
+// LaneOrders is every lane order, in the order Optimize tries them.
+var LaneOrders = []LaneOrder{LanesFromSource, LanesByDirection, LanesByCrossings}
+
+// Stats is how readable a drawing is, in the numbers that make it so.
+type Stats struct {
+	Crossings int // pairs of wires crossing, counted once per crossing
+	Wraps     int // wires sent around the outside of a board
+	Detours   int // wires sent under everything to get past a board
+	Length    int // cells of wire, end to end, all told
+	Width     int // the drawing's extent, in cells
+	Height    int
+}
+
+// Area is the cells the drawing covers.
+func (st Stats) Area() int { return st.Width * st.Height }
+
+func (st Stats) String() string {
+	return fmt.Sprintf("%d crossings, %d wraps, %d detours, %d cells of wire, %d×%d",
+		st.Crossings, st.Wraps, st.Detours, st.Length, st.Width, st.Height)
+}
+
+// cost is what Optimize minimises. A crossing is what makes a drawing hard to
+// follow, and a wrap or a detour is a long way round that crosses most of what
+// it passes, counted or not: it weighs as ten, so that turning a board whose
+// wires all wrap wins even where those wires, brought inside, cross the ones
+// that wrap around another. Length and area only decide between drawings as
+// tangled as each other, a screenful of cells weighing as one crossing.
+func (st Stats) cost() int {
+	return 10*st.Crossings + 100*(st.Wraps+st.Detours) + st.Length/10 + st.Area()/200
+}
+
+// Stats measures the drawing as RenderText draws it, net labels and all.
+func (s *Schematic) Stats() (Stats, error) {
+	dr, err := s.draw()
+	if err != nil {
+		return Stats{}, err
+	}
+	st := Stats{Width: dr.w, Height: dr.h}
+	for _, n := range crossings(dr.rs) {
+		st.Crossings += n
+	}
+	st.Crossings /= 2
+	for _, r := range dr.rs {
+		switch {
+		case r.wrapped:
+			st.Wraps++
+		case r.detoured:
+			st.Detours++
+		}
+		st.Length += pathLength(r.pts)
+	}
+	return st, nil
+}
+
+// Optimize rearranges the drawing to be easier to read, and returns its Stats
+// before and after. It changes how the schematic is drawn and never what it
+// connects: the order of the boards stacked in each column, which way each
+// board faces, and the LaneOrder.
+//
+// Layout keeps the order the boards were declared in, and routes the order of
+// the rows the wires leave from, which makes the drawing as good as the order
+// the description happened to be written in. Optimize searches instead: it
+// tries every swap of two boards in a column, turning each board over, and
+// each lane order, keeps whichever change most improves the drawing, and goes
+// again from there until no change does, or budget is spent. It is a local
+// search, and finds a drawing no worse than the one it starts from rather than
+// the best there is. Within the budget, the same schematic always comes out the
+// same way.
+func (s *Schematic) Optimize(budget time.Duration) (before, after Stats, err error) {
+	if before, err = s.Stats(); err != nil {
+		return before, before, err
+	}
+	deadline := time.Now().Add(budget)
+	best := before
+	for time.Now().Before(deadline) {
+		var keep func()
+		next := best
+		try := func(do, undo func()) error {
+			do()
+			st, err := s.Stats()
+			undo()
+			if err != nil {
+				return err
+			}
+			if st.cost() < next.cost() {
+				next, keep = st, do
+			}
+			return nil
+		}
+		for _, m := range s.moves() {
+			if err := try(m.do, m.undo); err != nil {
+				return before, best, err
+			}
+			if !time.Now().Before(deadline) {
+				break
+			}
+		}
+		if keep == nil {
+			break
+		}
+		keep()
+		best = next
+	}
+	return before, best, nil
+}
+
+// move is one change Optimize can make to a schematic, and its undoing.
+type move struct{ do, undo func() }
+
+// moves is every change Optimize tries from where s is.
+func (s *Schematic) moves() []move {
+	var ms []move
+	for i, a := range s.Boards {
+		for j := i + 1; j < len(s.Boards); j++ {
+			if s.Boards[j].Col != a.Col {
+				continue
+			}
+			swap := func() { s.Boards[i], s.Boards[j] = s.Boards[j], s.Boards[i] }
+			ms = append(ms, move{swap, swap})
+		}
+	}
+	for _, b := range s.Boards {
+		ms = append(ms, move{b.mirror, b.mirror})
+	}
+	for _, o := range LaneOrders {
+		if o == s.Lanes {
+			continue
+		}
+		was := s.Lanes
+		ms = append(ms, move{func() { s.Lanes = o }, func() { s.Lanes = was }})
+	}
+	return ms
+}
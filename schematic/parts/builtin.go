@@ -0,0 +1,287 @@
+package parts
+
+import (
+	"fmt"
+
+	"github.com/0magnet/tinygo-stuff/schematic"
+)
+
+// The built-in parts. They are registered before anything can look one up, and
+// a mistake in one is a bug in this file, so registering them panics rather
+// than returning an error no caller could act on.
+func init() {
+	if err := Register(
+		pico("pico", "Pi-Pico", "Raspberry Pi Pico: RP2040, 2 MB flash, 40-pin header"),
+		pico("pico-w", "Pi-Pico W", "Raspberry Pi Pico W: the Pico's header, with a CYW43439 for Wi-Fi and Bluetooth; "+
+			"its LED is on the wireless chip, not GP25"),
+		pico("pico2", "Pi-Pico 2", "Raspberry Pi Pico 2: RP2350, 4 MB flash, the Pico's header pin for pin"),
+		hd44780("hd44780", "HD44780", true),
+		hd44780("hd44780-14", "HD44780", false),
+		// The DS1307 is a 5 V part: below 1.25 times its battery's
+		// voltage it stops answering on I2C and runs from the battery.
+		// The DS3231's thresholds are 0.7 VCC, so depend on its supply.
+		rtc("ds1307", "DS1307", "DS1307 real-time clock, at I2C address 0x68; 4.5 V to 5.5 V",
+			schematic.Level{VMin: 4.5, VMax: 7, MA: 1.5}, schematic.Level{VIH: 2.2, VMax: 7},
+			"SQW"),
+		rtc("ds3231", "DS3231", "DS3231 temperature-compensated real-time clock, at I2C address 0x68; 2.3 V to 5.5 V",
+			schematic.Level{VMin: 2.3, VMax: 6, MA: 0.2}, schematic.Level{VMax: 6},
+			"SQW", "32K"),
+		typed(&Part{
+			ID:    "pcf8574",
+			Title: "PCF8574",
+			Desc:  "PCF8574 I2C backpack for an HD44780, at address 0x27 (0x3F for a PCF8574A); it drives the display in 4-bit mode",
+			Left:  []string{"GND", "VCC", "SDA", "SCL"},
+			Pins: map[string]Pin{
+				"VCC": {Desc: "5 V; the display and backlight run from it"},
+				"SDA": {Desc: "I2C data, pulled up to VCC on the backpack"},
+				"SCL": {Desc: "I2C clock, pulled up to VCC on the backpack"},
+			},
+		}, map[schematic.PinType][]string{
+			schematic.PowerIn:   {"GND", "VCC"},
+			schematic.OpenDrain: {"SDA"},
+			schematic.Input:     {"SCL"},
+		}, map[string]schematic.Level{
+			// What the backpack draws is the display's and its
+			// backlight's, which also set the supply it needs. Its
+			// thresholds are 0.7 VCC, so depend on the supply.
+			"VCC": {VMin: 4.5, VMax: 7, MA: 25},
+			"SDA": {VMax: 7},
+			"SCL": {VMax: 7},
+		}),
+		relay(4),
+		relay(8),
+	); err != nil {
+		panic(err)
+	}
+}
+
+// pico is the 40-pin header the Pico, Pico W and Pico 2 share, numbered as
+// the datasheet numbers it: 1 at GP0, down the left to 20, and back up the
+// right to 40 at VBUS.
+func pico(id, title, desc string) *Part {
+	p := &Part{
+		ID:    id,
+		Title: title,
+		Desc:  desc,
+		Left: []string{
+			"GP0", "GP1", "GND1", "GP2", "GP3", "GP4", "GP5", "GND2",
+			"GP6", "GP7", "GP8", "GP9", "GND3", "GP10", "GP11", "GP12",
+			"GP13", "GND4", "GP14", "GP15",
+		},
+		Right: []string{
+			"VBUS", "VSYS", "GND5", "3V3EN", "3V3", "AREF", "GP28", "GND6",
+			"GP27", "GP26", "RUN", "GP22", "GND7", "GP21", "GP20", "GP19",
+			"GP18", "GND8", "GP17", "GP16",
+		},
+		FirstPin: 1,
+		Labels: map[string]string{
+			"GP0": "GP0/U0Tx", "GP1": "GP1/U0Rx",
+			"GP26": "A0/GP26", "GP27": "A1/GP27", "GP28": "A2/GP28",
+		},
+		Pins: map[string]Pin{
+			"VBUS":  {Desc: "5 V from the USB connector, when there is one"},
+			"VSYS":  {Desc: "the main supply, 1.8 V to 5.5 V; VBUS through a diode when powered over USB"},
+			"3V3EN": {Desc: "pulled high; tie low to turn the 3.3 V regulator off"},
+			"3V3":   {Desc: "3.3 V out of the on-board regulator, for up to 300 mA of external load"},
+			"AREF":  {Desc: "ADC_VREF, the ADC's reference, filtered 3.3 V by default"},
+			"RUN":   {Desc: "pulled high; tie low to reset the RP2040"},
+		},
+	}
+	// The Pico has eight pins silkscreened GND. They need distinct names
+	// so a wire can say which one it lands on, but the drawing should show
+	// what is printed on the board.
+	for i := 1; i <= 8; i++ {
+		p.Labels[fmt.Sprintf("GND%d", i)] = "GND"
+	}
+	for _, col := range [][]string{p.Left, p.Right} {
+		for _, n := range col {
+			var gp int
+			if _, err := fmt.Sscanf(n, "GP%d", &gp); err == nil {
+				p.Pins[n] = Pin{Func: gpioFuncs(gp), Type: schematic.Bidirectional}
+			}
+		}
+	}
+	// The board is the supply of what is wired to it, ground included.
+	// RUN and 3V3EN are inputs, but pulled up on the board, so leaving
+	// them unconnected is how they are meant to be used; AREF is filtered
+	// from 3V3 on the board. None of them needs a wire, which is what
+	// passive says.
+	typed(p, map[schematic.PinType][]string{
+		schematic.PowerOut:   {"VBUS", "VSYS", "3V3", "GND1", "GND2", "GND3", "GND4", "GND5", "GND6", "GND7", "GND8"},
+		schematic.PassivePin: {"3V3EN", "RUN", "AREF"},
+	}, map[string]schematic.Level{
+		// Powered from USB: VBUS is what a USB 2.0 port gives, and VSYS
+		// is VBUS less the drop of the diode between them, sharing its
+		// limit. 3V3 is regulated from VSYS, so what it supplies is
+		// drawn from both.
+		"VBUS": {V: 5, MA: 500},
+		"VSYS": {V: 4.7, MA: 500, Upstream: "VBUS"},
+		"3V3":  {V: 3.3, MA: 300, Upstream: "VSYS"},
+	})
+	// The GPIOs run at IOVDD, 3.3 V, and are not 5 V tolerant: the
+	// datasheet's absolute maximum is IOVDD + 0.5 V.
+	for n, pin := range p.Pins {
+		if pin.Type == schematic.Bidirectional {
+			pin.Level = schematic.Level{V: 3.3, VIH: 2, VMax: 3.8}
+			p.Pins[n] = pin
+		}
+	}
+	return p
+}
+
+// gpioFuncs is what GPIO n of the RP2040 can be besides a GPIO, from the
+// datasheet's function table, which repeats every few pins: the I2C buses
+// alternate every two pins, SPI every eight, UART0 and UART1 every four pins
+// in pairs, and each pair of pins is one PWM slice.
+func gpioFuncs(n int) []string {
+	f := []string{
+		fmt.Sprintf("I2C%d %s", n/2%2, [2]string{"SDA", "SCL"}[n%2]),
+		fmt.Sprintf("SPI%d %s", n/8%2, [4]string{"RX", "CSn", "SCK", "TX"}[n%4]),
+	}
+	if n%4 < 2 {
+		f = append(f, fmt.Sprintf("UART%d %s", (n+4)/8%2, [2]string{"TX", "RX"}[n%4]))
+	}
+	f = append(f, fmt.Sprintf("PWM%d %c", n/2%8, 'A'+n%2))
+	if n >= 26 {
+		f = append(f, fmt.Sprintf("ADC%d", n-26))
+	}
+	return f
+}
+
+// hd44780 is a character display on the HD44780 or a clone, in the order of
+// its header, with the backlight's two pins or without them. The pins are
+// named for what they do rather than the datasheet's VSS, V0, DB0 and so on,
+// which are in their descriptions.
+func hd44780(id, title string, backlight bool) *Part {
+	p := &Part{
+		ID:    id,
+		Title: title,
+		Desc:  "HD44780 character display, 5 V, in 8-bit mode or 4-bit mode on BIT4-BIT7",
+		Left: []string{
+			"GND", "VCC", "CONT", "RS", "RW", "EN",
+			"BIT0", "BIT1", "BIT2", "BIT3",
+			"BIT4", "BIT5", "BIT6", "BIT7",
+		},
+		FirstPin: 1,
+		Pins: map[string]Pin{
+			"GND":  {Desc: "VSS, 0 V"},
+			"VCC":  {Desc: "VDD, 5 V"},
+			"CONT": {Desc: "V0, the contrast: a voltage between VSS and VDD, or PWM smoothed by a capacitor"},
+			"RS":   {Desc: "register select: low for a command, high for a character"},
+			"RW":   {Desc: "low to write, high to read; tie it low for a display that is only written to"},
+			"EN":   {Desc: "enable: the data pins are read on its falling edge"},
+		},
+	}
+	for i := range 8 {
+		p.Pins[fmt.Sprintf("BIT%d", i)] = Pin{Desc: fmt.Sprintf("DB%d", i), Type: schematic.Bidirectional}
+	}
+	// The contrast is an input: left unconnected, the display shows
+	// nothing. The backlight is not one, since a display without it lit
+	// still works.
+	types := map[schematic.PinType][]string{
+		schematic.PowerIn: {"GND", "VCC"},
+		schematic.Input:   {"CONT", "RS", "RW", "EN"},
+	}
+	if backlight {
+		p.Desc += ", with a backlight"
+		p.Left = append(p.Left, "LED+", "LED-")
+		p.Pins["LED+"] = Pin{Desc: "A, the backlight's anode; most modules have its resistor on board"}
+		p.Pins["LED-"] = Pin{Desc: "K, the backlight's cathode"}
+		types[schematic.PassivePin] = []string{"LED+", "LED-"}
+	} else {
+		p.Desc += ", without a backlight"
+	}
+	// From the datasheet at VCC = 5 V: an input reads as high from 2.2 V,
+	// which 3.3 V logic reaches, and takes up to VCC + 0.3 V. The data
+	// pins drive 5 V back only when the display is read, which a display
+	// with RW tied low never is, so they are given no output level.
+	levels := map[string]schematic.Level{
+		"VCC": {VMin: 4.5, VMax: 7, MA: 1.5},
+	}
+	for _, n := range []string{"RS", "RW", "EN", "BIT0", "BIT1", "BIT2", "BIT3", "BIT4", "BIT5", "BIT6", "BIT7"} {
+		levels[n] = schematic.Level{VIH: 2.2, VMax: 5.8}
+	}
+	if backlight {
+		levels["LED+"] = schematic.Level{MA: 20}
+	}
+	return typed(p, types, levels)
+}
+
+// rtc is a real-time clock module's I2C header. The modules differ in what
+// else they bring out, which is extra.
+func rtc(id, title, desc string, vcc, in schematic.Level, extra ...string) *Part {
+	p := &Part{
+		ID:    id,
+		Title: title,
+		Desc:  desc,
+		Left:  append([]string{"GND", "VCC", "SDA", "SCL"}, extra...),
+		Pins: map[string]Pin{
+			"SDA": {Desc: "I2C data; most modules pull it up to VCC"},
+			"SCL": {Desc: "I2C clock; most modules pull it up to VCC"},
+		},
+	}
+	for _, e := range extra {
+		switch e {
+		case "SQW":
+			p.Pins[e] = Pin{Desc: "square-wave output, open drain"}
+		case "32K":
+			p.Pins[e] = Pin{Desc: "32 kHz output, open drain"}
+		}
+	}
+	// The clock is an I2C target: it only ever pulls SDA low, and only
+	// reads SCL.
+	return typed(p, map[schematic.PinType][]string{
+		schematic.PowerIn:   {"GND", "VCC"},
+		schematic.OpenDrain: append([]string{"SDA"}, extra...),
+		schematic.Input:     {"SCL"},
+	}, map[string]schematic.Level{"VCC": vcc, "SDA": in, "SCL": in})
+}
+
+// relay is a module of n relays with an input header, in the order those
+// headers have: GND, IN1..INn, VCC.
+func relay(n int) *Part {
+	p := &Part{
+		ID:    fmt.Sprintf("relay-%d", n),
+		Title: fmt.Sprintf("Relay x%d", n),
+		Desc:  fmt.Sprintf("%d-channel relay module with optocoupled inputs, 5 V coils", n),
+		Left:  []string{"GND"},
+		Pins: map[string]Pin{
+			"VCC": {Desc: "5 V for the inputs, and for the coils while the JD-VCC jumper is fitted"},
+		},
+	}
+	for i := 1; i <= n; i++ {
+		in := fmt.Sprintf("IN%d", i)
+		p.Left = append(p.Left, in)
+		p.Pins[in] = Pin{Desc: fmt.Sprintf("relay %d, on while it is held low", i)}
+	}
+	p.Left = append(p.Left, "VCC")
+	// Each coil draws 72 mA at 5 V and pulls in from 3.75 V; the module's
+	// draw is with every relay on, which is when it matters.
+	return typed(p, map[schematic.PinType][]string{
+		schematic.PowerIn: {"GND", "VCC"},
+		schematic.Input:   p.Left[1 : n+1],
+	}, map[string]schematic.Level{
+		"VCC": {VMin: 3.75, VMax: 6, MA: 72 * float64(n)},
+	})
+}
+
+// typed gives each of the part's pins listed under a type that type, and each
+// pin in levels its level, keeping what else Pins says about it.
+func typed(p *Part, types map[schematic.PinType][]string, levels map[string]schematic.Level) *Part {
+	if p.Pins == nil {
+		p.Pins = map[string]Pin{}
+	}
+	for t, pins := range types {
+		for _, n := range pins {
+			pin := p.Pins[n]
+			pin.Type = t
+			p.Pins[n] = pin
+		}
+	}
+	for n, l := range levels {
+		pin := p.Pins[n]
+		pin.Level = l
+		p.Pins[n] = pin
+	}
+	return p
+}
@@ -0,0 +1,65 @@
+package parts
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"go.yaml.in/yaml/v3"
+)
+
+// file is a part file: a list of parts, under the same keys as Part's fields.
+//
+//	parts:
+//	  - id: bme280
+//	    title: BME280
+//	    desc: temperature, humidity and pressure, at I2C address 0x76
+//	    left: [VIN, GND, SCL, SDA]
+//	    pins:
+//	      VIN: {desc: 3.3 V to 5 V, regulated on the module, type: power_in}
+type file struct {
+	Parts []*Part `json:"parts" yaml:"parts" toml:"parts"`
+}
+
+// Load reads a part file, in the format its extension names: .yaml or .yml,
+// .json or .toml, as for a schematic file. The parts are checked as Register
+// checks them, but not registered.
+func Load(name string, data []byte) ([]*Part, error) {
+	var f file
+	switch ext := strings.ToLower(filepath.Ext(name)); ext {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(&f); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&f); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+	case ".toml":
+		md, err := toml.Decode(string(data), &f)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		if keys := md.Undecoded(); len(keys) > 0 {
+			return nil, fmt.Errorf("%s: unknown key %s", name, keys[0])
+		}
+	default:
+		return nil, fmt.Errorf("%s: a part file is .yaml, .json or .toml, not %q", name, ext)
+	}
+	for i, p := range f.Parts {
+		if p == nil {
+			return nil, fmt.Errorf("%s: part %d is empty", name, i)
+		}
+		if err := p.check(); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return f.Parts, nil
+}
@@ -0,0 +1,195 @@
+// Package parts is a library of the boards schematics are built from, so that a
+// Pico's forty pin names, the GND1..GND8 needed to tell its grounds apart, and
+// the sixteen pins of an HD44780 are written down once, checked once, and not
+// re-typed into every schematic that uses them.
+//
+//	pico, err := parts.New("pico", "pico")
+//	lcd, err := parts.New("hd44780", "lcd")
+//
+// A part carries more than its pin names: what is printed beside each pin, how
+// the datasheet numbers them, and, per pin, what it is for. The built-in parts
+// are the ones the firmwares in this repository are wired to and their common
+// substitutes; others are read from part files with Load and added with
+// Register.
+package parts
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"sync"
+
+	"github.com/0magnet/tinygo-stuff/schematic"
+)
+
+// Part is a kind of board: everything about it except where a schematic puts
+// it and what it is called there.
+type Part struct {
+	ID    string   `json:"id" yaml:"id" toml:"id"`                                        // what New takes, e.g. "pico"
+	Title string   `json:"title,omitempty" yaml:"title,omitempty" toml:"title,omitempty"` // the name of a board made from it
+	Desc  string   `json:"desc,omitempty" yaml:"desc,omitempty" toml:"desc,omitempty"`
+	Left  []string `json:"left,omitempty" yaml:"left,omitempty" toml:"left,omitempty"`
+	Right []string `json:"right,omitempty" yaml:"right,omitempty" toml:"right,omitempty"`
+
+	// Labels, FirstPin and Passive become the board's; see
+	// schematic.Board.
+	Labels   map[string]string `json:"labels,omitempty" yaml:"labels,omitempty" toml:"labels,omitempty"`
+	FirstPin int               `json:"first_pin,omitempty" yaml:"first_pin,omitempty" toml:"first_pin,omitzero"`
+	Passive  bool              `json:"passive,omitempty" yaml:"passive,omitempty" toml:"passive,omitempty"`
+
+	// Pins says what each pin is, by name. A pin need not be in it.
+	Pins map[string]Pin `json:"pins,omitempty" yaml:"pins,omitempty" toml:"pins,omitempty"`
+}
+
+// Pin is what a part knows about one of its pins.
+type Pin struct {
+	Desc string            `json:"desc,omitempty" yaml:"desc,omitempty" toml:"desc,omitempty"` // what it is for, e.g. "backlight anode"
+	Func []string          `json:"func,omitempty" yaml:"func,omitempty" toml:"func,omitempty"` // what else it can be, e.g. "I2C0 SDA"
+	Type schematic.PinType `json:"type,omitempty" yaml:"type,omitempty" toml:"type,omitempty"` // what it does electrically, for ERC
+
+	// Its voltages and current, from the datasheet, beside the other keys:
+	// VCC: {desc: 5 V, type: power_in, v_min: 4.5, ma: 1.5}.
+	schematic.Level `yaml:",inline"`
+}
+
+// Board makes a board of the part, named name. It is the board's own: the
+// pin lists and labels are copies, so a schematic can change them without
+// changing the part.
+func (p *Part) Board(name string) *schematic.Board {
+	b := &schematic.Board{
+		Name:     name,
+		Title:    p.Title,
+		Part:     p.ID,
+		Left:     slices.Clone(p.Left),
+		Right:    slices.Clone(p.Right),
+		Labels:   maps.Clone(p.Labels),
+		FirstPin: p.FirstPin,
+		Passive:  p.Passive,
+	}
+	for n, pin := range p.Pins {
+		if pin.Type != schematic.Untyped {
+			if b.Types == nil {
+				b.Types = map[string]schematic.PinType{}
+			}
+			b.Types[n] = pin.Type
+		}
+		if pin.Level != (schematic.Level{}) {
+			if b.Levels == nil {
+				b.Levels = map[string]schematic.Level{}
+			}
+			b.Levels[n] = pin.Level
+		}
+	}
+	return b
+}
+
+// pins names the pins of the part that are not gaps, down the left column and
+// then down the right.
+func (p *Part) pins() []string {
+	var names []string
+	for _, col := range [][]string{p.Left, p.Right} {
+		for _, n := range col {
+			if n != schematic.Gap {
+				names = append(names, n)
+			}
+		}
+	}
+	return names
+}
+
+// check is what Register refuses: a part that would make a board Validate
+// rejects, or that describes pins it does not have.
+func (p *Part) check() error {
+	if p.ID == "" {
+		return fmt.Errorf("a part has no id")
+	}
+	if len(p.Left) == 0 && len(p.Right) == 0 {
+		return fmt.Errorf("part %q has no pins", p.ID)
+	}
+	seen := map[string]bool{}
+	for _, n := range p.pins() {
+		if seen[n] {
+			return fmt.Errorf("part %q has two pins named %q", p.ID, n)
+		}
+		seen[n] = true
+	}
+	described := slices.Concat(slices.Sorted(maps.Keys(p.Labels)), slices.Sorted(maps.Keys(p.Pins)))
+	for _, n := range described {
+		if !seen[n] {
+			return fmt.Errorf("part %q: %q is not one of its pins", p.ID, n)
+		}
+	}
+	for _, n := range slices.Sorted(maps.Keys(p.Pins)) {
+		if t := p.Pins[n].Type; t != schematic.Untyped && !slices.Contains(schematic.PinTypes, t) {
+			return fmt.Errorf("part %q: pin %q has no type %q", p.ID, n, t)
+		}
+	}
+	return nil
+}
+
+var (
+	mu       sync.RWMutex
+	registry = map[string]*Part{}
+)
+
+// Register adds parts to the library. An id already taken is an error, built-in
+// or not: a part file that quietly redefined the Pico would redraw every
+// schematic that uses it.
+func Register(ps ...*Part) error {
+	mu.Lock()
+	defer mu.Unlock()
+	adding := map[string]bool{}
+	for _, p := range ps {
+		if err := p.check(); err != nil {
+			return err
+		}
+		if _, ok := registry[p.ID]; ok || adding[p.ID] {
+			return fmt.Errorf("there is already a part %q", p.ID)
+		}
+		adding[p.ID] = true
+	}
+	for _, p := range ps {
+		registry[p.ID] = p
+	}
+	return nil
+}
+
+// Lookup returns the part with the id, if there is one.
+func Lookup(id string) (*Part, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	p, ok := registry[id]
+	return p, ok
+}
+
+// IDs lists the parts in the library, sorted.
+func IDs() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	ids := make([]string, 0, len(registry))
+	for id := range registry {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// New makes a board of the part with the id, named name. It is a
+// schematic.Library, for schematic.LoadWith.
+func New(id, name string) (*schematic.Board, error) {
+	p, ok := Lookup(id)
+	if !ok {
+		return nil, fmt.Errorf("no part %q; the library has %v", id, IDs())
+	}
+	return p.Board(name), nil
+}
+
+// MustNew is New for the built-in parts, which cannot be missing.
+func MustNew(id, name string) *schematic.Board {
+	b, err := New(id, name)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
@@ -0,0 +1,249 @@
+package parts
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/0magnet/tinygo-stuff/schematic"
+)
+
+// Every built-in part has to make a board Validate accepts, or a schematic
+// that uses it is wrong before anything is wired.
+func TestEveryPartMakesAValidBoard(t *testing.T) {
+	for _, id := range IDs() {
+		b, err := New(id, "x")
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := &schematic.Schematic{Boards: []*schematic.Board{b}}
+		if errs := s.Validate(); len(errs) > 0 {
+			t.Errorf("%s: %v", id, errs)
+		}
+	}
+}
+
+func TestTheLibraryHasTheWellKnownParts(t *testing.T) {
+	for _, id := range []string{
+		"pico", "pico-w", "pico2", "hd44780", "hd44780-14",
+		"ds1307", "ds3231", "pcf8574", "relay-4", "relay-8",
+	} {
+		if _, ok := Lookup(id); !ok {
+			t.Errorf("no part %q", id)
+		}
+	}
+}
+
+// The Pico's header, as its datasheet numbers it.
+func TestThePicoIsNumberedAsTheDatasheetNumbersIt(t *testing.T) {
+	p, _ := Lookup("pico")
+	for _, tc := range []struct {
+		pin  string
+		want int
+	}{
+		{"GP0", 1}, {"GND1", 3}, {"GP15", 20}, {"GP16", 21}, {"GND8", 23}, {"3V3", 36}, {"VBUS", 40},
+	} {
+		n := 0
+		if i := slices.Index(p.Left, tc.pin); i >= 0 {
+			n = p.FirstPin + i
+		} else if i := slices.Index(p.Right, tc.pin); i >= 0 {
+			n = p.FirstPin + len(p.Left) + len(p.Right) - 1 - i
+		}
+		if n != tc.want {
+			t.Errorf("%s is pin %d, want %d", tc.pin, n, tc.want)
+		}
+	}
+	for i := 1; i <= 8; i++ {
+		if l := p.Labels[fmt.Sprintf("GND%d", i)]; l != "GND" {
+			t.Errorf("GND%d is labelled %q", i, l)
+		}
+	}
+	if f := p.Pins["GP4"].Func; !slices.Contains(f, "I2C0 SDA") || !slices.Contains(f, "UART1 TX") || !slices.Contains(f, "PWM2 A") {
+		t.Errorf("GP4 can be %v", f)
+	}
+}
+
+// A board is the schematic's to change; changing it must not change the
+// next board made of the same part.
+func TestNewMakesABoardOfItsOwn(t *testing.T) {
+	a := MustNew("pico", "a")
+	a.Left[0] = "changed"
+	a.Labels["GND1"] = "changed"
+	b := MustNew("pico", "b")
+	if b.Left[0] != "GP0" || b.Labels["GND1"] != "GND" {
+		t.Errorf("changing one board changed the part: %v, %q", b.Left[:1], b.Labels["GND1"])
+	}
+	if b.Part != "pico" || b.Name != "b" {
+		t.Errorf("made %+v", b)
+	}
+}
+
+func TestNewNamesTheMissingPart(t *testing.T) {
+	if _, err := New("pico3", "x"); err == nil || !strings.Contains(err.Error(), `"pico3"`) {
+		t.Errorf("error %v", err)
+	}
+}
+
+func TestLoadReadsEachFormat(t *testing.T) {
+	for name, src := range map[string]string{
+		"p.yaml": "parts:\n  - id: bme\n    left: [VIN, GND, SCL, SDA]\n    pins:\n      VIN: {desc: supply, v_min: 1.7}\n",
+		"p.json": `{"parts": [{"id": "bme", "left": ["VIN", "GND", "SCL", "SDA"], "pins": {"VIN": {"desc": "supply", "v_min": 1.7}}}]}`,
+		"p.toml": "[[parts]]\nid = \"bme\"\nleft = [\"VIN\", \"GND\", \"SCL\", \"SDA\"]\n[parts.pins.VIN]\ndesc = \"supply\"\nv_min = 1.7\n",
+	} {
+		ps, err := Load(name, []byte(src))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if len(ps) != 1 || ps[0].ID != "bme" || len(ps[0].Left) != 4 || ps[0].Pins["VIN"].Desc != "supply" || ps[0].Pins["VIN"].VMin != 1.7 {
+			t.Errorf("%s: read %+v", name, ps[0])
+		}
+	}
+}
+
+func TestLoadRefusesAPartThatDescribesPinsItDoesNotHave(t *testing.T) {
+	for name, src := range map[string]string{
+		"labels.yaml": "parts:\n  - id: bme\n    left: [VIN]\n    labels: {VCC: VCC}\n",
+		"twice.yaml":  "parts:\n  - id: bme\n    left: [VIN, VIN]\n",
+		"none.yaml":   "parts:\n  - id: bme\n",
+		"key.yaml":    "parts:\n  - id: bme\n    left: [VIN]\n    colour: red\n",
+	} {
+		if _, err := Load(name, []byte(src)); err == nil {
+			t.Errorf("%s: loaded", name)
+		}
+	}
+}
+
+func TestRegisterRefusesATakenID(t *testing.T) {
+	if err := Register(&Part{ID: "pico", Left: []string{"A"}}); err == nil {
+		t.Error("redefined the Pico")
+	}
+	if err := Register(&Part{ID: "twin", Left: []string{"A"}}, &Part{ID: "twin", Left: []string{"B"}}); err == nil {
+		t.Error("registered two parts with one id")
+	}
+	if _, ok := Lookup("twin"); ok {
+		t.Error("a failed Register added a part")
+	}
+}
+
+// A schematic file's boards can name parts, and keep what they give
+// themselves over what the part has.
+func TestASchematicFileCanNameParts(t *testing.T) {
+	const src = `boards:
+  - name: pico
+    part: pico
+    col: 1
+  - name: rtc
+    part: ds3231
+    title: RTC
+    labels: {SQW: INT}
+wires:
+  - {from: pico.GP4, to: rtc.SDA, net: I2C}
+  - {from: pico.GP5, to: rtc.SCL, net: I2C}
+  - {from: pico.GND2, to: rtc.GND, net: GND}
+`
+	s, err := schematic.LoadWith("wiring.yaml", []byte(src), New)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pico, rtc := s.Boards[0], s.Boards[1]
+	if pico.Col != 1 || len(pico.Left) != 20 || pico.FirstPin != 1 {
+		t.Errorf("pico is %+v", pico)
+	}
+	if rtc.Title != "RTC" || rtc.Labels["SQW"] != "INT" || !slices.Contains(rtc.Left, "32K") {
+		t.Errorf("rtc is %+v", rtc)
+	}
+
+	if _, err := schematic.LoadWith("wiring.yaml", []byte("boards:\n  - {name: x, part: nope}\n"), New); err == nil ||
+		!strings.Contains(err.Error(), "wiring.yaml:2:") {
+		t.Errorf("error %v", err)
+	}
+	if _, err := schematic.Load("wiring.yaml", []byte(src)); err == nil {
+		t.Error("loaded parts without a library")
+	}
+}
+
+// The firmware's wiring, of built-in parts, passes ERC but for the pull-ups
+// the modules carry and the part cannot say they do, and for the DS1307 run
+// from 3.3 V, below its rating; a display with no supply does not.
+func TestTheBuiltInPartsAreTypedForERC(t *testing.T) {
+	pico, lcd, rtc := MustNew("pico", "pico"), MustNew("hd44780", "lcd"), MustNew("ds1307", "rtc")
+	s := &schematic.Schematic{
+		Boards: []*schematic.Board{pico, lcd, rtc},
+		Wires: []schematic.Wire{
+			{From: "pico.GP0", To: "rtc.SDA", Net: "I2C"},
+			{From: "pico.GP1", To: "rtc.SCL", Net: "I2C"},
+			{From: "pico.3V3", To: "rtc.VCC", Net: "PWR"},
+			{From: "pico.GND1", To: "rtc.GND", Net: "GND"},
+			{From: "pico.GND2", To: "lcd.GND", Net: "GND"},
+			{From: "pico.GND3", To: "lcd.RW", Net: "GND"},
+			{From: "pico.GP2", To: "lcd.CONT", Net: "CTL"},
+			{From: "pico.GP3", To: "lcd.RS", Net: "CTL"},
+			{From: "pico.GP4", To: "lcd.EN", Net: "CTL"},
+		},
+	}
+	if errs := s.Validate(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	vs, err := s.ERC()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, v := range vs {
+		got = append(got, fmt.Sprintf("%s %s", v.Rule, strings.Join(v.Pins, " ")))
+	}
+	want := []string{
+		"i2c-pull-up pico.GP0 rtc.SDA",
+		"i2c-pull-up pico.GP1 rtc.SCL",
+		"undervoltage pico.3V3 rtc.VCC",
+		"unconnected-power lcd.VCC",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("violations\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+// A Pico with a display on its right and a relay board and a clock stacked on
+// its left, declared as they come to hand: the clock below the relays it is
+// wired above, and both with their pins, as the library has them, facing away
+// from the Pico. Optimize turns them to face it and puts the clock on top, and
+// what crossings are left come of which Pico pins the wiring uses: 13 is what
+// it gets to, and a change to the search must not do worse.
+func TestOptimizeUntanglesTheBuiltInParts(t *testing.T) {
+	pico, lcd, relay, rtc := MustNew("pico", "pico"), MustNew("hd44780", "lcd"), MustNew("relay-4", "relay"), MustNew("ds1307", "rtc")
+	pico.Col, lcd.Col = 1, 2
+	s := &schematic.Schematic{
+		Boards: []*schematic.Board{relay, rtc, pico, lcd},
+		Wires: []schematic.Wire{
+			{From: "pico.GP0", To: "rtc.SDA", Net: "I2C"},
+			{From: "pico.GP1", To: "rtc.SCL", Net: "I2C"},
+			{From: "pico.GND1", To: "rtc.GND", Net: "GND"},
+			{From: "pico.GND3", To: "relay.GND", Net: "GND"},
+			{From: "pico.GP10", To: "relay.IN1", Net: "CTL"},
+			{From: "pico.GP11", To: "relay.IN2", Net: "CTL"},
+			{From: "pico.GP12", To: "relay.IN3", Net: "CTL"},
+			{From: "pico.GP13", To: "relay.IN4", Net: "CTL"},
+			{From: "pico.GND8", To: "lcd.GND", Net: "GND"},
+			{From: "pico.VBUS", To: "lcd.VCC", Net: "PWR"},
+			{From: "pico.GP22", To: "lcd.RS", Net: "CTL"},
+			{From: "pico.GP21", To: "lcd.EN", Net: "CTL"},
+			{From: "pico.GP20", To: "lcd.BIT4", Net: "DAT"},
+			{From: "pico.GP19", To: "lcd.BIT5", Net: "DAT"},
+			{From: "pico.GP18", To: "lcd.BIT6", Net: "DAT"},
+			{From: "pico.GP17", To: "lcd.BIT7", Net: "DAT"},
+		},
+	}
+	before, after, err := s.Optimize(10 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !relay.Mirrored || !rtc.Mirrored || s.Boards[0] != rtc {
+		t.Errorf("boards %s, %s; relay mirrored %v, rtc %v", s.Boards[0].Name, s.Boards[1].Name, relay.Mirrored, rtc.Mirrored)
+	}
+	if after.Wraps > 0 || after.Detours > 0 || after.Crossings > 13 {
+		t.Errorf("optimized from %v to %v", before, after)
+	}
+}
@@ -0,0 +1,212 @@
+package schematic
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RenderDOT writes the connections as a Graphviz graph, for `dot -Tsvg`.
+//
+// The drawings are fixed layouts: every pin of every board, every wire in a
+// lane of its own. That is what wiring needs, but in documentation, or to see
+// what a large build is made of, a graph laid out by Graphviz reads better.
+// Each board is a record with a port per pin, its pins down either side as on
+// the drawing, and boards sharing a Col are ranked together. Each wire is an
+// edge in the color the drawing gives it.
+//
+// With bus set, the wires of one net between the same two boards are drawn as
+// a single edge labelled with the net and how many wires it stands for — eight
+// data lines become one "DAT ×8".
+func (s *Schematic) RenderDOT(bus bool) (string, error) {
+	edges, err := s.graphEdges(bus)
+	if err != nil {
+		return "", err
+	}
+	th := s.theme()
+
+	var b strings.Builder
+	b.WriteString("graph schematic {\n")
+	fmt.Fprintf(&b, "  rankdir=LR; bgcolor=%q; splines=true; nodesep=0.3;\n", th.Background)
+	fmt.Fprintf(&b, "  node [shape=record, color=%q, fontcolor=%q, fontname=\"monospace\"];\n", th.Foreground, th.Foreground)
+	fmt.Fprintf(&b, "  edge [fontcolor=%q, fontname=\"monospace\"];\n", th.Muted)
+
+	var cols []int
+	byCol := map[int][]*Board{}
+	for _, bd := range s.Boards {
+		if _, ok := byCol[bd.Col]; !ok {
+			cols = append(cols, bd.Col)
+		}
+		byCol[bd.Col] = append(byCol[bd.Col], bd)
+	}
+	for _, c := range cols {
+		fmt.Fprintf(&b, "  subgraph col%d {\n    rank=same;\n", c)
+		for _, bd := range byCol[c] {
+			fields := []string{dotEscape(bd.title())}
+			if len(bd.Left) > 0 {
+				fields = append([]string{dotColumn(bd, bd.Left)}, fields...)
+			}
+			if len(bd.Right) > 0 {
+				fields = append(fields, dotColumn(bd, bd.Right))
+			}
+			fmt.Fprintf(&b, "    %q [label=\"{%s}\"];\n", bd.Name, strings.Join(fields, "|"))
+		}
+		b.WriteString("  }\n")
+	}
+
+	for _, e := range edges {
+		if e.wires == 1 {
+			fmt.Fprintf(&b, "  %q:%q -- %q:%q [color=%q];\n", e.from, e.fromPin, e.to, e.toPin, e.color)
+			continue
+		}
+		fmt.Fprintf(&b, "  %q -- %q [color=%q, penwidth=%d, label=%q];\n", e.from, e.to, e.color, min(e.wires, 4), e.label())
+	}
+	b.WriteString("}\n")
+	return b.String(), nil
+}
+
+// RenderMermaid writes the connections as a Mermaid flowchart, which GitHub
+// and most documentation sites draw from a ```mermaid block with no tool to
+// install. Mermaid has no ports, so each board is a subgraph of the pins that
+// are wired — all forty of a Pico's would bury the few that matter — and each
+// wire an edge between two of them, in its color. Bus is as for RenderDOT.
+func (s *Schematic) RenderMermaid(bus bool) (string, error) {
+	edges, err := s.graphEdges(bus)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	theme := "dark"
+	if s.theme().Name == Light.Name {
+		theme = "default"
+	}
+	fmt.Fprintf(&b, "%%%%{init: {\"theme\": %q}}%%%%\n", theme)
+	b.WriteString("flowchart LR\n")
+
+	id := map[string]string{} // "board.pin" → node id
+	for i, bd := range s.Boards {
+		id[bd.Name] = fmt.Sprintf("b%d", i)
+		var pins []string
+		for _, col := range [][]string{bd.Left, bd.Right} {
+			for _, p := range col {
+				if p != Gap && edges.uses(bd.Name+"."+p) {
+					pins = append(pins, p)
+				}
+			}
+		}
+		// A board whose wires are all in buses has no pins to show, and
+		// is a node rather than an empty box.
+		if len(pins) == 0 {
+			fmt.Fprintf(&b, "  %s[\"%s\"]\n", id[bd.Name], mermaidEscape(bd.title()))
+			continue
+		}
+		fmt.Fprintf(&b, "  subgraph %s[\"%s\"]\n", id[bd.Name], mermaidEscape(bd.title()))
+		for j, p := range pins {
+			ref := bd.Name + "." + p
+			id[ref] = fmt.Sprintf("b%dp%d", i, j)
+			fmt.Fprintf(&b, "    %s[\"%s\"]\n", id[ref], mermaidEscape(bd.label(p)))
+		}
+		b.WriteString("  end\n")
+	}
+
+	for _, e := range edges {
+		if e.wires == 1 {
+			fmt.Fprintf(&b, "  %s --- %s\n", id[e.from+"."+e.fromPin], id[e.to+"."+e.toPin])
+			continue
+		}
+		fmt.Fprintf(&b, "  %s ---|\"%s\"| %s\n", id[e.from], mermaidEscape(e.label()), id[e.to])
+	}
+	// Links are styled by their index, in the order they were written.
+	for i, e := range edges {
+		fmt.Fprintf(&b, "  linkStyle %d stroke:%s,stroke-width:%dpx\n", i, e.color, min(e.wires, 4))
+	}
+	return b.String(), nil
+}
+
+// graphEdge is one edge of a graph rendering: a wire, or with bus, the wires
+// of a net between two boards.
+type graphEdge struct {
+	from, fromPin string
+	to, toPin     string
+	net           string
+	color         string // the first wire's
+	wires         int
+}
+
+func (e graphEdge) label() string {
+	return fmt.Sprintf("%s ×%d", e.net, e.wires)
+}
+
+type graphEdges []graphEdge
+
+// uses reports whether an edge ends on the pin ref.
+func (es graphEdges) uses(ref string) bool {
+	for _, e := range es {
+		if e.wires == 1 && (e.from+"."+e.fromPin == ref || e.to+"."+e.toPin == ref) {
+			return true
+		}
+	}
+	return false
+}
+
+// graphEdges is the wires as edges, in order, and with bus, each net's wires
+// between two boards merged into the first of them. The boards of a bus edge
+// are in the order its first wire names them, so the graph reads the way the
+// wiring was written.
+func (s *Schematic) graphEdges(bus bool) (graphEdges, error) {
+	styles := s.Styles()
+	var edges graphEdges
+	at := map[string]int{} // net and boards → index in edges
+	for i, w := range s.Wires {
+		fb, fp, err := splitRef(w.From)
+		if err != nil {
+			return nil, fmt.Errorf("wire %d: %w", i, err)
+		}
+		tb, tp, err := splitRef(w.To)
+		if err != nil {
+			return nil, fmt.Errorf("wire %d: %w", i, err)
+		}
+		for _, end := range [][2]string{{fb, fp}, {tb, tp}} {
+			if bd := s.board(end[0]); bd == nil || !bd.hasPin(end[1]) {
+				return nil, fmt.Errorf("wire %d: no pin %s.%s", i, end[0], end[1])
+			}
+		}
+		if bus {
+			a, z := fb, tb
+			if a > z {
+				a, z = z, a
+			}
+			key := w.Net + "\x00" + a + "\x00" + z
+			if j, ok := at[key]; ok {
+				edges[j].wires++
+				continue
+			}
+			at[key] = len(edges)
+		}
+		edges = append(edges, graphEdge{fb, fp, tb, tp, w.Net, styles[i].Hex, 1})
+	}
+	return edges, nil
+}
+
+// dotColumn is one column of a board's record: a field per row, with a port
+// named for the pin, and an empty field for a Gap.
+func dotColumn(bd *Board, col []string) string {
+	fields := make([]string, len(col))
+	for i, p := range col {
+		if p != Gap {
+			fields[i] = "<" + dotEscape(p) + "> " + dotEscape(bd.label(p))
+		}
+	}
+	return "{" + strings.Join(fields, "|") + "}"
+}
+
+// dotEscape escapes what a record label gives a meaning to.
+func dotEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "{", `\{`, "}", `\}`, "|", `\|`, "<", `\<`, ">", `\>`).Replace(s)
+}
+
+// mermaidEscape makes s safe inside a quoted Mermaid label, where a quote
+// would end it.
+func mermaidEscape(s string) string {
+	return strings.ReplaceAll(s, `"`, "#quot;")
+}
@@ -0,0 +1,217 @@
+package schematic
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"strconv"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// RenderPNG draws the schematic as RenderSVG does, into pixels, and encodes it
+// as a PNG scale times the SVG's size.
+//
+// The SVG is exact, but an SVG is not an image until something renders it, and
+// for a README that meant a browser or an HTML-to-image step in CI — the step
+// whose font rounding RenderSVG exists to get away from. This needs nothing but
+// the standard library's image packages and a bitmap font compiled into the
+// binary, so the same layout comes out pixel for pixel wherever it is run: the
+// geometry is RenderSVG's, every line lands on whole pixels, and the drawing is
+// made once at one pixel per SVG unit and then scaled up by whole pixels, which
+// keeps the bitmap font's strokes as sharp as the wires'.
+//
+// The font is X11's 7×13 fixed, which has ASCII and nothing else; any other
+// character in a pin's label or a title is drawn as a box.
+func (s *Schematic) RenderPNG(scale int) ([]byte, error) {
+	if scale < 1 {
+		return nil, fmt.Errorf("png scale %d: want 1 or more", scale)
+	}
+	dr, err := s.draw()
+	if err != nil {
+		return nil, err
+	}
+	th := s.theme()
+	r := newRaster(dr.w*svgCellW+2*svgPad, dr.h*svgCellH+2*svgPad, th)
+
+	for _, board := range s.Boards {
+		r.board(board, th)
+	}
+	// The casing is the SVG's: a crossing reads as one wire ducking behind
+	// another rather than as a junction.
+	styles := s.Styles()
+	for _, rt := range dr.rs {
+		r.route(rt, hexColor(th.Background), 4)
+		r.route(rt, hexColor(styleFor(styles, s.Wires, rt.wire).Hex), 1)
+	}
+	for _, l := range dr.labels {
+		pngLabel(r, l, hexColor(styleFor(styles, s.Wires, l.wire).Hex), th)
+	}
+
+	var b bytes.Buffer
+	if err := png.Encode(&b, r.scaled(scale)); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// raster is the image RenderPNG draws on, with the few shapes it draws: lines
+// across or along the grid, the odd diagonal of a label's flag, and text.
+type raster struct {
+	img  *image.RGBA
+	face font.Face
+}
+
+func newRaster(w, h int, th *Theme) *raster {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(img, img.Bounds(), image.NewUniform(hexColor(th.Background)), image.Point{}, draw.Src)
+	return &raster{img: img, face: basicfont.Face7x13}
+}
+
+// hexColor reads a Theme's #rrggbb. A color it cannot read comes out as the
+// grey styleFor falls back to, rather than as an error nothing could act on:
+// the themes are the package's own.
+func hexColor(hex string) color.RGBA {
+	c := color.RGBA{A: 0xff}
+	if _, err := fmt.Sscanf(hex, "#%02x%02x%02x", &c.R, &c.G, &c.B); err != nil || len(hex) != 7 {
+		return color.RGBA{R: 0x88, G: 0x88, B: 0x88, A: 0xff}
+	}
+	return c
+}
+
+// fill paints the rectangle from (x0, y0) to (x1, y1), both corners included,
+// whichever way round they are given.
+func (r *raster) fill(x0, y0, x1, y1 int, c color.RGBA) {
+	rect := image.Rect(min(x0, x1), min(y0, y1), max(x0, x1)+1, max(y0, y1)+1)
+	draw.Draw(r.img, rect, image.NewUniform(c), image.Point{}, draw.Src)
+}
+
+// line draws from (x0, y0) to (x1, y1), width pixels wide. A line across or
+// along the grid is a filled rectangle, reaching width/2 past each end the way
+// a round cap does; anything else is stepped out a pixel at a time.
+func (r *raster) line(x0, y0, x1, y1, width int, c color.RGBA) {
+	h := width / 2
+	if x0 == x1 || y0 == y1 {
+		r.fill(min(x0, x1)-h, min(y0, y1)-h, max(x0, x1)-h+width-1, max(y0, y1)-h+width-1, c)
+		return
+	}
+	dx, dy := abs(x1-x0), -abs(y1-y0)
+	sx, sy := sign(x1-x0), sign(y1-y0)
+	for e := dx + dy; ; {
+		r.fill(x0-h, y0-h, x0-h+width-1, y0-h+width-1, c)
+		if x0 == x1 && y0 == y1 {
+			return
+		}
+		if e2 := 2 * e; e2 >= dy {
+			e += dy
+			x0 += sx
+		}
+		if e2 := 2 * e; e2 <= dx {
+			e += dx
+			y0 += sy
+		}
+	}
+}
+
+// text draws s with its baseline at y, starting at x, or ending there if end.
+func (r *raster) text(x, y int, s string, c color.RGBA, end bool) {
+	if end {
+		x -= font.MeasureString(r.face, s).Ceil()
+	}
+	d := font.Drawer{Dst: r.img, Src: image.NewUniform(c), Face: r.face, Dot: fixed.P(x, y)}
+	d.DrawString(s)
+}
+
+// middle is the baseline that centers a line of text on y, which is what the
+// SVG's dominant-baseline="middle" does.
+func (r *raster) middle(y int) int {
+	m := r.face.Metrics()
+	return y + (m.Ascent-m.Descent).Ceil()/2
+}
+
+// board draws a board as svgBoard does.
+func (r *raster) board(bd *Board, th *Theme) {
+	x := svgPad + bd.X*svgCellW
+	y := svgPad + bd.Y*svgCellH
+	w := bd.W * svgCellW
+	h := bd.H * svgCellH
+	fg, muted := hexColor(th.Foreground), hexColor(th.Muted)
+
+	r.line(x, y, x+w, y, 1, fg)
+	r.line(x, y+h, x+w, y+h, 1, fg)
+	r.line(x, y, x, y+h, 1, fg)
+	r.line(x+w, y, x+w, y+h, 1, fg)
+	title := bd.title()
+	r.text(x+w/2-font.MeasureString(r.face, title).Ceil()/2, y+h-4, title, muted, false)
+
+	for i, p := range bd.Left {
+		if p == Gap {
+			continue
+		}
+		ty := cy(bd.Y + bd.pinRowY(i))
+		r.line(x-3, ty, x+3, ty, 1, fg)
+		r.text(x+5, r.middle(ty), bd.socket(p)+bd.label(p), fg, false)
+	}
+	for i, p := range bd.Right {
+		if p == Gap {
+			continue
+		}
+		ty := cy(bd.Y + bd.pinRowY(i))
+		r.line(x+w-3, ty, x+w+3, ty, 1, fg)
+		r.text(x+w-5, r.middle(ty), bd.label(p)+bd.socket(p), fg, true)
+	}
+
+	if bd.FirstPin != 0 {
+		if n := len(bd.Left); n > 0 {
+			r.text(x+4, y+svgCellH-2, strconv.Itoa(bd.pinNumber(Left, 0)), muted, false)
+			r.text(x+4, y+h-svgCellH-2, strconv.Itoa(bd.pinNumber(Left, n-1)), muted, false)
+		}
+		if n := len(bd.Right); n > 0 {
+			r.text(x+w-4, y+svgCellH-2, strconv.Itoa(bd.pinNumber(Right, 0)), muted, true)
+			r.text(x+w-4, y+h-svgCellH-2, strconv.Itoa(bd.pinNumber(Right, n-1)), muted, true)
+		}
+	}
+}
+
+// route draws the wire through the same points svgRoute joins.
+func (r *raster) route(rt route, c color.RGBA, width int) {
+	for i := 1; i < len(rt.pts); i++ {
+		a, b := rt.pts[i-1], rt.pts[i]
+		r.line(cx(a.X), cy(a.Y), cx(b.X), cy(b.Y), width, c)
+	}
+}
+
+// scaled is the image made scale times bigger a whole pixel at a time, so
+// nothing is blurred.
+func (r *raster) scaled(scale int) image.Image {
+	if scale == 1 {
+		return r.img
+	}
+	b := r.img.Bounds()
+	out := image.NewRGBA(image.Rect(0, 0, b.Dx()*scale, b.Dy()*scale))
+	for y := range out.Bounds().Dy() {
+		src := r.img.Pix[(y/scale)*r.img.Stride:]
+		dst := out.Pix[y*out.Stride:]
+		for x := range out.Bounds().Dx() {
+			copy(dst[4*x:4*x+4], src[4*(x/scale):])
+		}
+	}
+	return out
+}
+
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
+
+func abs(n int) int { return n * sign(n) }
@@ -23,38 +23,17 @@ const (
 // HTML-to-image step with wires that visibly drift, worse the further right you
 // look. Here a wire is a polyline between two computed points, so it is exactly
 // straight no matter what renders it.
+//
+// Net labels are drawn as RenderText draws them, with real symbols.
 func (s *Schematic) RenderSVG() (string, error) {
-	pos, err := s.Layout()
-	if err != nil {
-		return "", err
-	}
-	rs, err := s.routes(pos)
+	dr, err := s.draw()
 	if err != nil {
 		return "", err
 	}
-
-	cols, rows := 0, 0
-	for _, b := range s.Boards {
-		if r := b.X + b.W; r > cols {
-			cols = r
-		}
-		if d := b.Y + b.H; d > rows {
-			rows = d
-		}
-	}
 	// The routing decides the extent as much as the boards do: a wire that
 	// wraps around the right-hand board runs past the rightmost box, and
 	// sizing the canvas from the boards alone clips it off the edge.
-	for _, r := range rs {
-		for _, p := range r.pts {
-			if p.X+1 > cols {
-				cols = p.X + 1
-			}
-			if p.Y+1 > rows {
-				rows = p.Y + 1
-			}
-		}
-	}
+	cols, rows := dr.w, dr.h
 	w := cols*svgCellW + 2*svgPad
 	h := rows*svgCellH + 2*svgPad
 
@@ -73,10 +52,13 @@ func (s *Schematic) RenderSVG() (string, error) {
 	// junction. Without it two lines simply overlap and the reader cannot
 	// tell a crossing from a connection.
 	styles := s.Styles()
-	for _, r := range rs {
+	for _, r := range dr.rs {
 		svgRoute(&b, r, th.Background, 4)
 		svgRoute(&b, r, styleFor(styles, s.Wires, r.wire).Hex, 0)
 	}
+	for _, l := range dr.labels {
+		svgLabel(&b, l, styleFor(styles, s.Wires, l.wire).Hex, th)
+	}
 
 	b.WriteString(`</g></svg>`)
 	return b.String(), nil
@@ -78,52 +78,27 @@ func (c *canvas) String(colorize bool) string {
 // together so this renderer and the SVG one cannot drift apart.
 
 // RenderText draws the schematic as text. Set colorize to emit ANSI color.
+//
+// With NetLabels, the wires it chooses are left out of the layout and drawn as
+// a label at each end instead.
 func (s *Schematic) RenderText(colorize bool) (string, error) {
-	pos, err := s.Layout()
-	if err != nil {
-		return "", err
-	}
-	rs, err := s.routes(pos)
+	dr, err := s.draw()
 	if err != nil {
 		return "", err
 	}
-
-	w, h := 0, 0
-	for _, b := range s.Boards {
-		if r := b.X + b.W; r > w {
-			w = r
-		}
-		if d := b.Y + b.H; d > h {
-			h = d
-		}
-	}
-	// Size to what the routing actually used, not to the worst case: a
-	// single detour must not leave a page of blank rows under the drawing.
-	//
-	// Both axes, not just the height. A wire that wraps around the right-hand
-	// board runs past the rightmost box, and the canvas silently discards
-	// out-of-bounds writes — so sizing from the boards alone truncates that
-	// wire into a drawing that still looks finished.
-	for _, r := range rs {
-		for _, p := range r.pts {
-			if p.X+1 > w {
-				w = p.X + 1
-			}
-			if p.Y+1 > h {
-				h = p.Y + 1
-			}
-		}
-	}
-	c := newCanvas(w+2, h+1)
+	c := newCanvas(dr.w+2, dr.h+1)
 
 	for _, b := range s.Boards {
 		drawBoard(c, b)
 	}
 
 	styles := s.Styles()
-	for _, r := range rs {
+	for _, r := range dr.rs {
 		drawRoute(c, r, styleFor(styles, s.Wires, r.wire).ANSI)
 	}
+	for _, l := range dr.labels {
+		drawLabel(c, l, styleFor(styles, s.Wires, l.wire).ANSI)
+	}
 	return c.String(colorize), nil
 }
 
@@ -1,9 +1,14 @@
 package schematic
 
 import (
+	"bytes"
 	"fmt"
+	"image/png"
+	"slices"
+	"sort"
 	"strings"
 	"testing"
+	"time"
 )
 
 // twoBoards is a small schematic with the shapes that matter: a numbered board
@@ -177,6 +182,25 @@ func TestPinNumbersAreAContiguousRun(t *testing.T) {
 	}
 }
 
+// A board with one column is a row of header pins, and counts from the top
+// whichever side of the board its pins are drawn on.
+func TestPinNumberRunsDownASingleColumnOnEitherSide(t *testing.T) {
+	for _, b := range []*Board{
+		{Name: "l", Left: []string{"A", "B", "C"}, FirstPin: 1},
+		{Name: "r", Right: []string{"A", "B", "C"}, FirstPin: 1},
+	} {
+		side := Left
+		if len(b.Left) == 0 {
+			side = Right
+		}
+		for i, want := range []int{1, 2, 3} {
+			if got := b.pinNumber(side, i); got != want {
+				t.Errorf("%s: pinNumber(%v, %d) = %d, want %d", b.Name, side, i, got, want)
+			}
+		}
+	}
+}
+
 func TestPinNumberIsZeroWhenTheBoardIsUnnumbered(t *testing.T) {
 	b := twoBoards().Boards[1] // no FirstPin
 	if got := b.pinNumber(Left, 0); got != 0 {
@@ -365,6 +389,54 @@ func TestRenderSVGIsWellFormed(t *testing.T) {
 	}
 }
 
+// The PNG is the SVG's layout in pixels, so it is the SVG's size, scaled, with
+// the page in the background and each wire in its own color along its route.
+func TestRenderPNGDrawsTheSVGsLayout(t *testing.T) {
+	s := twoBoards()
+	svg, err := s.RenderSVG()
+	if err != nil {
+		t.Fatalf("RenderSVG: %v", err)
+	}
+	var w, h int
+	if _, err := fmt.Sscanf(svg, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d"`, &w, &h); err != nil {
+		t.Fatalf("reading the SVG's size: %v", err)
+	}
+	dr, err := s.draw()
+	if err != nil {
+		t.Fatal(err)
+	}
+	styles := s.Styles()
+	for _, scale := range []int{1, 3} {
+		out, err := s.RenderPNG(scale)
+		if err != nil {
+			t.Fatalf("RenderPNG(%d): %v", scale, err)
+		}
+		img, err := png.Decode(bytes.NewReader(out))
+		if err != nil {
+			t.Fatalf("RenderPNG(%d) is not a PNG: %v", scale, err)
+		}
+		if b := img.Bounds(); b.Dx() != w*scale || b.Dy() != h*scale {
+			t.Errorf("at %d it is %d×%d, want %d×%d", scale, b.Dx(), b.Dy(), w*scale, h*scale)
+		}
+		if c := img.At(0, 0); c != hexColor(Dark.Background) {
+			t.Errorf("at %d the page is %v", scale, c)
+		}
+		for _, r := range dr.rs {
+			// The middle of the wire's first run, clear of the pins and
+			// of any other wire's casing at a corner.
+			a, b := r.pts[0], r.pts[1]
+			x, y := (cx(a.X)+cx(b.X))/2, (cy(a.Y)+cy(b.Y))/2
+			want := hexColor(styleFor(styles, s.Wires, r.wire).Hex)
+			if c := img.At(x*scale, y*scale); c != want {
+				t.Errorf("at %d the %s wire is %v at (%d, %d), want %v", scale, r.wire.Net, c, x, y, want)
+			}
+		}
+	}
+	if _, err := s.RenderPNG(0); err == nil {
+		t.Error("drew at scale 0")
+	}
+}
+
 // The light theme exists for print and for a README on a white background, so
 // it has to actually change the colors rather than just being selectable.
 func TestLightThemeChangesTheOutput(t *testing.T) {
@@ -397,11 +469,93 @@ func TestRenderersSurviveAnEmptySchematic(t *testing.T) {
 	if strings.ContainsAny(out, "┌│└") {
 		t.Errorf("an empty schematic drew a box: %q", out)
 	}
+	if _, err := s.RenderPNG(1); err != nil {
+		t.Errorf("RenderPNG on an empty schematic: %v", err)
+	}
 	if n := s.Netlist(); strings.Count(n, "\n") > 2 {
 		t.Errorf("an empty schematic produced netlist rows:\n%s", n)
 	}
 }
 
+// A file written by Dump has to come back as the same drawing, in each format,
+// or --dump is not a starting point. Its net labels are part of the drawing.
+func TestDumpRoundTripsThroughLoad(t *testing.T) {
+	labelled := func() *Schematic {
+		s := twoBoards()
+		s.NetLabels = &NetLabels{Nets: []string{"SIG", "GND"}}
+		s.Wires[0].Name = "CLK"
+		return s
+	}
+	want, err := labelled().RenderText(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(want, "CLK") {
+		t.Fatalf("no CLK label in\n%s", want)
+	}
+	for _, format := range []string{"yaml", "json", "toml"} {
+		b, err := Dump(labelled(), format)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		s, err := Load("wiring."+format, b)
+		if err != nil {
+			t.Fatalf("%s: %v\n%s", format, err, b)
+		}
+		got, err := s.RenderText(false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("%s: drew\n%s\nwant\n%s", format, got, want)
+		}
+	}
+}
+
+// Every problem is reported, and each at the line it is on.
+func TestLoadReportsWhereTheProblemsAre(t *testing.T) {
+	const src = `nets: [SIG, GND]
+boards:
+  - name: mcu
+    left: [P0, GND]
+  - name: part
+    col: 1
+    left: [IN, GND]
+wires:
+  - {from: mcu.P0, to: part.IN, net: SIG}
+  - {from: mcu.GND, to: part.NOPE, net: GND}
+  - {from: mcu.GND, to: part.GND, net: PWR}
+`
+	_, err := Load("wiring.yaml", []byte(src))
+	if err == nil {
+		t.Fatal("loaded")
+	}
+	for _, want := range []string{
+		`wiring.yaml:10:25: wire 1: board "part" has no pin "NOPE"`,
+		`wiring.yaml:11:40: wire 2: net "PWR" is not one of SIG, GND`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("no %q in\n%v", want, err)
+		}
+	}
+
+	for name, src := range map[string]string{
+		"bad.yaml": "boards:\n  - name: mcu\n    colour: red\n",
+		"bad.toml": "[[boards]]\nname = \"mcu\"\n\n[[wires]]\nfrom = \"mcu.P0\"\nto = \"mcu.P1\"\n",
+		"bad.txt":  "",
+	} {
+		_, err := Load(name, []byte(src))
+		want := map[string]string{
+			"bad.yaml": "bad.yaml:3: field colour not found",
+			"bad.toml": `bad.toml:4: wire 0: board "mcu" has no pin "P0"`,
+			"bad.txt":  `not ".txt"`,
+		}[name]
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: error %v, want one containing %q", name, err, want)
+		}
+	}
+}
+
 func containsAll(errs []error, subs ...string) bool {
 	var all strings.Builder
 	for _, err := range errs {
@@ -415,3 +569,798 @@ func containsAll(errs []error, subs ...string) bool {
 	}
 	return len(errs) > 0
 }
+
+// What the netlist says has to be what the wires say: every wire's two pins in
+// one net, under the numbers the drawing gives them.
+func TestKiCadNetlistRoundTripsThroughTheParser(t *testing.T) {
+	s := twoBoards()
+	s.Boards[1].Left = append(s.Boards[1].Left, "OUT")
+	s.Wires = append(s.Wires,
+		Wire{From: "mcu.P2", To: "part.OUT", Net: "SIG"},
+		Wire{From: "mcu.GND2", To: "part.GND", Net: "GND"})
+	s.Boards[0].Types = map[string]PinType{"P0": OpenDrain, "P2": Output, "GND2": PowerOut}
+	out, err := s.KiCadNetlist()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nl, err := parseKiCadNetlist(out)
+	if err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+
+	if len(nl.comps) != 2 || nl.comps[0].ref != "mcu" || nl.comps[0].value != "MCU" || nl.comps[1].ref != "part" {
+		t.Errorf("components %+v", nl.comps)
+	}
+	// mcu is numbered 1..4 down the left and 5..8 up the right, the gap
+	// taking 3; part is unnumbered, so counts from 1. The types come back
+	// as they went, open_drain by way of KiCad's open_collector.
+	if got := fmt.Sprint(nl.libparts["mcu"]); got != "[{1 P0 open_drain} {2 P1 } {4 GND1 } {5 GND2 power_out} {6 P3 } {7 P2 output} {8 VCC }]" {
+		t.Errorf("mcu's pins %s", got)
+	}
+
+	net := map[string]string{}
+	for _, n := range nl.nets {
+		for _, nd := range n.nodes {
+			net[fmt.Sprintf("%s.%s", nd.ref, nd.function)] = n.name
+		}
+	}
+	for _, w := range s.Wires {
+		if net[w.From] == "" || net[w.From] != net[w.To] {
+			t.Errorf("%s and %s are in nets %q and %q", w.From, w.To, net[w.From], net[w.To])
+		}
+	}
+	// Both SIG wires make nets of their own, so neither can be called
+	// just SIG. GND1 and GND2 are one ground, however many wires.
+	if net["mcu.P0"] != "SIG-(mcu-Pad1)" || net["mcu.P2"] != "SIG-(mcu-Pad7)" {
+		t.Errorf("SIG nets are %q and %q", net["mcu.P0"], net["mcu.P2"])
+	}
+	if net["mcu.GND1"] != "GND" || net["mcu.GND2"] != "GND" || net["mcu.VCC"] != "PWR" {
+		t.Errorf("rails are %q, %q and %q", net["mcu.GND1"], net["mcu.GND2"], net["mcu.VCC"])
+	}
+	if len(nl.nets) != 4 {
+		t.Errorf("%d nets, want 4", len(nl.nets))
+	}
+
+	again, err := s.KiCadNetlist()
+	if err != nil || again != out {
+		t.Error("the netlist is not the same twice")
+	}
+}
+
+func TestParseSexprHandlesKiCadStrings(t *testing.T) {
+	e, err := parseSexpr("(net (name \"a \\\"b\\\" \\\\c\")\n  (code 3))")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := e.value("name"); got != `a "b" \c` {
+		t.Errorf("name %q", got)
+	}
+	if got := e.value("code"); got != "3" {
+		t.Errorf("code %q", got)
+	}
+	for _, bad := range []string{"(a", "(a))", "(a \"b)", ")"} {
+		if _, err := parseSexpr(bad); err == nil {
+			t.Errorf("%q parsed", bad)
+		}
+	}
+	if got := kicadQuote("x\"y\\"); got != `"x\"y\\"` {
+		t.Errorf("quoted %s", got)
+	}
+}
+
+func TestRenderDOTHasARecordPerBoardAndAnEdgePerWire(t *testing.T) {
+	s := twoBoards()
+	out, err := s.RenderDOT(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	styles := s.Styles()
+	for _, want := range []string{
+		`"mcu" [label="{{<P0> P0|<P1> P1||<GND1> GND}|MCU|{<VCC> VCC|<P2> P2|<P3> P3|<GND2> GND}}"]`,
+		`"part" [label="{{<IN> IN|<PWR> PWR|<GND> GND}|PART}"]`,
+		`"mcu":"P0" -- "part":"IN" [color="` + styles[0].Hex + `"]`,
+		`"mcu":"GND1" -- "part":"GND" [color="` + styles[2].Hex + `"]`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("no %s in\n%s", want, out)
+		}
+	}
+	if n := strings.Count(out, " -- "); n != len(s.Wires) {
+		t.Errorf("%d edges for %d wires", n, len(s.Wires))
+	}
+}
+
+// With bus, the wires of a net between two boards are one edge that says how
+// many there are; a net with one wire between them is still that wire.
+func TestBusEdgesCollapseANetBetweenTwoBoards(t *testing.T) {
+	s := twoBoards()
+	s.Boards[1].Left = append(s.Boards[1].Left, "IN2")
+	s.Wires = append(s.Wires, Wire{From: "mcu.P1", To: "part.IN2", Net: "SIG"})
+
+	dot, err := s.RenderDOT(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(dot, `"mcu" -- "part" [color="`+s.Styles()[0].Hex+`", penwidth=2, label="SIG ×2"]`) ||
+		!strings.Contains(dot, `"mcu":"VCC" -- "part":"PWR"`) || strings.Count(dot, " -- ") != 3 {
+		t.Errorf("drew\n%s", dot)
+	}
+
+	mm, err := s.RenderMermaid(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`b0 ---|"SIG ×2"| b1`,
+		`b0p1 --- b1p0`, // VCC to PWR
+		`linkStyle 0 stroke:` + s.Styles()[0].Hex,
+	} {
+		if !strings.Contains(mm, want) {
+			t.Errorf("no %s in\n%s", want, mm)
+		}
+	}
+}
+
+// Mermaid has no ports, so a pin is a node, and only a pin with a wire on it.
+func TestRenderMermaidShowsOnlyTheWiredPins(t *testing.T) {
+	out, err := twoBoards().RenderMermaid(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`subgraph b0["MCU"]`, `["GND"]`, `["IN"]`, "flowchart LR"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("no %s in\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, `["P3"]`) {
+		t.Errorf("an unwired pin is drawn:\n%s", out)
+	}
+	if n := strings.Count(out, " --- "); n != 3 {
+		t.Errorf("%d edges, want 3", n)
+	}
+}
+
+// kicadConnectivity is a schematic's nets as the netlist writes them, each as
+// its sorted pads, so two schematics can be compared for what they connect
+// whatever their wires look like.
+func kicadConnectivity(t *testing.T, s *Schematic) []string {
+	t.Helper()
+	nets, err := s.kicadNets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []string
+	for _, n := range nets {
+		var pads []string
+		for _, nd := range n.nodes {
+			pads = append(pads, fmt.Sprintf("%s-%d", nd.ref, nd.pin))
+		}
+		sort.Strings(pads)
+		out = append(out, strings.Join(pads, " "))
+	}
+	sort.Strings(out)
+	return out
+}
+
+// A netlist imported has to connect what the schematic it came from did, and
+// be a schematic that validates and draws.
+func TestImportKiCadConnectsWhatWasExported(t *testing.T) {
+	s := twoBoards()
+	s.Boards[1].Left = append(s.Boards[1].Left, "VDD", "OUT")
+	s.Wires = append(s.Wires,
+		Wire{From: "mcu.VCC", To: "part.VDD", Net: "PWR"},
+		Wire{From: "mcu.GND2", To: "part.OUT", Net: "GND"})
+	net, err := s.KiCadNetlist()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ImportKiCad("x.net", []byte(net), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errs := got.Validate(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if _, err := got.RenderText(false); err != nil {
+		t.Fatal(err)
+	}
+	if a, b := kicadConnectivity(t, s), kicadConnectivity(t, got); !slices.Equal(a, b) {
+		t.Errorf("exported\n%v\nimported\n%v", a, b)
+	}
+	// The mcu is on every net, so each is wired from it; the part faces it.
+	for _, w := range got.Wires {
+		if !strings.HasPrefix(w.From, "mcu.") {
+			t.Errorf("wire %v is not from the mcu", w)
+		}
+	}
+	if p := got.board("part"); got.board("mcu").Col != 1 || p.Col == 0 && len(p.Left) > 0 || p.Col == 2 && len(p.Right) > 0 {
+		t.Errorf("part is at col %d with pins %v %v", p.Col, p.Left, p.Right)
+	}
+}
+
+// As KiCad writes a netlist: pins that share a name, a local label's sheet
+// path, nets named for their pads, and a part the library has.
+func TestImportKiCadReadsKiCadsOwnNames(t *testing.T) {
+	const src = `(export (version "E")
+  (components
+    (comp (ref "U1") (value "MCU") (libsource (lib "x") (part "mcu") (description "")))
+    (comp (ref "J1") (value "Sensor") (libsource (lib "x") (part "Conn_01x04") (description ""))))
+  (libparts
+    (libpart (lib "x") (part "Conn_01x04")
+      (pins
+        (pin (num "1") (name "GND") (type "passive"))
+        (pin (num "2") (name "GND") (type "passive"))
+        (pin (num "4") (name "~") (type "passive")))))
+  (nets
+    (net (code "1") (name "/SDA")
+      (node (ref "U1") (pin "2") (pinfunction "P1"))
+      (node (ref "J1") (pin "4")))
+    (net (code "2") (name "Net-(J1-Pad1)")
+      (node (ref "U1") (pin "4") (pinfunction "GND1"))
+      (node (ref "J1") (pin "1") (pinfunction "GND"))
+      (node (ref "J1") (pin "2") (pinfunction "GND")))))
+`
+	lib := func(part, name string) (*Board, error) {
+		if part != "mcu" {
+			return nil, fmt.Errorf("no part %q", part)
+		}
+		b := twoBoards().Boards[0]
+		b.Name, b.Part = name, part
+		return b, nil
+	}
+	s, err := ImportKiCad("x.net", []byte(src), lib, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j1 := s.board("J1")
+	if got := fmt.Sprint(append(j1.Left, j1.Right...)); got != "[GND1 GND2  P4]" {
+		t.Errorf("J1's pins %s", got)
+	}
+	if j1.Labels["GND2"] != "GND" || j1.Labels["P4"] != "4" || j1.Title != "Sensor" {
+		t.Errorf("J1 is %+v", j1)
+	}
+	if u1 := s.board("U1"); u1.Part != "mcu" || len(u1.Right) != 4 {
+		t.Errorf("U1 is %+v", u1)
+	}
+	want := []Wire{
+		{From: "U1.P1", To: "J1.P4", Net: "SDA"},
+		{From: "U1.GND1", To: "J1.GND1"},
+		{From: "U1.GND1", To: "J1.GND2"},
+	}
+	if !slices.Equal(s.Wires, want) {
+		t.Errorf("wires\n%v\nwant\n%v", s.Wires, want)
+	}
+
+	if _, err := ImportKiCad("x.net", []byte(src), lib, map[string]string{"J1": "nope"}); err == nil {
+		t.Error("a part asked for by name and not found was not an error")
+	}
+}
+
+func TestImportCSV(t *testing.T) {
+	const src = `from,to,net
+# the bus
+pico.GP4, rtc.SDA, I2C
+pico.GP5,rtc.SCL,I2C
+pico.GND1,rtc.GND
+`
+	lib := func(part, name string) (*Board, error) {
+		if part != "pico" {
+			return nil, fmt.Errorf("no part %q", part)
+		}
+		return &Board{Name: name, Part: part, Left: []string{"GP4", "GP5", "GND1"}}, nil
+	}
+	s, err := ImportCSV("w.csv", []byte(src), lib, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errs := s.Validate(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	rtc := s.board("rtc")
+	if got := fmt.Sprint(rtc.Right); got != "[SDA SCL GND]" || rtc.Col != 0 {
+		t.Errorf("rtc is at col %d with pins %s", rtc.Col, got)
+	}
+	if s.board("pico").Part != "pico" || s.board("pico").Col != 1 {
+		t.Errorf("pico is %+v", s.board("pico"))
+	}
+	if len(s.Wires) != 3 || s.Wires[0] != (Wire{From: "pico.GP4", To: "rtc.SDA", Net: "I2C"}) || s.Wires[2].Net != "" {
+		t.Errorf("wires %v", s.Wires)
+	}
+
+	_, err = ImportCSV("w.csv", []byte("pico.GP4,rtc.SDA\npico.GP9,rtc.SCL\nrtc\n"), lib, nil)
+	for _, want := range []string{`w.csv:2: a pico has no pin "GP9"`, "w.csv:3: a wire is from,to"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("no %q in %v", want, err)
+		}
+	}
+
+	for src, want := range map[string]string{
+		"from,to,net\n\"x,y":    `w.csv:2: extraneous or missing " in quoted-field`,
+		"":                      "w.csv: no wires",
+		"from,to,net\n# none\n": "w.csv: no wires",
+	} {
+		if _, err := ImportCSV("w.csv", []byte(src), lib, nil); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: error %v, want %q", src, err, want)
+		}
+	}
+}
+
+// ercRules is the rules of the violations, in the order ERC reported them.
+func ercRules(t *testing.T, s *Schematic) []Rule {
+	t.Helper()
+	vs, err := s.ERC()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules := make([]Rule, len(vs))
+	for i, v := range vs {
+		rules[i] = v.Rule
+	}
+	return rules
+}
+
+// Untyped pins are given the benefit of the doubt: a schematic with no types
+// has nothing to report.
+func TestERCHasNothingToSayAboutUntypedPins(t *testing.T) {
+	if rules := ercRules(t, twoBoards()); len(rules) != 0 {
+		t.Errorf("violations %v", rules)
+	}
+}
+
+func TestERCFindsEachKindOfMistake(t *testing.T) {
+	s := twoBoards()
+	s.Boards[0].Types = map[string]PinType{
+		"P0": Output, "P1": Bidirectional, "P2": Bidirectional, "P3": OpenDrain,
+		"VCC": PowerOut, "GND1": PowerOut, "GND2": PowerOut,
+	}
+	s.Boards[1].Types = map[string]PinType{"IN": Input, "PWR": PowerIn, "GND": PowerIn}
+	if rules := ercRules(t, s); len(rules) != 0 {
+		t.Fatalf("a sound circuit has violations %v", rules)
+	}
+
+	// Two outputs on one net.
+	s.Boards[1].Types["IN"] = Output
+	if rules := ercRules(t, s); !slices.Equal(rules, []Rule{RuleOutputConflict}) {
+		t.Errorf("output against output: %v", rules)
+	}
+
+	// An input nothing drives, and a supply pin nothing supplies.
+	s.Boards[0].Types["P0"], s.Boards[1].Types["IN"] = Input, Input
+	s.Boards[0].Types["VCC"] = PowerIn
+	if rules := ercRules(t, s); !slices.Equal(rules, []Rule{RuleUndrivenInput, RuleUndrivenPower}) {
+		t.Errorf("undriven: %v", rules)
+	}
+	s.Boards[0].Types["P0"], s.Boards[0].Types["VCC"] = Output, PowerOut
+
+	// An input and a power pin with no wire; the grounds are one rail, so
+	// GND1 being wired counts for GND2.
+	s.Boards[1].Left = append(s.Boards[1].Left, "EN", "VDD")
+	s.Boards[1].Types["EN"], s.Boards[1].Types["VDD"] = Input, PowerIn
+	if rules := ercRules(t, s); !slices.Equal(rules, []Rule{RuleUnconnectedInput, RuleUnconnectedPower}) {
+		t.Errorf("unconnected: %v", rules)
+	}
+	s.Boards[1].Left = s.Boards[1].Left[:3]
+
+	// A no-connect with a wire.
+	s.Boards[1].Types["IN"] = NoConnect
+	if rules := ercRules(t, s); !slices.Equal(rules, []Rule{RuleNoConnect}) {
+		t.Errorf("no-connect: %v", rules)
+	}
+}
+
+// An I2C line is one on the I2C net or to a pin called SDA or SCL, and a
+// passive board on it is its pull-up.
+func TestERCWantsAPullUpOnI2C(t *testing.T) {
+	s := twoBoards()
+	s.Wires[0].Net = "I2C"
+	if rules := ercRules(t, s); !slices.Equal(rules, []Rule{RuleI2CPullUp}) {
+		t.Errorf("on the I2C net: %v", rules)
+	}
+	s.Wires[0].Net = "SIG"
+	s.Boards[1].Labels = map[string]string{"IN": "SDA"}
+	if rules := ercRules(t, s); !slices.Equal(rules, []Rule{RuleI2CPullUp}) {
+		t.Errorf("to SDA: %v", rules)
+	}
+
+	s.Boards = append(s.Boards, &Board{Name: "r", Left: []string{"A", "B"}, Passive: true})
+	s.Wires = append(s.Wires, Wire{From: "r.B", To: "part.IN"}, Wire{From: "r.A", To: "mcu.VCC"})
+	if rules := ercRules(t, s); len(rules) != 0 {
+		t.Errorf("with a pull-up: %v", rules)
+	}
+}
+
+func TestERCSeverityCanBeChanged(t *testing.T) {
+	s := twoBoards()
+	s.Wires[0].Net = "I2C"
+	vs, err := s.ERC()
+	if err != nil || len(vs) != 1 || vs[0].Severity != SeverityWarning {
+		t.Fatalf("by default %v, %v", vs, err)
+	}
+	s.Severity = map[Rule]Severity{RuleI2CPullUp: SeverityError}
+	if vs, _ := s.ERC(); len(vs) != 1 || vs[0].Severity != SeverityError || !strings.HasPrefix(vs[0].String(), "error: ") {
+		t.Errorf("as an error %v", vs)
+	}
+	s.Severity[RuleI2CPullUp] = SeverityIgnore
+	if vs, _ := s.ERC(); len(vs) != 0 {
+		t.Errorf("ignored %v", vs)
+	}
+
+	for _, v := range []Severity{SeverityIgnore, SeverityWarning, SeverityError} {
+		if p, err := ParseSeverity(v.String()); err != nil || p != v {
+			t.Errorf("%v parses as %v, %v", v, p, err)
+		}
+	}
+}
+
+func TestValidateCatchesABadPinType(t *testing.T) {
+	s := twoBoards()
+	s.Boards[1].Types = map[string]PinType{"IN": "analog", "OUT": Output}
+	s.Boards[1].Levels = map[string]Level{"OUT": {V: 5}}
+	if errs := s.Validate(); !containsAll(errs, `pin "IN" has no type "analog"`, `types pin "OUT"`, `level for pin "OUT"`) {
+		t.Errorf("errors %v", errs)
+	}
+}
+
+// A typed supply pin may carry several wires whatever it is called.
+func TestValidateLetsATypedSupplyFanOut(t *testing.T) {
+	s := twoBoards()
+	s.Boards[0].Right[0] = "V+"
+	s.Wires[1].From = "mcu.V+"
+	s.Boards = append(s.Boards, &Board{Name: "other", Left: []string{"PWR"}})
+	s.Wires = append(s.Wires, Wire{From: "mcu.V+", To: "other.PWR", Net: "PWR"})
+	if errs := s.Validate(); len(errs) != 1 {
+		t.Fatalf("an untyped V+ fanning out: %v", errs)
+	}
+	s.Boards[0].Types = map[string]PinType{"V+": PowerOut}
+	if errs := s.Validate(); len(errs) != 0 {
+		t.Errorf("a power_out V+ fanning out: %v", errs)
+	}
+}
+
+// levelled is twoBoards with mcu a 3.3 V part supplying part, which runs on
+// 3 V to 5.5 V and reads P0 as high from 2 V.
+func levelled() *Schematic {
+	s := twoBoards()
+	s.Boards[0].Types = map[string]PinType{"P0": Output, "VCC": PowerOut, "GND1": PowerOut, "GND2": PowerOut}
+	s.Boards[0].Levels = map[string]Level{"P0": {V: 3.3}, "VCC": {V: 3.3, MA: 100}}
+	s.Boards[1].Types = map[string]PinType{"IN": Input, "PWR": PowerIn, "GND": PowerIn}
+	s.Boards[1].Levels = map[string]Level{"IN": {VIH: 2, VMax: 5.8}, "PWR": {VMin: 3, VMax: 6, MA: 40}}
+	return s
+}
+
+func TestERCChecksLevels(t *testing.T) {
+	s := levelled()
+	if rules := ercRules(t, s); len(rules) != 0 {
+		t.Fatalf("levels that match: %v", rules)
+	}
+
+	s.Boards[1].Levels["IN"] = Level{VIH: 3.5, VMax: 5.8}
+	if rules := ercRules(t, s); !slices.Equal(rules, []Rule{RuleLogicLevel}) {
+		t.Errorf("3.3 V into a 3.5 V threshold: %v", rules)
+	}
+	s.Boards[1].Levels["IN"] = Level{VIH: 2, VMax: 3}
+	if rules := ercRules(t, s); !slices.Equal(rules, []Rule{RuleOvervoltage}) {
+		t.Errorf("3.3 V into a 3 V input: %v", rules)
+	}
+	s.Boards[1].Levels["IN"] = Level{}
+
+	s.Boards[1].Levels["PWR"] = Level{VMin: 4.5, MA: 40}
+	if rules := ercRules(t, s); !slices.Equal(rules, []Rule{RuleUndervoltage}) {
+		t.Errorf("a 5 V part on 3.3 V: %v", rules)
+	}
+	s.Boards[1].Levels["PWR"] = Level{MA: 140}
+	if rules := ercRules(t, s); !slices.Equal(rules, []Rule{RuleOverBudget}) {
+		t.Errorf("140 mA from 100: %v", rules)
+	}
+}
+
+func TestBudgetsSumEachRail(t *testing.T) {
+	s := levelled()
+	s.Boards = append(s.Boards, &Board{
+		Name:   "led",
+		Left:   []string{"A"},
+		Types:  map[string]PinType{"A": PassivePin},
+		Levels: map[string]Level{"A": {MA: 20.5}},
+	})
+	s.Wires = append(s.Wires, Wire{From: "mcu.VCC", To: "led.A", Net: "PWR"})
+	bs, err := s.Budgets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bs) != 1 {
+		t.Fatalf("budgets %+v", bs)
+	}
+	b := bs[0]
+	if b.Source != "mcu.VCC" || b.V != 3.3 || b.Limit != 100 || len(b.Loads) != 2 || b.Total() != 60.5 || b.Headroom() != 39.5 {
+		t.Errorf("budget %+v", b)
+	}
+
+	out, err := s.PowerReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"mcu.VCC           3.3 V   60.5 mA    100 mA    39.5 mA  39%", "  led.A                   20.5 mA"} {
+		if !strings.Contains(out, want+"\n") {
+			t.Errorf("no %q in\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "warning") || strings.Contains(out, "error") {
+		t.Errorf("a sound rail has problems:\n%s", out)
+	}
+}
+
+// A rail made from another is one of its loads, and so is counted all the way
+// up: what is on 3V3 is drawn from VSYS and from VBUS, whether or not anything
+// is wired to them, and puts them over budget too.
+func TestBudgetsChargeARailToTheOneItIsMadeFrom(t *testing.T) {
+	s := &Schematic{
+		Boards: []*Board{
+			{Name: "pico", Left: []string{"VBUS", "VSYS", "3V3"},
+				Types: map[string]PinType{"VBUS": PowerOut, "VSYS": PowerOut, "3V3": PowerOut},
+				Levels: map[string]Level{
+					"VBUS": {V: 5, MA: 500},
+					"VSYS": {V: 4.7, MA: 500, Upstream: "VBUS"},
+					"3V3":  {V: 3.3, MA: 300, Upstream: "VSYS"},
+				}},
+			{Name: "rtc", Left: []string{"VCC"}, Col: 1,
+				Types: map[string]PinType{"VCC": PowerIn}, Levels: map[string]Level{"VCC": {MA: 250}}},
+			{Name: "lcd", Left: []string{"VCC"}, Col: 1,
+				Types: map[string]PinType{"VCC": PowerIn}, Levels: map[string]Level{"VCC": {MA: 280}}},
+		},
+		Wires: []Wire{{From: "pico.3V3", To: "rtc.VCC"}, {From: "pico.VBUS", To: "lcd.VCC"}},
+	}
+	bs, err := s.Budgets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]Budget{}
+	var order []string
+	for _, b := range bs {
+		got[b.Source] = b
+		order = append(order, b.Source)
+	}
+	if want := []string{"pico.VBUS", "pico.3V3", "pico.VSYS"}; !slices.Equal(order, want) {
+		t.Errorf("rails %v, want %v: VSYS has nothing wired, so comes after", order, want)
+	}
+	if b := got["pico.3V3"]; b.Total() != 250 {
+		t.Errorf("3V3 %+v", b)
+	}
+	if b := got["pico.VSYS"]; b.Total() != 250 || len(b.Loads) != 1 || b.Loads[0] != (RailLoad{Pin: "pico.3V3", MA: 250, Rail: true}) {
+		t.Errorf("VSYS %+v", b)
+	}
+	if b := got["pico.VBUS"]; b.Total() != 530 || len(b.Loads) != 2 || !b.Loads[1].Rail {
+		t.Errorf("VBUS %+v", b)
+	}
+	if rules := ercRules(t, s); !slices.Equal(rules, []Rule{RuleOverBudget}) {
+		t.Errorf("530 mA from VBUS's 500: %v", rules)
+	}
+
+	// Upstreams that loop are followed until they would come round again.
+	s.Boards[0].Levels["VBUS"] = Level{V: 5, MA: 500, Upstream: "3V3"}
+	if _, err := s.Budgets(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// A labelled wire is not drawn, and both its pins get a label in its place,
+// whole: the first column moves right to make room for a label on its left.
+func TestNetLabelsReplaceTheirWires(t *testing.T) {
+	s := twoBoards()
+	s.NetLabels = &NetLabels{Nets: []string{"GND"}}
+	d, labelled, err := s.withLabels()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(d.Wires) != 2 || !slices.Equal(labelled, []Wire{s.Wires[2]}) {
+		t.Errorf("drawn %v, labelled %v", d.Wires, labelled)
+	}
+	out, err := s.RenderText(false)
+	if err != nil {
+		t.Fatalf("RenderText: %v", err)
+	}
+	// mcu.GND1 is on the left of the first column, so its label starts
+	// the line; part.GND is on the left of the second, after the gutter.
+	var first, second int
+	for _, l := range strings.Split(out, "\n") {
+		switch {
+		case strings.HasPrefix(l, "GND ⊥──┤[ ]GND"):
+			first++
+		case strings.Contains(l, "├ ") && strings.Contains(l, " GND ⊥──┤[ ]GND"):
+			second++
+		}
+	}
+	if first != 1 || second != 1 || strings.Count(out, "⊥") != 2 {
+		t.Errorf("GND labels at mcu.GND1 and part.GND:\n%s", out)
+	}
+
+	svg, err := s.RenderSVG()
+	if err != nil {
+		t.Fatalf("RenderSVG: %v", err)
+	}
+	if n := strings.Count(svg, ">GND</text>"); n != 2 {
+		t.Errorf("%d GND labels in the SVG", n)
+	}
+}
+
+// A label is named for the net it is on, so that two labels alike are always
+// connected and two nets never share a name.
+func TestNetLabelsAreNamedOncePerNet(t *testing.T) {
+	s := twoBoards()
+	s.Boards = append(s.Boards, &Board{
+		Name: "other", Col: 1,
+		Left:   []string{"A", "B", "VCC", "GND"},
+		Labels: map[string]string{"B": "DATA"},
+	})
+	s.Wires = append(s.Wires,
+		Wire{From: "mcu.P1", To: "other.A", Net: "SIG", Name: "CLK"},
+		Wire{From: "mcu.P2", To: "other.B", Net: "SIG"},
+		Wire{From: "mcu.P3", To: "other.VCC", Net: "PWR"},
+		Wire{From: "mcu.GND2", To: "other.GND", Net: "GND"},
+	)
+	chosen := make([]bool, len(s.Wires))
+	for i := range chosen {
+		chosen[i] = true
+	}
+	names, err := s.labelNames(chosen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for i := range s.Wires {
+		got = append(got, fmt.Sprintf("%s/%d", names[i].name, names[i].kind))
+	}
+	// SIG and PWR are on two nets each, so only GND, one net through the
+	// mcu's two grounds, is named by Net. mcu.P3 is not a rail, and
+	// other.VCC names its net for it.
+	want := []string{"PART_IN/0", "VCC/2", "GND/1", "CLK/0", "OTHER_DATA/0", "VCC/2", "GND/1"}
+	if !slices.Equal(got, want) {
+		t.Errorf("names %v, want %v", got, want)
+	}
+}
+
+// Length and Crossings choose the wires that are costly to draw, and a wire
+// sharing a pin with one is labelled along with it.
+func TestNetLabelsChooseLongAndCrossedWires(t *testing.T) {
+	s := twoBoards()
+	s.NetLabels = &NetLabels{Length: 1}
+	_, labelled, err := s.withLabels()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(labelled) != len(s.Wires) {
+		t.Errorf("wires longer than a cell: %v", labelled)
+	}
+
+	s.NetLabels = &NetLabels{Crossings: 100}
+	if d, labelled, err := s.withLabels(); err != nil || d != s || labelled != nil {
+		t.Errorf("no wire has 100 crossings, but %v are labelled (%v)", labelled, err)
+	}
+
+	rs := []route{
+		{pts: []point{{0, 5}, {10, 5}}},
+		{pts: []point{{5, 0}, {5, 10}}},
+		{pts: []point{{0, 5}, {5, 5}, {5, 10}}}, // meets the others only at ends and along them
+	}
+	if got := crossings(rs); !slices.Equal(got, []int{1, 1, 0}) {
+		t.Errorf("crossings %v", got)
+	}
+
+	s.NetLabels = nil
+	s.Wires = append(s.Wires, Wire{From: "mcu.VCC", To: "part.IN", Net: "PWR"})
+	s.NetLabels = &NetLabels{Nets: []string{"SIG"}}
+	_, labelled, err = s.withLabels()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(labelled) != 3 {
+		t.Errorf("part.IN is labelled, so both its wires and mcu.VCC's other are: %v", labelled)
+	}
+}
+
+// Stats counts what RenderText draws. twoBoards has two wired pins on the far
+// side of its board, so two wraps, and one crossing where the second passes
+// the wire from VCC.
+func TestStatsCountWhatIsDrawn(t *testing.T) {
+	st, err := twoBoards().Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := twoBoards().RenderText(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimRight(out, "\n"), "\n")
+	if st.Height != len(lines) {
+		t.Errorf("height %d, drawn %d lines:\n%s", st.Height, len(lines), out)
+	}
+	want := Stats{Crossings: 1, Wraps: 2, Detours: 0, Length: st.Length, Width: st.Width, Height: st.Height}
+	if st != want {
+		t.Errorf("stats %+v\n%s", st, out)
+	}
+}
+
+// Turning a board over moves its pins and not their numbers, so the netlist,
+// which is by number, is the same.
+func TestMirrorKeepsThePinNumbers(t *testing.T) {
+	s := twoBoards()
+	want := kicadConnectivity(t, s)
+	mcu := s.Boards[0]
+	mcu.mirror()
+	if mcu.Left[0] != "VCC" || mcu.Right[0] != "P0" || !mcu.Mirrored {
+		t.Errorf("mirrored: left %v, right %v", mcu.Left, mcu.Right)
+	}
+	for _, tc := range []struct {
+		side Side
+		i    int
+		want int
+	}{
+		{Right, 0, 1}, {Right, 3, 4}, {Left, 3, 5}, {Left, 0, 8},
+	} {
+		if got := mcu.pinNumber(tc.side, tc.i); got != tc.want {
+			t.Errorf("pinNumber(%v, %d) = %d, want %d", tc.side, tc.i, got, tc.want)
+		}
+	}
+	if got := kicadConnectivity(t, s); !slices.Equal(got, want) {
+		t.Errorf("connectivity %v, want %v", got, want)
+	}
+	mcu.mirror()
+	if mcu.Left[0] != "P0" || mcu.Mirrored {
+		t.Errorf("mirrored twice: left %v", mcu.Left)
+	}
+}
+
+// Optimize changes how a schematic is drawn and not what it connects, and
+// leaves it no worse than it found it. twoBoards' mcu has two of its three
+// wired pins on the side away from the part, and is turned over.
+func TestOptimizeChangesOnlyTheDrawing(t *testing.T) {
+	s := twoBoards()
+	want := kicadConnectivity(t, s)
+	before, after, err := s.Optimize(10 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.cost() > before.cost() {
+		t.Errorf("optimized from %v to %v", before, after)
+	}
+	if after.Wraps != 1 || !s.Boards[0].Mirrored {
+		t.Errorf("optimized to %v, with mcu mirrored %v", after, s.Boards[0].Mirrored)
+	}
+	if st, err := s.Stats(); err != nil || st != after {
+		t.Errorf("Optimize says %v, Stats %v (%v)", after, st, err)
+	}
+	if got := kicadConnectivity(t, s); !slices.Equal(got, want) {
+		t.Errorf("connectivity %v, want %v", got, want)
+	}
+
+	// Already as good as it gets, it is left alone.
+	again, _, err := s.Optimize(10 * time.Second)
+	if err != nil || again != after {
+		t.Errorf("optimized again from %v (%v)", again, err)
+	}
+}
+
+// The lane orders route the same wires the same way round, and the one for
+// crossings crosses no more of them than first come, first served.
+func TestLaneOrdersRouteEveryWire(t *testing.T) {
+	s := twoBoards()
+	s.Boards[1].Left = append(s.Boards[1].Left, "A", "B")
+	s.Wires = append(s.Wires,
+		Wire{From: "mcu.P2", To: "part.B", Net: "SIG"},
+		Wire{From: "mcu.P3", To: "part.A", Net: "SIG"},
+	)
+	stats := map[LaneOrder]Stats{}
+	for _, o := range LaneOrders {
+		s.Lanes = o
+		st, err := s.Stats()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if st.Wraps != 2 || st.Detours != 0 {
+			t.Errorf("%q: %v", o, st)
+		}
+		stats[o] = st
+	}
+	if stats[LanesByCrossings].Crossings > stats[LanesFromSource].Crossings {
+		t.Errorf("lanes by crossings %v, from source %v", stats[LanesByCrossings], stats[LanesFromSource])
+	}
+}
@@ -3,23 +3,51 @@
 //	mcu schematic            text renderer, color
 //	mcu schematic --graph    asciigraph renderer
 //	mcu schematic --netlist  connection table
+//	mcu schematic --png f    a PNG, drawn without a browser; --png-scale 3 bigger
+//	mcu schematic --kicad f  a KiCad netlist, for laying out a PCB
+//	mcu schematic --dot      a Graphviz graph; --mermaid, a Mermaid flowchart
+//	mcu schematic --erc      check the wiring against the pins' electrical types
+//	  --erc-severity i2c-pull-up=error   and make a rule matter more, or less
+//	mcu schematic --power    each rail's current budget, and the logic levels
+//	mcu schematic --label-nets GND,PWR    draw those nets as net labels, not wires;
+//	  --label-length 60, --label-crossings 8   and wires longer, or more crossed
+//	mcu schematic --optimize   reorder and turn over the boards, and pick the
+//	  lanes, for the fewest crossings; --optimize=5s to search for longer
+//	mcu schematic --stats    how many crossings, wraps and detours it has
+//	mcu schematic --from f   the wiring of another firmware than GOPROG's
+//	mcu schematic -f w.yaml  a schematic file; --dump writes one to start from
+//	  --parts p.yaml         parts for its boards besides the built-in ones
+//	mcu schematic -f w.net   a KiCad netlist, or -f w.csv a table of wires;
+//	  --part lcd=hd44780     a board of it is drawn as the library's part
 //
 // Everything below the flag parsing is a description of the hardware. There is
 // no drawing code here at all: boards are pin names, wires are pairs of pin
-// references, and the package works out the geometry. Retargeting this to
-// different hardware means editing the data, not re-tuning coordinates.
+// references, and the package works out the geometry.
 //
-// The wiring matches firmware/main.go: the LCD data bus on GP22..GP15, RS on GP26, EN on
-// GP27, contrast on GP28, and the DS1307 on I2C0 (GP0/GP1).
+// The description is read from the firmware itself. It used to be written out
+// here by hand, pin for pin, with a comment asking that it be kept in step with
+// firmware/main.go; it was not always. Now the displays, ds1307i2c and relays
+// initializers are evaluated from the source, the way eval --export does it,
+// and the diagram is of the program that is flashed.
 package main
 
 import (
+	"cmp"
+	"errors"
 	"fmt"
+	"maps"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
+	"go.yaml.in/yaml/v3"
 
+	"github.com/0magnet/tinygo-stuff/goprog"
 	"github.com/0magnet/tinygo-stuff/schematic"
+	"github.com/0magnet/tinygo-stuff/schematic/parts"
 )
 
 // Nets. Naming them is what colors the drawing and groups the netlist.
@@ -36,7 +64,28 @@ var (
 	schNetlist bool
 	schPlain   bool
 	schSVG     string
+	schPNG     string
+	schScale   int
+	schKiCad   string
+	schDOT     bool
+	schMermaid bool
+	schBus     bool
 	schLight   bool
+	schFrom    string
+	schFile    string
+	schDump    string
+	schParts   []string
+	schPartOf  map[string]string
+	schERC     bool
+	schSevOf   map[string]string
+	schPower   bool
+
+	schLabelNets      []string
+	schLabelLength    int
+	schLabelCrossings int
+
+	schOptimize time.Duration
+	schStats    bool
 )
 
 func init() {
@@ -44,7 +93,28 @@ func init() {
 	schematicCmd.Flags().BoolVar(&schNetlist, "netlist", false, "print the connection table instead of a drawing")
 	schematicCmd.Flags().BoolVar(&schPlain, "plain", false, "no ANSI color")
 	schematicCmd.Flags().StringVar(&schSVG, "svg", "", "write an SVG to this path instead of drawing to the terminal")
+	schematicCmd.Flags().StringVar(&schPNG, "png", "", "write a PNG to this path instead of drawing to the terminal; needs no browser or fonts")
+	schematicCmd.Flags().IntVar(&schScale, "png-scale", 2, "with --png, this many pixels to each of the SVG's")
+	schematicCmd.Flags().StringVar(&schKiCad, "kicad", "", "write a KiCad netlist (.net) to this path, for Pcbnew to import")
+	schematicCmd.Flags().BoolVar(&schDOT, "dot", false, "print the connections as a Graphviz graph")
+	schematicCmd.Flags().BoolVar(&schMermaid, "mermaid", false, "print the connections as a Mermaid flowchart")
+	schematicCmd.Flags().BoolVar(&schERC, "erc", false, "check the wiring against the pins' electrical types; errors make the exit status non-zero")
+	schematicCmd.Flags().StringToStringVar(&schSevOf, "erc-severity", nil, "rule=severity: report an ERC rule as an error or warning, or ignore it")
+	schematicCmd.Flags().BoolVar(&schPower, "power", false, "print each rail's current budget and headroom, and any logic-level mismatch")
+	schematicCmd.Flags().BoolVar(&schBus, "bus", false, "with --dot or --mermaid, draw each net's wires between two boards as one edge")
+	schematicCmd.Flags().StringSliceVar(&schLabelNets, "label-nets", nil, "draw the wires of these nets as net labels at their pins rather than as wires")
+	schematicCmd.Flags().IntVar(&schLabelLength, "label-length", 0, "draw each wire longer than this many cells as net labels")
+	schematicCmd.Flags().IntVar(&schLabelCrossings, "label-crossings", 0, "draw each wire crossed by more than this many others as net labels")
+	schematicCmd.Flags().DurationVar(&schOptimize, "optimize", 0, "spend up to this long rearranging the drawing for fewer crossings, wraps and detours")
+	schematicCmd.Flags().Lookup("optimize").NoOptDefVal = "1s"
+	schematicCmd.Flags().BoolVar(&schStats, "stats", false, "print the drawing's crossings, wraps, detours and size instead of drawing it")
 	schematicCmd.Flags().BoolVar(&schLight, "light", false, "use the light-background palette (for print, a white terminal, or a README)")
+	schematicCmd.Flags().StringVar(&schFrom, "from", "", "the firmware source to read the wiring from (default GOPROG)")
+	schematicCmd.Flags().StringVarP(&schFile, "file", "f", "", "draw a schematic file (.yaml, .json or .toml), KiCad netlist (.net) or table of wires (.csv) instead of a firmware's wiring")
+	schematicCmd.Flags().StringVar(&schDump, "dump", "", "print the schematic as yaml, json or toml, in the form -f reads, instead of drawing it")
+	schematicCmd.Flags().Lookup("dump").NoOptDefVal = "yaml"
+	schematicCmd.Flags().StringToStringVar(&schPartOf, "part", nil, "board=part: draw a board of an imported netlist or table as the library's part")
+	schematicCmd.Flags().StringSliceVar(&schParts, "parts", nil, "part files (.yaml, .json or .toml) whose parts -f's boards may name, besides the built-in ones")
 	schematicCmd.Flags().SortFlags = false
 	RootCmd.AddCommand(schematicCmd)
 }
@@ -56,12 +126,86 @@ var schematicCmd = &cobra.Command{
 	SilenceUsage:          true,
 	DisableSuggestions:    true,
 	DisableFlagsInUseLine: true,
+	Args:                  noArgs("--dump=toml"),
 	RunE: func(_ *cobra.Command, _ []string) error {
-		s := build()
+		var s *schematic.Schematic
+		if schFile != "" {
+			if schFrom != "" {
+				return fmt.Errorf("-f draws a schematic file and --from a firmware's wiring; give one")
+			}
+			for _, name := range schParts {
+				data, err := os.ReadFile(name)
+				if err != nil {
+					return err
+				}
+				ps, err := parts.Load(name, data)
+				if err != nil {
+					return err
+				}
+				if err := parts.Register(ps...); err != nil {
+					return fmt.Errorf("%s: %w", name, err)
+				}
+			}
+			data, err := os.ReadFile(schFile)
+			if err != nil {
+				return err
+			}
+			switch strings.ToLower(filepath.Ext(schFile)) {
+			case ".net":
+				s, err = schematic.ImportKiCad(schFile, data, parts.New, schPartOf)
+			case ".csv":
+				s, err = schematic.ImportCSV(schFile, data, parts.New, schPartOf)
+			default:
+				s, err = schematic.LoadWith(schFile, data, parts.New)
+			}
+			if err != nil {
+				var errs interface{ Unwrap() []error }
+				if errors.As(err, &errs) {
+					for _, err := range errs.Unwrap() {
+						fmt.Fprintln(os.Stderr, "schematic:", err)
+					}
+					return fmt.Errorf("%d errors in %s", len(errs.Unwrap()), schFile)
+				}
+				return err
+			}
+		} else {
+			var err error
+			if s, err = firmwareSchematic(); err != nil {
+				return err
+			}
+		}
 
 		if schLight {
 			s.Theme = &schematic.Light
 		}
+		if schLabelNets != nil || schLabelLength != 0 || schLabelCrossings != 0 {
+			// The flags add to what a file asks for, and a number given
+			// replaces its number.
+			nl := schematic.NetLabels{}
+			if s.NetLabels != nil {
+				nl = *s.NetLabels
+			}
+			nl.Nets = append(slices.Clone(nl.Nets), schLabelNets...)
+			nl.Length = cmp.Or(schLabelLength, nl.Length)
+			nl.Crossings = cmp.Or(schLabelCrossings, nl.Crossings)
+			s.NetLabels = &nl
+		}
+		if schOptimize > 0 {
+			before, after, err := s.Optimize(schOptimize)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "schematic: optimized from %v\nschematic: to %v\n", before, after)
+		}
+
+		if schDump != "" {
+			b, err := schematic.Dump(s, schDump)
+			if err != nil {
+				return err
+			}
+			_, err = os.Stdout.Write(b)
+			return err
+		}
 
 		if errs := s.Validate(); len(errs) > 0 {
 			for _, err := range errs {
@@ -71,6 +215,20 @@ var schematicCmd = &cobra.Command{
 		}
 
 		switch {
+		case schERC:
+			return erc(s)
+		case schStats:
+			st, err := s.Stats()
+			if err != nil {
+				return err
+			}
+			fmt.Println(st)
+		case schPower:
+			out, err := s.PowerReport()
+			if err != nil {
+				return err
+			}
+			fmt.Print(out)
 		case schSVG != "":
 			out, err := s.RenderSVG()
 			if err != nil {
@@ -79,6 +237,30 @@ var schematicCmd = &cobra.Command{
 			// The output is a diagram meant to be read and committed, not a
 			// secret, so it is readable rather than 0600.
 			return os.WriteFile(schSVG, []byte(out), 0o644) //nolint:gosec
+		case schPNG != "":
+			out, err := s.RenderPNG(schScale)
+			if err != nil {
+				return err
+			}
+			return os.WriteFile(schPNG, out, 0o644) //nolint:gosec // as for the SVG
+		case schKiCad != "":
+			out, err := s.KiCadNetlist()
+			if err != nil {
+				return err
+			}
+			return os.WriteFile(schKiCad, []byte(out), 0o644) //nolint:gosec // as for the SVG
+		case schDOT:
+			out, err := s.RenderDOT(schBus)
+			if err != nil {
+				return err
+			}
+			fmt.Print(out)
+		case schMermaid:
+			out, err := s.RenderMermaid(schBus)
+			if err != nil {
+				return err
+			}
+			fmt.Print(out)
 		case schNetlist:
 			fmt.Print(s.Netlist())
 		case schGraph:
@@ -98,136 +280,347 @@ var schematicCmd = &cobra.Command{
 	},
 }
 
-func build() *schematic.Schematic {
-	pico := &schematic.Board{
-		Name:  "pico",
-		Title: "Pi-Pico",
-		Left: []string{
-			"GP0", "GP1", "GND1", "GP2", "GP3", "GP4", "GP5", "GND2",
-			"GP6", "GP7", "GP8", "GP9", "GND3", "GP10", "GP11", "GP12",
-			"GP13", "GND4", "GP14", "GP15",
-		},
-		Right: []string{
-			"VBUS", "VSYS", "GND5", "3V3EN", "3V3", "AREF", "GP28", "GND6",
-			"GP27", "GP26", "RUN", "GP22", "GND7", "GP21", "GP20", "GP19",
-			"GP18", "GND8", "GP17", "GP16",
-		},
-	}
-	// The Pico has eight pins silkscreened GND. They need distinct names
-	// so a wire can say which one it lands on, but the drawing should show
-	// what is printed on the board.
-	pico.Labels = map[string]string{}
-	for i := 1; i <= 8; i++ {
-		pico.Labels[fmt.Sprintf("GND%d", i)] = "GND"
-	}
-	for n, l := range map[string]string{
-		"GP0": "GP0/U0Rx", "GP1": "GP1/U0Tx",
-		"GP26": "A0/GP26", "GP27": "A1/GP27", "GP28": "A2/GP28",
-	} {
-		pico.Labels[n] = l
-	}
-	pico.FirstPin = 1
-
-	lcd := &schematic.Board{
-		Name:  "lcd",
-		Title: "HD44780",
-		Left: []string{
-			"GND", "VCC", "CONT", "RS", "RW", "EN",
-			"BIT0", "BIT1", "BIT2", "BIT3",
-			"BIT4", "BIT5", "BIT6", "BIT7",
-			"LED+", "LED-",
-		},
-	}
-
-	// The DS1307 talks to I2C0 on GP0/GP1, which are on the Pico's left
-	// column, so it is placed to the left with its pins facing right.
-	// Board order and pin side are the layout: get them to match the
-	// physical arrangement and almost every wire routes straight across.
-	// The one that cannot is 3V3, which is genuinely on the far side of the
-	// Pico — that wire routes around the outside, as it would in reality.
-	rtc := &schematic.Board{
-		Name:  "rtc",
-		Title: "DS1307",
-		Right: []string{"GND", "VCC", "SDA", "SCL", "SQW"},
-	}
-
-	// The default build drives two displays (see multidisplays in firmware/main.go).
-	// The second hangs off the Pico's left column, so it shares column 0
-	// with the RTC and stacks underneath it — which is how the original
-	// hand-drawn diagram arranged them.
-	lcd2 := &schematic.Board{
-		Name:  "lcd2",
-		Title: "HD44780 #2",
-		Col:   0,
-		Right: []string{
-			"GND", "VCC", "CONT", "RS", "RW", "EN",
-			"BIT0", "BIT1", "BIT2", "BIT3",
-			"BIT4", "BIT5", "BIT6", "BIT7",
-			"LED+", "LED-",
-		},
-	}
-	rtc.Col = 0
-	pico.Col = 1
-	lcd.Col = 2
-
-	s := &schematic.Schematic{Boards: []*schematic.Board{rtc, lcd2, pico, lcd}}
-
-	// LCD 8-bit data bus. Bit n is driven by the nth entry of DataPins in
-	// main.go, which counts down from GP22.
-	dataPins := []string{"GP22", "GP21", "GP20", "GP19", "GP18", "GP17", "GP16", "GP15"}
-	for i, p := range dataPins {
-		s.Wires = append(s.Wires, schematic.Wire{
-			From: "pico." + p,
-			To:   fmt.Sprintf("lcd.BIT%d", i),
-			Net:  netDAT,
-		})
-	}
-
-	s.Wires = append(s.Wires,
-		// LCD control.
-		schematic.Wire{From: "pico.GP26", To: "lcd.RS", Net: netCTL},
-		schematic.Wire{From: "pico.GP27", To: "lcd.EN", Net: netCTL},
-		schematic.Wire{From: "pico.GP28", To: "lcd.CONT", Net: netCTL},
-		// RW is tied low: this display is only ever written to.
-		schematic.Wire{From: "pico.GND8", To: "lcd.RW", Net: netGND},
-
-		// LCD power and backlight.
-		schematic.Wire{From: "pico.VBUS", To: "lcd.VCC", Net: netPWR},
-		schematic.Wire{From: "pico.GND7", To: "lcd.GND", Net: netGND},
-		schematic.Wire{From: "pico.VBUS", To: "lcd.LED+", Net: netPWR},
-		schematic.Wire{From: "pico.GND6", To: "lcd.LED-", Net: netGND},
-
-		// DS1307 on I2C0.
-		schematic.Wire{From: "pico.GP0", To: "rtc.SDA", Net: netI2C},
-		schematic.Wire{From: "pico.GP1", To: "rtc.SCL", Net: netI2C},
-		schematic.Wire{From: "pico.3V3", To: "rtc.VCC", Net: netPWR},
-		schematic.Wire{From: "pico.GND1", To: "rtc.GND", Net: netGND},
-	)
-
-	wireSecondDisplay(s)
-
-	return s
-}
-
-// wireSecondDisplay adds the second HD44780 from the multidisplays default in
-// main.go: data on GP5..GP12, RS on GP4, EN on GP3, contrast on GP2.
-func wireSecondDisplay(s *schematic.Schematic) {
-	data2 := []string{"GP5", "GP6", "GP7", "GP8", "GP9", "GP10", "GP11", "GP12"}
-	for i, p := range data2 {
-		s.Wires = append(s.Wires, schematic.Wire{
-			From: "pico." + p,
-			To:   fmt.Sprintf("lcd2.BIT%d", i),
-			Net:  netDAT,
-		})
-	}
-	s.Wires = append(s.Wires,
-		schematic.Wire{From: "pico.GP4", To: "lcd2.RS", Net: netCTL},
-		schematic.Wire{From: "pico.GP3", To: "lcd2.EN", Net: netCTL},
-		schematic.Wire{From: "pico.GP2", To: "lcd2.CONT", Net: netCTL},
-		schematic.Wire{From: "pico.GND2", To: "lcd2.RW", Net: netGND},
-		schematic.Wire{From: "pico.GND3", To: "lcd2.GND", Net: netGND},
-		schematic.Wire{From: "pico.GND4", To: "lcd2.LED-", Net: netGND},
-		schematic.Wire{From: "pico.VSYS", To: "lcd2.VCC", Net: netPWR},
-		schematic.Wire{From: "pico.VSYS", To: "lcd2.LED+", Net: netPWR},
-	)
+// erc prints what the electrical rule check finds, warnings and all, and is an
+// error if any of it is.
+func erc(s *schematic.Schematic) error {
+	for _, r := range slices.Sorted(maps.Keys(schSevOf)) {
+		if !slices.Contains(schematic.Rules, schematic.Rule(r)) {
+			return fmt.Errorf("--erc-severity: there is no rule %q; the rules are %s", r, strings.Join(ruleNames(), ", "))
+		}
+		v, err := schematic.ParseSeverity(schSevOf[r])
+		if err != nil {
+			return fmt.Errorf("--erc-severity: %s: %w", r, err)
+		}
+		if s.Severity == nil {
+			s.Severity = map[schematic.Rule]schematic.Severity{}
+		}
+		s.Severity[schematic.Rule(r)] = v
+	}
+	vs, err := s.ERC()
+	if err != nil {
+		return err
+	}
+	errs := 0
+	for _, v := range vs {
+		fmt.Println(v)
+		if v.Severity == schematic.SeverityError {
+			errs++
+		}
+	}
+	if errs > 0 {
+		return fmt.Errorf("%d ERC errors", errs)
+	}
+	return nil
+}
+
+func ruleNames() []string {
+	names := make([]string, len(schematic.Rules))
+	for i, r := range schematic.Rules {
+		names[i] = string(r)
+	}
+	return names
+}
+
+// firmwareSchematic is the wiring of the firmware --from or GOPROG names.
+func firmwareSchematic() (*schematic.Schematic, error) {
+	name := schFrom
+	if name == "" {
+		name = goProg
+	}
+	// Run from the root of the repo with nothing set, the firmware is the
+	// one there is, as it is the one flash suggests.
+	if name == "" {
+		if _, err := os.Stat(defaultFirmware); err == nil {
+			name = defaultFirmware
+		}
+	}
+	if !goprog.MainFile(name) {
+		return nil, fmt.Errorf("no firmware to draw: give --from main.go or -f wiring.yaml, or set GOPROG to a single file")
+	}
+	src, err := goprog.ParseSource(name, nil)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := goprog.Export(src.File)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	s, err := fromFirmware(doc)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return s, nil
+}
+
+// firmwareWiring is the part of a firmware's configuration that is wiring, as
+// goprog.Export gives it: pins by name, GPn or NoPin.
+type firmwareWiring struct {
+	Displays []struct {
+		DataPins []string `yaml:"data_pins"`
+		RS       string   `yaml:"rs"`
+		EN       string   `yaml:"en"`
+		RW       string   `yaml:"rw"`
+		Contrast string   `yaml:"contrast"`
+	} `yaml:"displays"`
+	DS1307 *struct {
+		SDA string `yaml:"sda"`
+		SCL string `yaml:"scl"`
+	} `yaml:"ds1307i2c"`
+	Relays []string `yaml:"relays"`
+}
+
+// fromFirmware lays out the boards a firmware's configuration calls for and
+// wires them to the Pico.
+//
+// A peripheral is placed on whichever side of the Pico most of its signals
+// leave from, with its pins facing the Pico, and the peripherals down each
+// side are stacked in the order of the pins they use. That is how the
+// hand-drawn diagram arranged them, and it lets almost every wire route
+// straight across. The Pico's grounds are numbered so that each carries one
+// wire; each board takes the free ones nearest its signals.
+func fromFirmware(doc *yaml.Node) (*schematic.Schematic, error) {
+	var fw firmwareWiring
+	if err := doc.Decode(&fw); err != nil {
+		return nil, err
+	}
+	w := &wiring{s: &schematic.Schematic{}, pico: parts.MustNew("pico", "pico"), used: map[string]bool{}, row: map[*schematic.Board]float64{}}
+
+	for i, d := range fw.Displays {
+		name, title := "lcd", "HD44780"
+		if i > 0 {
+			name, title = fmt.Sprintf("lcd%d", i+1), fmt.Sprintf("HD44780 #%d", i+1)
+		}
+		// BIT0 is DataPins[0]. In 4-bit mode the HD44780 listens on
+		// BIT4..BIT7 and its low four pins are left unconnected.
+		bits := 0
+		if len(d.DataPins) == 4 {
+			bits = 4
+		}
+		signals := map[string]string{"RS": d.RS, "EN": d.EN, "RW": d.RW, "CONT": d.Contrast}
+		for j, p := range d.DataPins {
+			signals[fmt.Sprintf("BIT%d", bits+j)] = p
+		}
+		b := parts.MustNew("hd44780", name)
+		b.Title = title
+		if err := w.board(b, signals); err != nil {
+			return nil, err
+		}
+		power := "VBUS"
+		if w.side(b) == schematic.Left {
+			power = "VSYS"
+		}
+		for _, pin := range pins(b) {
+			w.signal(b, pin, signals[pin])
+		}
+		// RW as NoPin is tied low: the display is only ever written to.
+		if !isGPIO(d.RW) {
+			w.ground(b, "RW")
+		}
+		w.ground(b, "GND")
+		w.ground(b, "LED-")
+		w.power(b, power, "VCC", "LED+")
+	}
+
+	if fw.DS1307 != nil {
+		signals := map[string]string{"SDA": fw.DS1307.SDA, "SCL": fw.DS1307.SCL}
+		b := parts.MustNew("ds1307", "rtc")
+		if err := w.board(b, signals); err != nil {
+			return nil, err
+		}
+		w.signal(b, "SDA", fw.DS1307.SDA)
+		w.signal(b, "SCL", fw.DS1307.SCL)
+		w.ground(b, "GND")
+		w.power(b, "3V3", "VCC")
+	}
+
+	// A relay module is a board of its own, the smallest in the library
+	// with an input for each relay, driven in the order the firmware lists
+	// them.
+	if len(fw.Relays) > 0 {
+		id := "relay-4"
+		if len(fw.Relays) > 4 {
+			id = "relay-8"
+		}
+		if len(fw.Relays) > 8 {
+			return nil, fmt.Errorf("%d relays: the largest relay module in the library has 8", len(fw.Relays))
+		}
+		b := parts.MustNew(id, "relays")
+		signals := map[string]string{}
+		for i, p := range fw.Relays {
+			signals[fmt.Sprintf("IN%d", i+1)] = p
+		}
+		if err := w.board(b, signals); err != nil {
+			return nil, err
+		}
+		for _, in := range pins(b) {
+			w.signal(b, in, signals[in])
+		}
+		w.ground(b, "GND")
+		w.power(b, "VSYS", "VCC")
+	}
+
+	if err := w.place(); err != nil {
+		return nil, err
+	}
+	return w.s, nil
+}
+
+// pins is a peripheral's pins, in the one column it has.
+func pins(b *schematic.Board) []string { return append(b.Left, b.Right...) }
+
+func isBit(pin string) bool { return len(pin) == 4 && pin[:3] == "BIT" }
+
+func isGPIO(pin string) bool { return pin != "" && pin != "NoPin" }
+
+// wiring is a schematic under construction around the Pico.
+type wiring struct {
+	s    *schematic.Schematic
+	pico *schematic.Board
+	used map[string]bool // the Pico's grounds already carrying a wire
+
+	grounds []groundPin // waiting for place
+
+	// row is where on the Pico's header each board's signals are, on
+	// average, by board: negative for the left column.
+	row map[*schematic.Board]float64
+}
+
+// header is which column of the Pico a pin is in and how far down.
+func (w *wiring) header(pin string) (schematic.Side, int, bool) {
+	if i := slices.Index(w.pico.Left, pin); i >= 0 {
+		return schematic.Left, i, true
+	}
+	if i := slices.Index(w.pico.Right, pin); i >= 0 {
+		return schematic.Right, i, true
+	}
+	return 0, 0, false
+}
+
+// board adds a peripheral, made from a part with its pins in one column, on
+// the side of the Pico most of its signals are on, facing the Pico.
+func (w *wiring) board(b *schematic.Board, signals map[string]string) error {
+	var left, right, rows, n int
+	for _, p := range signals {
+		if !isGPIO(p) {
+			continue
+		}
+		side, row, ok := w.header(p)
+		if !ok {
+			return fmt.Errorf("%s: %s is not on the Pico's header", b.Name, p)
+		}
+		if side == schematic.Left {
+			left++
+		} else {
+			right++
+		}
+		rows += row
+		n++
+	}
+	col := pins(b)
+	if left > right {
+		b.Col, b.Left, b.Right = 0, nil, col
+	} else {
+		b.Col, b.Left, b.Right = 2, col, nil
+	}
+	w.row[b] = float64(rows) / float64(max(n, 1))
+	w.s.Boards = append(w.s.Boards, b)
+	return nil
+}
+
+func (w *wiring) side(b *schematic.Board) schematic.Side {
+	if b.Col == 0 {
+		return schematic.Left
+	}
+	return schematic.Right
+}
+
+// signal wires a board's pin to the GPIO the firmware gives it; NoPin is
+// left unconnected.
+func (w *wiring) signal(b *schematic.Board, pin, gpio string) {
+	if !isGPIO(gpio) {
+		return
+	}
+	net := netCTL
+	switch {
+	case isBit(pin):
+		net = netDAT
+	case pin == "SDA" || pin == "SCL":
+		net = netI2C
+	}
+	w.s.Wires = append(w.s.Wires, schematic.Wire{From: "pico." + gpio, To: b.Name + "." + pin, Net: net})
+}
+
+// ground ties a board's pin to one of the Pico's grounds, which one being
+// left to place.
+func (w *wiring) ground(b *schematic.Board, pin string) {
+	w.grounds = append(w.grounds, groundPin{b, pin})
+}
+
+type groundPin struct {
+	b   *schematic.Board
+	pin string
+}
+
+// nearestGround wires a board's pin to the free Pico ground nearest the
+// board's signals, on its own side of the Pico if one is left there.
+func (w *wiring) nearestGround(b *schematic.Board, pin string) error {
+	want := w.side(b)
+	best, bestScore := "", 0.0
+	for _, side := range []schematic.Side{schematic.Left, schematic.Right} {
+		col := w.pico.Left
+		if side == schematic.Right {
+			col = w.pico.Right
+		}
+		for row, p := range col {
+			if len(p) < 4 || p[:3] != "GND" || w.used[p] {
+				continue
+			}
+			score := w.row[b] - float64(row)
+			score = max(score, -score)
+			if side != want {
+				score += 100
+			}
+			if best == "" || score < bestScore {
+				best, bestScore = p, score
+			}
+		}
+	}
+	if best == "" {
+		return fmt.Errorf("%s.%s: every ground on the Pico is taken", b.Name, pin)
+	}
+	w.used[best] = true
+	w.s.Wires = append(w.s.Wires, schematic.Wire{From: "pico." + best, To: b.Name + "." + pin, Net: netGND})
+	return nil
+}
+
+func (w *wiring) power(b *schematic.Board, rail string, pins ...string) {
+	for _, p := range pins {
+		w.s.Wires = append(w.s.Wires, schematic.Wire{From: "pico." + rail, To: b.Name + "." + p, Net: netPWR})
+	}
+}
+
+// place puts the Pico between the columns and stacks each column's boards in
+// the order of the header rows they use, top first. The grounds are handed out
+// in the same order, so the board at the top gets the ground at the top.
+func (w *wiring) place() error {
+	w.pico.Col = 1
+	boards := append([]*schematic.Board{w.pico}, w.s.Boards...)
+	slices.SortStableFunc(boards, func(a, b *schematic.Board) int {
+		if c := cmp.Compare(a.Col, b.Col); c != 0 {
+			return c
+		}
+		return cmp.Compare(w.row[a], w.row[b])
+	})
+	w.s.Boards = boards
+	for _, b := range boards {
+		for _, g := range w.grounds {
+			if g.b != b {
+				continue
+			}
+			if err := w.nearestGround(b, g.pin); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
 }
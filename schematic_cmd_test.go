@@ -0,0 +1,27 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// With neither --from nor GOPROG, schematic draws the repo's firmware when it
+// is run where the firmware is, and says what to give it when it is not.
+func TestFirmwareSchematicFallsBackToTheRepoFirmware(t *testing.T) {
+	savedFrom, savedProg := schFrom, goProg
+	t.Cleanup(func() { schFrom, goProg = savedFrom, savedProg })
+	schFrom, goProg = "", ""
+
+	s, err := firmwareSchematic()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Boards) < 3 {
+		t.Errorf("drew %d boards, want the Pico, the displays and the RTC", len(s.Boards))
+	}
+
+	t.Chdir(t.TempDir())
+	if _, err := firmwareSchematic(); err == nil || !strings.Contains(err.Error(), "no firmware to draw") {
+		t.Errorf("away from the repo: error %v", err)
+	}
+}
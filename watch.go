@@ -0,0 +1,431 @@
+// Watch mode: rebuild and reflash whenever the source changes.
+//
+// `mcu flash --watch` and `mcu ef --watch` stay running. Each save of GOPROG,
+// another file in its package, or the project file starts a new cycle: the
+// firmware is built first, and only a build that succeeds is flashed. A build
+// error is printed where the serial output was and the board is left alone,
+// still running the last firmware that compiled.
+//
+// Flashing a board that is running means getting it back into BOOTSEL without
+// touching it. The serial port is opened at 1200 baud and closed, which the
+// RP2040's USB stack takes as a request to reset into the bootloader — the same
+// trick tinygo flash uses.
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/bitfield/script"
+	"github.com/spf13/cobra"
+	"github.com/tarm/serial"
+
+	"github.com/0magnet/tinygo-stuff/goprog"
+)
+
+// debounce is how long the tree has to be quiet before a cycle starts. An
+// editor's save is often a write, a rename and a chmod; one rebuild is wanted,
+// not three.
+const debounce = 300 * time.Millisecond
+
+var watch bool
+
+// srcValues is each generated non-string flag's default as it was read from
+// the source, so watch mode can tell a value the user chose from one the
+// source has since changed.
+var srcValues = map[string]string{}
+
+func addWatchFlags(cmds ...*cobra.Command) {
+	for _, c := range cmds {
+		c.Flags().BoolVar(&watch, "watch", false, "rebuild and reflash when GOPROG, its package, or "+configName+" changes")
+	}
+}
+
+// watchPaths is every directory to watch, with the file names in it that
+// matter. Directories rather than files, because editors save by writing a new
+// file and renaming it over the old one, and a watch on the old inode sees
+// nothing after the first save.
+func watchPaths() map[string]func(name string) bool {
+	paths := map[string]func(string) bool{}
+	for _, dir := range progDirs {
+		paths[dir] = func(name string) bool {
+			return strings.HasSuffix(name, ".go") && !strings.HasSuffix(name, "_test.go")
+		}
+	}
+	if project != nil {
+		// The directories of the program are absolute, and a relative
+		// MCUCONFIG's would be a second key for what may be one of them.
+		path := project.path
+		if abs, err := filepath.Abs(path); err == nil {
+			path = abs
+		}
+		dir, base := filepath.Split(path)
+		dir = filepath.Clean(dir)
+		prev := paths[dir]
+		paths[dir] = func(name string) bool {
+			return name == base || (prev != nil && prev(name))
+		}
+	}
+	return paths
+}
+
+// runWatch runs build/flash cycles until interrupted. rewrite is true for ef,
+// whose cycle also regenerates the modified source.
+func runWatch(cmd *cobra.Command, rewriteSrc bool) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	changes, err := watchFiles(ctx, watchPaths())
+	if err != nil {
+		return err
+	}
+
+	if blkDev != "" {
+		if _, err := script.Exec(`bash -c  'set -x ; sudo echo "sudo cache" ;  set +x'`).Stdout(); err != nil {
+			return err
+		}
+	}
+
+	mon := &monitor{}
+	defer mon.detach()
+	go mon.forwardStdin()
+
+	for {
+		if err := cycle(ctx, cmd, rewriteSrc, mon); err != nil {
+			out("\x1b[31m" + err.Error() + "\x1b[0m\n")
+		}
+		out(fmt.Sprintf("\x1b[2mwatching %s — ^C to stop\x1b[0m\n", goProg))
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case changed, ok := <-changes:
+			// A closed channel receives at once, forever: without this the
+			// loop would spin rebuilding after the watcher gave out.
+			if !ok {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return errors.New("stopped watching: the file watcher closed")
+			}
+			out(fmt.Sprintf("\n\x1b[1;34mchanged: %s\x1b[0m\n", strings.Join(changed, ", ")))
+			if project != nil && slices.ContainsFunc(changed, func(p string) bool { return samePath(p, project.path) }) {
+				reloadProfile(cmd)
+			}
+		}
+	}
+}
+
+// cycle is one build and, if it worked, one flash.
+func cycle(ctx context.Context, cmd *cobra.Command, rewriteSrc bool, mon *monitor) error {
+	src := goProg
+	if rewriteSrc {
+		tmp, err := writeRewritten(cmd)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp) //nolint:errcheck,gosec // best-effort cleanup of a temp file
+		src = tmp
+	} else if err := checkPins(nil, false); err != nil {
+		return err
+	}
+
+	dir, err := os.MkdirTemp("", "mcu-watch-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir) //nolint:errcheck // best-effort cleanup of a temp dir
+	uf2 := filepath.Join(dir, "firmware.uf2")
+
+	// Expanded again for each build, so a {{now}} is this build's time.
+	if err := checkXFlags(cmd); err != nil {
+		return err
+	}
+	t := target
+	if t == "" {
+		t = "pico"
+	}
+	build := "tinygo build -target=" + t
+	if x := xFlags(cmd); x != "" {
+		build += ` -ldflags="` + x + `" `
+	}
+	build += " -o " + uf2 + " " + src
+	out(build + "\n")
+	start := time.Now()
+	msg, err := script.Exec(`bash -c '` + build + ` 2>&1'`).String()
+	if err != nil {
+		// The rewritten source is a temp file that is about to be deleted;
+		// the errors mean the file the user is editing.
+		msg = strings.ReplaceAll(msg, src, goProg)
+		return fmt.Errorf("build failed; the board is still running the last good firmware\n%s", strings.TrimRight(msg, "\n"))
+	}
+	out(fmt.Sprintf("built in %.1fs\n", time.Since(start).Seconds()))
+
+	if blkDev == "" {
+		out("no --dev given; not flashing\n")
+		return nil
+	}
+
+	// Let go of the port before resetting the board through it.
+	tty := mon.detach()
+	if tty == "" {
+		tty = resolveTTY(ttyUSB)
+	}
+	before := ttys()
+	if bootVolume() == "" && tty != "" {
+		resetToBootloader(tty)
+		delete(before, tty)
+	}
+
+	step, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	dev, err := waitFor(step, func() string {
+		if d := bootVolume(); d != "" {
+			return d
+		}
+		return ""
+	})
+	if err != nil {
+		return fmt.Errorf("no %s volume appeared; is the board connected?", bootLabel)
+	}
+	mp := mountPoint(dev)
+	if mp == "" {
+		if _, err := script.Exec(`udisksctl mount -b ` + dev).Stdout(); err != nil {
+			return err
+		}
+		mp = mountPoint(dev)
+	}
+	if mp == "" {
+		return fmt.Errorf("%s did not mount", dev)
+	}
+	if err := copyFile(uf2, filepath.Join(mp, filepath.Base(uf2))); err != nil {
+		return err
+	}
+	out(fmt.Sprintf("flashed %s\n", dev))
+
+	if ttyUSB == "" {
+		return nil
+	}
+	tty, err = waitFor(step, func() string {
+		if t := newTTY(before); t != "" {
+			return t
+		}
+		return resolveTTY(ttyUSB)
+	})
+	if err != nil {
+		return fmt.Errorf("the board did not come back as a serial device")
+	}
+	time.Sleep(sleepTime)
+	return mon.attach(tty)
+}
+
+// writeRewritten re-reads GOPROG, applies the flags to it as ef does, and
+// writes the result to a temp file. The source is read again every cycle
+// because it is what changed; a flag is only applied where its value is not
+// simply what the source said when mcu started.
+func writeRewritten(cmd *cobra.Command) (string, error) {
+	src, err := goprog.ParseSource(goProg, nil)
+	if err != nil {
+		return "", err
+	}
+	b, err := rewritten(cmd, src)
+	if err != nil {
+		return "", err
+	}
+	if err := checkPins(b, false); err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp("", "*.go")
+	if err != nil {
+		return "", err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close() //nolint:errcheck,gosec // the write error is the one worth reporting
+		return "", err
+	}
+	return tmp.Name(), tmp.Close()
+}
+
+// reloadProfile re-reads the project file and applies the profile again to
+// every flag the command line did not set.
+func reloadProfile(cmd *cobra.Command) {
+	p, err := loadProject(project.path)
+	if err != nil {
+		out("\x1b[31m" + err.Error() + "\x1b[0m\n")
+		return
+	}
+	project = p
+	if profileName == "" {
+		return
+	}
+	pr, err := project.resolve(profileName)
+	if err != nil {
+		out("\x1b[31m" + err.Error() + "\x1b[0m\n")
+		return
+	}
+	if pr.GoProg != "" && project.rel(pr.GoProg) != goProg {
+		out("profile " + profileName + " now names a different goprog; restart to switch programs\n")
+	}
+	profile = pr
+	for name, v := range pr.values() {
+		if f := cmd.Flags().Lookup(name); f != nil && !f.Changed {
+			if err := f.Value.Set(v); err != nil {
+				out(fmt.Sprintf("profile %s: --%s: %v\n", profileName, name, err))
+			}
+		}
+	}
+}
+
+// resolveTTY expands a -m value such as /dev/ttyACM? to the device present now.
+func resolveTTY(pattern string) string {
+	if pattern == "" {
+		return ""
+	}
+	found, err := filepath.Glob(pattern)
+	if err != nil || len(found) == 0 {
+		return ""
+	}
+	return found[0]
+}
+
+// resetToBootloader opens the port at 1200 baud and closes it again, which an
+// RP2040 running TinyGo firmware takes as the signal to reboot into BOOTSEL.
+func resetToBootloader(tty string) {
+	p, err := serial.OpenPort(&serial.Config{Name: tty, Baud: 1200})
+	if err != nil {
+		return
+	}
+	p.Close() //nolint:errcheck,gosec // the close is the signal; the board is gone straight after
+}
+
+// monitor is a serial connection that can be dropped and picked up again
+// without restarting mcu. Reflashing takes the port away and the board comes
+// back on a new one, so the connection cannot be for the life of the process
+// the way it is in mon.
+type monitor struct {
+	mu   sync.Mutex
+	port *serial.Port
+	tty  string
+}
+
+func (m *monitor) attach(tty string) error {
+	var port *serial.Port
+	var err error
+	for i := 0; i < 10; i++ {
+		port, err = serial.OpenPort(&serial.Config{Name: tty, Baud: baud})
+		if err == nil {
+			break
+		}
+		time.Sleep(time.Second)
+	}
+	if err != nil {
+		return fmt.Errorf("serial.OpenPort: %w", err)
+	}
+	m.mu.Lock()
+	m.port, m.tty = port, tty
+	m.mu.Unlock()
+	out(fmt.Sprintf("\x1b[2mattached %s\x1b[0m\n", tty))
+
+	go func() {
+		reader := bufio.NewReader(port)
+		for {
+			data, err := reader.ReadString('\n')
+			if err != nil {
+				// Closed by detach, or the board went away. Either way
+				// the next cycle attaches again.
+				return
+			}
+			fmt.Print(data)
+		}
+	}()
+	return nil
+}
+
+// detach closes the port, if one is open, and returns the tty it was on.
+func (m *monitor) detach() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.port == nil {
+		return ""
+	}
+	m.port.Close() //nolint:errcheck,gosec // the reader goroutine ends on the error this causes
+	tty := m.tty
+	m.port, m.tty = nil, ""
+	return tty
+}
+
+// forwardStdin sends each line typed to whichever port is attached at the time.
+func (m *monitor) forwardStdin() {
+	reader := bufio.NewReader(os.Stdin)
+	for {
+		input, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		m.mu.Lock()
+		if m.port != nil {
+			_, _ = m.port.Write([]byte(strings.TrimSuffix(input, "\n"))) //nolint:errcheck // a write to a board that is going away is not worth stopping for
+		}
+		m.mu.Unlock()
+	}
+}
+
+// samePath reports whether a and b name the same file, however each is
+// written: the watcher reports absolute paths, and MCUCONFIG may be relative.
+func samePath(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	if errA != nil || errB != nil {
+		return filepath.Clean(a) == filepath.Clean(b)
+	}
+	return absA == absB
+}
+
+// coalesce collects the paths arriving on raw until none has arrived for wait,
+// then sends them, sorted and each once, as one change. It ends, closing the
+// channel it returns, when raw is closed or the context is done.
+func coalesce(ctx context.Context, raw <-chan string, wait time.Duration) <-chan []string {
+	changes := make(chan []string)
+	go func() {
+		defer close(changes)
+		pending := map[string]bool{}
+		var fire <-chan time.Time
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case p, ok := <-raw:
+				if !ok {
+					return
+				}
+				pending[p] = true
+				fire = time.After(wait)
+			case <-fire:
+				list := make([]string, 0, len(pending))
+				for p := range pending {
+					list = append(list, p)
+				}
+				sort.Strings(list)
+				pending = map[string]bool{}
+				fire = nil
+				select {
+				case changes <- list:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return changes
+}
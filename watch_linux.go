@@ -0,0 +1,74 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// watchFiles reports changes to the named files in each directory, debounced,
+// as a list of the paths that changed. It stops when the context does.
+func watchFiles(ctx context.Context, dirs map[string]func(name string) bool) (<-chan []string, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	wds := map[int32]string{}
+	for dir := range dirs {
+		wd, err := unix.InotifyAddWatch(fd, dir, unix.IN_CLOSE_WRITE|unix.IN_MOVED_TO|unix.IN_CREATE|unix.IN_DELETE)
+		if err != nil {
+			unix.Close(fd) //nolint:errcheck,gosec // the watch error is the one worth reporting
+			return nil, err
+		}
+		wds[int32(wd)] = dir
+	}
+
+	raw := make(chan string)
+	go func() {
+		defer close(raw)
+		buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+		for {
+			n, err := unix.Read(fd, buf)
+			if err != nil || n <= 0 {
+				return
+			}
+			for off := 0; off+unix.SizeofInotifyEvent <= n; {
+				ev := (*unix.InotifyEvent)(unsafe.Pointer(&buf[off])) //nolint:gosec // the kernel's layout for this buffer
+				name := ""
+				if ev.Len > 0 {
+					b := buf[off+unix.SizeofInotifyEvent : off+unix.SizeofInotifyEvent+int(ev.Len)]
+					for i, c := range b {
+						if c == 0 {
+							b = b[:i]
+							break
+						}
+					}
+					name = string(b)
+				}
+				off += unix.SizeofInotifyEvent + int(ev.Len)
+				dir, ok := wds[ev.Wd]
+				if !ok || name == "" || !dirs[dir](name) {
+					continue
+				}
+				select {
+				case raw <- filepath.Join(dir, name):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	// Closing the descriptor ends the reads above once the kernel has
+	// one more event for them; the changes stop with the context.
+	go func() {
+		<-ctx.Done()
+		unix.Close(fd) //nolint:errcheck,gosec // shutting down
+	}()
+
+	return coalesce(ctx, raw, debounce), nil
+}
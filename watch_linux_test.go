@@ -0,0 +1,54 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// Saving the way editors do, by writing a new file and renaming it over the
+// old, is seen; a file the filter does not want is not.
+func TestWatchFilesSeesASave(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dir := t.TempDir()
+	changes, err := watchFiles(ctx, map[string]func(string) bool{
+		dir: func(name string) bool { return filepath.Ext(name) == ".go" },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmp := filepath.Join(dir, ".main.go.tmp")
+	for name, data := range map[string]string{tmp: "package main\n", filepath.Join(dir, "notes.txt"): "x"} {
+		if err := os.WriteFile(name, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, "main.go")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case got := <-changes:
+		if want := []string{filepath.Join(dir, "main.go")}; !slices.Equal(got, want) {
+			t.Errorf("change %v, want %v", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the save was not seen")
+	}
+
+	cancel()
+	select {
+	case _, ok := <-changes:
+		if ok {
+			t.Error("a change after the watch was stopped")
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("the watch did not stop with its context")
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+// watchFiles needs inotify. The rest of the flash workflow assumes linux too
+// (udisksctl, /dev/ttyACM*), so there is no portable fallback to offer.
+func watchFiles(_ context.Context, _ map[string]func(name string) bool) (<-chan []string, error) {
+	return nil, errors.New("--watch needs inotify, which is linux only")
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// An editor's save is several events; a burst of them is one change, each path
+// in it once.
+func TestCoalesceMakesABurstOneChange(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	raw := make(chan string)
+	changes := coalesce(ctx, raw, 50*time.Millisecond)
+
+	for _, p := range []string{"/src/main.go", "/src/.main.go.swp", "/src/main.go", "/mcu.yaml"} {
+		raw <- p
+	}
+	select {
+	case got := <-changes:
+		if want := []string{"/mcu.yaml", "/src/.main.go.swp", "/src/main.go"}; !slices.Equal(got, want) {
+			t.Errorf("change %v, want %v", got, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no change after the burst")
+	}
+
+	raw <- "/src/pins.go"
+	select {
+	case got := <-changes:
+		if !slices.Equal(got, []string{"/src/pins.go"}) {
+			t.Errorf("second change %v: the first one's paths were kept", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no second change")
+	}
+
+	close(raw)
+	if _, ok := <-changes; ok {
+		t.Error("a change after the events ended")
+	}
+}
+
+// The program's directories take its Go files but not its tests, and the
+// project file's directory takes the project file too, keyed by its absolute
+// path whichever way MCUCONFIG gave it.
+func TestWatchPathsFilterWhatMatters(t *testing.T) {
+	savedProject, savedDirs := project, progDirs
+	t.Cleanup(func() { project, progDirs = savedProject, savedDirs })
+
+	dir := t.TempDir()
+	t.Chdir(dir)
+	progDirs = []string{dir, filepath.Join(dir, "pins")}
+	project = &Project{path: configName}
+
+	paths := watchPaths()
+	if len(paths) != 2 {
+		t.Fatalf("watching %d directories, want 2: the project file is in the program's", len(paths))
+	}
+	for _, tc := range []struct {
+		dir, name string
+		want      bool
+	}{
+		{dir, "main.go", true},
+		{dir, "main_test.go", false},
+		{dir, configName, true},
+		{dir, "mcu.yaml~", false},
+		{dir, "README.md", false},
+		{filepath.Join(dir, "pins"), "pins.go", true},
+		{filepath.Join(dir, "pins"), configName, false},
+	} {
+		if got := paths[tc.dir](tc.name); got != tc.want {
+			t.Errorf("%s in %s: %v", tc.name, tc.dir, got)
+		}
+	}
+	if !samePath(filepath.Join(dir, configName), project.path) {
+		t.Errorf("%s is not the project file %s", filepath.Join(dir, configName), project.path)
+	}
+	if samePath(filepath.Join(dir, "pins", configName), project.path) {
+		t.Error("another directory's mcu.yaml is the project file")
+	}
+}